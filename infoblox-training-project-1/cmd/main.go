@@ -18,38 +18,62 @@ import (
 
 	"github.com/vstarostin/infoblox-training-project-1/internal/config"
 	"github.com/vstarostin/infoblox-training-project-1/internal/handler"
-	"github.com/vstarostin/infoblox-training-project-1/internal/model"
 	"github.com/vstarostin/infoblox-training-project-1/internal/pb"
+	"github.com/vstarostin/infoblox-training-project-1/internal/phonenumber"
 	"github.com/vstarostin/infoblox-training-project-1/internal/repository"
 	"github.com/vstarostin/infoblox-training-project-1/internal/service"
+	"github.com/vstarostin/infoblox-training-project-1/internal/usercsv"
+	"github.com/vstarostin/infoblox-training-project-1/internal/vcard"
 )
 
 func main() {
 	cfg := config.NewConfig()
 
-	db, err := gorm.Open(postgres.Open(cfg.DBConnectionString), &gorm.Config{})
+	phoneNormalizer, err := phonenumber.NewNormalizer(cfg.PhoneRegion)
 	if err != nil {
-		log.Println("DB initializing error")
 		log.Fatal(err)
 	}
 
-	sqlDB, err := db.DB()
-	err = sqlDB.Ping()
-	if err != nil {
-		log.Println("DB pinging error")
-		log.Fatal(err)
-	}
-	defer sqlDB.Close()
-	log.Printf("Database connection successfully opened")
+	var addressBookRepo service.AddressBookStorage
+	switch cfg.Storage {
+	case config.MemoryStorage:
+		addressBookRepo = repository.NewMemoryStorage()
+		log.Println("Using in-memory storage")
+	case config.PostgresStorage:
+		db, err := gorm.Open(postgres.Open(cfg.DBConnectionString), &gorm.Config{})
+		if err != nil {
+			log.Println("DB initializing error")
+			log.Fatal(err)
+		}
+
+		sqlDB, err := db.DB()
+		err = sqlDB.Ping()
+		if err != nil {
+			log.Println("DB pinging error")
+			log.Fatal(err)
+		}
+		defer sqlDB.Close()
+		log.Printf("Database connection successfully opened")
 
-	db.AutoMigrate(&model.User{})
-	log.Println("Database migrated")
+		err = repository.Migrate(db, phoneNormalizer)
+		if err != nil {
+			log.Println("DB migration error")
+			log.Fatal(err)
+		}
+		log.Println("Database migrated")
 
-	addressBookRepo := repository.New(db)
-	addressBookService := service.New(addressBookRepo)
+		addressBookRepo = repository.New(db)
+	default:
+		log.Fatalf("Unknown storage %q, expected %q or %q", cfg.Storage, config.MemoryStorage, config.PostgresStorage)
+	}
+
+	addressBookService := service.New(addressBookRepo, phoneNormalizer)
 	addressBookHandler := handler.New(addressBookService)
 
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(addressBookHandler.AuditInterceptor),
+		grpc.StreamInterceptor(addressBookHandler.AuditStreamInterceptor),
+	)
 	pb.RegisterAddressBookServiceServer(grpcServer, addressBookHandler)
 
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort))
@@ -67,7 +91,12 @@ func main() {
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	mux := runtime.NewServeMux()
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(vcard.ContentType, handler.NewBodyMarshaler(vcard.ContentType)),
+		runtime.WithMarshalerOption(usercsv.ContentType, handler.NewBodyMarshaler(usercsv.ContentType)),
+		runtime.WithOutgoingHeaderMatcher(handler.OutgoingHeaderMatcher),
+		runtime.WithIncomingHeaderMatcher(handler.IncomingHeaderMatcher),
+	)
 	opt := []grpc.DialOption{grpc.WithInsecure()}
 	err = pb.RegisterAddressBookServiceHandlerFromEndpoint(
 		ctx, mux, fmt.Sprintf(":%d", cfg.GRPCPort), opt,
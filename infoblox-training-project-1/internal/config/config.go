@@ -5,15 +5,24 @@ import (
 	"fmt"
 )
 
+const (
+	MemoryStorage   = "memory"
+	PostgresStorage = "postgres"
+)
+
 type Config struct {
 	Port               int
 	GRPCPort           int
+	Storage            string
 	DBConnectionString string
+	PhoneRegion        string
 }
 
 func NewConfig() *Config {
 	port := flag.Int("port", 8080, "GRPC gateway server port")
 	gRPCPort := flag.Int("grpcport", 9090, "GRPC server port")
+	storage := flag.String("storage", PostgresStorage, "storage backend: memory|postgres")
+	phoneRegion := flag.String("phone-region", "RU", "region of phone numbers given without a country code")
 	username := flag.String("username", "postgres", "database user")
 	password := flag.String("password", "password", "database password")
 	host := flag.String("host", "postgres-service", "database host")
@@ -26,6 +35,8 @@ func NewConfig() *Config {
 	return &Config{
 		Port:               *port,
 		GRPCPort:           *gRPCPort,
+		Storage:            *storage,
 		DBConnectionString: dbConn,
+		PhoneRegion:        *phoneRegion,
 	}
 }
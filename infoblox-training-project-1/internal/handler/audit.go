@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+	"github.com/vstarostin/infoblox-training-project-1/internal/pb"
+	"github.com/vstarostin/infoblox-training-project-1/internal/service"
+)
+
+const (
+	// AnonymousActor names callers that send no ActorHeader metadata.
+	AnonymousActor = "anonymous"
+	// ForwardedForHeader is the metadata the gateway passes the address of
+	// its HTTP client in.
+	ForwardedForHeader = "x-forwarded-for"
+)
+
+// auditedMethods are the full names of the RPCs that add, change or delete
+// users or groups. Every other RPC only reads.
+var auditedMethods = map[string]bool{
+	"/pb.AddressBookService/AddUser":            true,
+	"/pb.AddressBookService/ImportUsers":        true,
+	"/pb.AddressBookService/ImportVCard":        true,
+	"/pb.AddressBookService/ImportCSV":          true,
+	"/pb.AddressBookService/DeleteUser":         true,
+	"/pb.AddressBookService/UpdateUser":         true,
+	"/pb.AddressBookService/UpdateUserByID":     true,
+	"/pb.AddressBookService/DeleteUserByID":     true,
+	"/pb.AddressBookService/RestoreUser":        true,
+	"/pb.AddressBookService/PurgeDeletedUsers":  true,
+	"/pb.AddressBookService/MergeUsers":         true,
+	"/pb.AddressBookService/CreateGroup":        true,
+	"/pb.AddressBookService/UpdateGroup":        true,
+	"/pb.AddressBookService/DeleteGroup":        true,
+	"/pb.AddressBookService/AddGroupMembers":    true,
+	"/pb.AddressBookService/RemoveGroupMembers": true,
+	"/pb.AddressBookService/RevertUser":         true,
+}
+
+// AuditInterceptor records every call of the audited unary methods as an
+// audit event once it has completed.
+func (ab *AddressBook) AuditInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (interface{}, error) {
+	if !auditedMethods[info.FullMethod] {
+		return next(ctx, req)
+	}
+	affected := new(int64)
+	resp, err := next(context.WithValue(ctx, affectedKey{}, affected), req)
+	digest := sha256.New()
+	writeMessage(digest, req)
+	ab.record(ctx, info.FullMethod, digest, affectedRows(resp, *affected, err), err)
+	return resp, err
+}
+
+// AuditStreamInterceptor records every call of the audited streaming
+// methods, digesting all messages received from the client.
+func (ab *AddressBook) AuditStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, next grpc.StreamHandler) error {
+	if !auditedMethods[info.FullMethod] {
+		return next(srv, ss)
+	}
+	stream := &auditedStream{ServerStream: ss, digest: sha256.New()}
+	err := next(srv, stream)
+	ab.record(ss.Context(), info.FullMethod, stream.digest, affectedRows(stream.sent, 0, err), err)
+	return err
+}
+
+// record stores the audit event of a completed call. An event that cannot
+// be recorded is logged, the call has already taken effect and keeps its
+// outcome.
+func (ab *AddressBook) record(ctx context.Context, method string, digest hash.Hash, affected int64, err error) {
+	event := model.AuditEvent{
+		Actor:    actor(ctx),
+		Peer:     peerAddress(ctx),
+		Method:   method,
+		Digest:   hex.EncodeToString(digest.Sum(nil)),
+		Code:     status.Code(err).String(),
+		Affected: affected,
+	}
+	if auditErr := ab.service.RecordAuditEvent(event); auditErr != nil {
+		log.Printf("Audit event of %s by %s was not recorded: %v", event.Method, event.Actor, auditErr)
+	}
+}
+
+func (ab *AddressBook) ListAuditEvents(_ context.Context, in *pb.ListAuditEventsRequest) (*pb.ListAuditEventsResponse, error) {
+	opts := service.AuditOptions{
+		Actor:     in.GetActor(),
+		PageSize:  in.GetPageSize(),
+		PageToken: in.GetPageToken(),
+	}
+	if in.GetFrom() != nil {
+		opts.From = in.GetFrom().AsTime()
+	}
+	if in.GetTo() != nil {
+		opts.To = in.GetTo().AsTime()
+	}
+	page, err := ab.service.ListAuditEvents(opts)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	response := &pb.ListAuditEventsResponse{
+		Events:        make([]*pb.AuditEvent, 0, len(page.Events)),
+		NextPageToken: page.NextPageToken,
+	}
+	for _, e := range page.Events {
+		response.Events = append(response.Events, &pb.AuditEvent{
+			Id:            uint64(e.ID),
+			CreatedAt:     timestamppb.New(e.CreatedAt),
+			ClaimedActor:  e.Actor,
+			Peer:          e.Peer,
+			Method:        e.Method,
+			PayloadDigest: e.Digest,
+			Code:          e.Code,
+			AffectedRows:  e.Affected,
+		})
+	}
+	return response, nil
+}
+
+// auditedStream digests the messages received from the client and keeps
+// the last one sent to it, the response of a client streaming call.
+type auditedStream struct {
+	grpc.ServerStream
+	digest hash.Hash
+	sent   interface{}
+}
+
+func (s *auditedStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		writeMessage(s.digest, m)
+	}
+	return err
+}
+
+func (s *auditedStream) SendMsg(m interface{}) error {
+	s.sent = m
+	return s.ServerStream.SendMsg(m)
+}
+
+type affectedKey struct{}
+
+// setAffected reports the rows an audited call affected when its response
+// does not tell them.
+func setAffected(ctx context.Context, n int64) {
+	if affected, ok := ctx.Value(affectedKey{}).(*int64); ok {
+		*affected = n
+	}
+}
+
+// actor returns the first non-empty ActorHeader value of the incoming
+// metadata. The caller sets it freely, it is a claim and not an identity.
+func actor(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(ActorHeader) {
+		if v != "" {
+			return v
+		}
+	}
+	return AnonymousActor
+}
+
+// peerAddress returns the network address of the caller, followed by the
+// addresses the gateway forwarded the call for.
+func peerAddress(ctx context.Context) string {
+	var address string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		address = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if forwarded := md.Get(ForwardedForHeader); len(forwarded) > 0 {
+		address += " for " + forwarded[0]
+	}
+	return address
+}
+
+// writeMessage adds the length prefixed deterministic encoding of a request
+// message to the digest, so that events can be matched with requests
+// without keeping their contacts.
+func writeMessage(digest hash.Hash, m interface{}) {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return
+	}
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(data)))
+	digest.Write(size[:])
+	digest.Write(data)
+}
+
+// affectedRows returns the number of users or groups a successful call
+// added, changed or deleted, from its response or from what the handler
+// reported with setAffected.
+func affectedRows(resp interface{}, reported int64, err error) int64 {
+	if err != nil {
+		return 0
+	}
+	switch resp := resp.(type) {
+	case *pb.AddUserResponse, *pb.UpdateUserResponse, *pb.RestoreUserResponse, *pb.RevertUserResponse,
+		*pb.CreateGroupResponse, *pb.UpdateGroupResponse, *pb.DeleteGroupResponse:
+		return 1
+	case *pb.DeleteUserResponse:
+		return resp.GetDeletedCount()
+	case *pb.PurgeDeletedUsersResponse:
+		return resp.GetPurgedCount()
+	case *pb.MergeUsersResponse:
+		return 1 + int64(len(resp.GetMergedIds()))
+	case *pb.GroupMembersResponse:
+		return resp.GetCount()
+	case *pb.ImportUsersResponse:
+		return int64(resp.GetCreated() + resp.GetUpdated())
+	case *pb.ImportVCardResponse:
+		return int64(resp.GetReport().GetCreated() + resp.GetReport().GetUpdated())
+	}
+	return reported
+}
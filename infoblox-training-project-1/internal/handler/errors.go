@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"errors"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/service"
+)
+
+var serviceErrorCodes = map[service.ErrorKind]codes.Code{
+	service.KindNotFound:           codes.NotFound,
+	service.KindConflict:           codes.AlreadyExists,
+	service.KindValidation:         codes.InvalidArgument,
+	service.KindFailedPrecondition: codes.FailedPrecondition,
+	service.KindUnavailable:        codes.Unavailable,
+	service.KindInternal:           codes.Internal,
+}
+
+// toStatus translates an error returned by AddressBookService into a gRPC status error.
+func toStatus(err error) error {
+	var serviceErr *service.Error
+	if !errors.As(err, &serviceErr) {
+		log.Printf("Unexpected service error: %v", err)
+		return status.Error(codes.Internal, service.ErrInternal)
+	}
+
+	code, ok := serviceErrorCodes[serviceErr.Kind]
+	if !ok {
+		code = codes.Internal
+	}
+	if code == codes.Internal || code == codes.Unavailable {
+		log.Printf("Storage error: %v", serviceErr.Err)
+	}
+	return status.Error(code, serviceErr.Message)
+}
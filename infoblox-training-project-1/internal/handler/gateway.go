@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"io"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// BodyMarshaler lets the gateway accept request bodies of a content type
+// that is not JSON, such as vCard files, as they are. They are decoded into
+// the google.api.HttpBody body field of the request, responses and other
+// messages are JSON like with the default marshaler.
+type BodyMarshaler struct {
+	runtime.HTTPBodyMarshaler
+	contentType string
+}
+
+// NewBodyMarshaler returns the marshaler to register for contentType with
+// runtime.WithMarshalerOption.
+func NewBodyMarshaler(contentType string) *BodyMarshaler {
+	return &BodyMarshaler{
+		HTTPBodyMarshaler: runtime.HTTPBodyMarshaler{
+			Marshaler: &runtime.JSONPb{
+				MarshalOptions:   protojson.MarshalOptions{EmitUnpopulated: true},
+				UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+			},
+		},
+		contentType: contentType,
+	}
+}
+
+func (m *BodyMarshaler) Unmarshal(data []byte, v interface{}) error {
+	if body := m.httpBody(v); body != nil {
+		body.ContentType, body.Data = m.contentType, data
+		return nil
+	}
+	return m.HTTPBodyMarshaler.Unmarshal(data, v)
+}
+
+func (m *BodyMarshaler) NewDecoder(r io.Reader) runtime.Decoder {
+	return runtime.DecoderFunc(func(v interface{}) error {
+		if m.httpBody(v) == nil {
+			return m.HTTPBodyMarshaler.NewDecoder(r).Decode(v)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return m.Unmarshal(data, v)
+	})
+}
+
+// httpBody returns the message v points to if it is a google.api.HttpBody,
+// allocating it for a pointer to a nil field.
+func (m *BodyMarshaler) httpBody(v interface{}) *httpbody.HttpBody {
+	switch v := v.(type) {
+	case *httpbody.HttpBody:
+		return v
+	case **httpbody.HttpBody:
+		if *v == nil {
+			*v = &httpbody.HttpBody{}
+		}
+		return *v
+	}
+	return nil
+}
+
+// OutgoingHeaderMatcher forwards the Content-Disposition of files as is, so
+// that browsers download them, and other metadata like the gateway default.
+func OutgoingHeaderMatcher(key string) (string, bool) {
+	if key == ContentDispositionHeader {
+		return "Content-Disposition", true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+// IncomingHeaderMatcher passes the X-Actor header on as the metadata that
+// names the caller in audit events, and other headers like the gateway
+// default.
+func IncomingHeaderMatcher(key string) (string, bool) {
+	if strings.EqualFold(key, ActorHeader) {
+		return ActorHeader, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
@@ -1,21 +1,46 @@
 package handler
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/vstarostin/infoblox-training-project-1/internal/model"
 	"github.com/vstarostin/infoblox-training-project-1/internal/pb"
+	"github.com/vstarostin/infoblox-training-project-1/internal/service"
+	"github.com/vstarostin/infoblox-training-project-1/internal/usercsv"
+	"github.com/vstarostin/infoblox-training-project-1/internal/vcard"
 )
 
 const (
-	AddUserMethodResponse    = "successfully added"
-	UpdateUserMethodResponse = "user was successfully updated"
-	ErrUpdateUserMethod      = "please provide full phone number, address or name"
+	AddUserMethodResponse     = "successfully added"
+	UpdateUserMethodResponse  = "user was successfully updated"
+	RestoreUserMethodResponse = "user was successfully restored"
+	MergeUsersMethodResponse  = "users were successfully merged"
+	CreateGroupMethodResponse = "group was successfully created"
+	UpdateGroupMethodResponse = "group was successfully updated"
+	RevertUserMethodResponse  = "user was successfully reverted"
+	ErrUpdateUserMethod       = "please provide full phone number, address or name"
+	ErrInvalidVCard           = "invalid vCard file: %v"
+	ErrInvalidCSV             = "invalid CSV file: %v"
+
+	NextPageTokenHeader      = "next-page-token"
+	TotalSizeHeader          = "total-size"
+	ImportedRowsHeader       = "imported-rows"
+	RejectedRowsHeader       = "rejected-rows"
+	ContentDispositionHeader = "content-disposition"
+	ActorHeader              = "x-actor"
 )
 
 type AddressBook struct {
@@ -24,11 +49,33 @@ type AddressBook struct {
 }
 
 type AddressBookService interface {
-	AddUser(name, phone, address string) error
-	ListUsers() ([]model.User, error)
-	DeleteUser(name string) (string, error)
-	FindUser(name, phone, address string) ([]model.User, error)
-	UpdateUser(phone string, updatedUser model.User) error
+	AddUser(user model.User) (model.User, error)
+	ListUsers(opts service.ListOptions) (service.Page, error)
+	DeleteUser(name string, opts service.DeleteOptions) (service.DeleteResult, error)
+	FindUser(filter model.User, expression string, opts service.ListOptions) (service.Page, error)
+	SearchUsers(query string, limit int32) ([]model.SearchHit, error)
+	ExportUsers(expression string, send func(model.User) error) error
+	ImportUsers(onConflict string, next func() (service.ImportRow, error)) (service.ImportReport, error)
+	UpdateUser(phone string, updatedUser model.User, fields []string) (model.User, error)
+	GetUser(id uint) (model.User, error)
+	UpdateUserByID(id uint, updatedUser model.User, fields []string) (model.User, error)
+	DeleteUserByID(id uint) (string, error)
+	ListDeletedUsers(opts service.ListOptions) (service.Page, error)
+	RestoreUser(id uint) (model.User, error)
+	PurgeDeletedUsers(olderThan time.Time) (string, int64, error)
+	FindDuplicates(minScore float64, limit int32) ([]model.DuplicateGroup, error)
+	MergeUsers(ids []uint, survivor uint, resolution map[string]string) (model.User, []uint, error)
+	AddGroup(group model.Group) (model.Group, error)
+	ListGroups() ([]model.Group, error)
+	GetGroup(id uint) (model.Group, error)
+	UpdateGroup(id uint, updatedGroup model.Group, fields []string) (model.Group, error)
+	DeleteGroup(id uint) (string, error)
+	AddGroupMembers(id uint, userIDs []uint) (string, int64, error)
+	RemoveGroupMembers(id uint, userIDs []uint) (string, int64, error)
+	GetUserHistory(id uint) ([]service.Revision, error)
+	RevertUser(id, revision uint) (model.User, error)
+	RecordAuditEvent(event model.AuditEvent) error
+	ListAuditEvents(opts service.AuditOptions) (service.AuditPage, error)
 }
 
 func New(service AddressBookService) *AddressBook {
@@ -36,63 +83,298 @@ func New(service AddressBookService) *AddressBook {
 }
 
 func (ab *AddressBook) AddUser(_ context.Context, in *pb.AddUserRequest) (*pb.AddUserResponse, error) {
-	name := format(in.GetNewUser().GetUserName())
-	address := format(in.GetNewUser().GetAddress())
-	phone := format(in.GetNewUser().GetPhone())
-
-	err := ab.service.AddUser(name, phone, address)
+	if err := validateAddUser(in); err != nil {
+		return nil, err
+	}
+	user, err := ab.service.AddUser(toModelUser(in.GetNewUser()))
 	if err != nil {
-		return nil, status.Error(codes.AlreadyExists, err.Error())
+		return nil, toStatus(err)
 	}
 
 	return &pb.AddUserResponse{
 		Response: AddUserMethodResponse,
+		User:     toPBUser(user),
 	}, nil
 }
 
-func (ab *AddressBook) ListUsers(_ context.Context, _ *empty.Empty) (*pb.ListUsersResponse, error) {
-	users, err := ab.service.ListUsers()
+func (ab *AddressBook) ListUsers(_ context.Context, in *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	page, err := ab.service.ListUsers(listOptions(in))
 	if err != nil {
-		return nil, status.Error(codes.NotFound, err.Error())
+		return nil, toStatus(err)
 	}
 
-	response := &pb.ListUsersResponse{Users: make([]*pb.User, 0)}
-	for _, u := range users {
-		response.Users = append(response.Users, &pb.User{
-			UserName: u.Name,
-			Phone:    u.Phone,
-			Address:  u.Address,
-		})
-	}
-	return response, nil
+	return &pb.ListUsersResponse{
+		Users:         toPBUsers(page.Users),
+		NextPageToken: page.NextPageToken,
+		TotalSize:     page.TotalSize,
+	}, nil
 }
 
 func (ab *AddressBook) DeleteUser(_ context.Context, in *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
-	incomingNamePattern := format(in.GetUserName())
-	response, err := ab.service.DeleteUser(incomingNamePattern)
+	incomingNamePattern := in.GetUserName()
+	opts := service.DeleteOptions{
+		DryRun:        in.GetDryRun(),
+		AllowBulk:     in.GetAllowBulk(),
+		ExpectedCount: in.GetExpectedCount(),
+	}
+	result, err := ab.service.DeleteUser(incomingNamePattern, opts)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, toStatus(err)
 	}
 
-	return &pb.DeleteUserResponse{Response: response}, nil
+	return &pb.DeleteUserResponse{Response: result.Response, Users: toPBUsers(result.Users), DeletedCount: result.Deleted}, nil
 }
 
 func (ab *AddressBook) FindUser(_ context.Context, in *pb.FindUserRequest) (*pb.FindUserResponse, error) {
-	name := format(in.GetName())
-	phone := format(in.GetPhone())
-	address := format(in.GetAddress())
+	if err := validateFindUser(in); err != nil {
+		return nil, err
+	}
+	page, err := ab.service.FindUser(findFilter(in), in.GetFilter(), listOptions(in))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.FindUserResponse{
+		Users:         toPBUsers(page.Users),
+		NextPageToken: page.NextPageToken,
+		TotalSize:     page.TotalSize,
+	}, nil
+}
+
+func (ab *AddressBook) FindUserCSV(ctx context.Context, in *pb.FindUserRequest) (*httpbody.HttpBody, error) {
+	if err := validateFindUser(in); err != nil {
+		return nil, err
+	}
+	page, err := ab.service.FindUser(findFilter(in), in.GetFilter(), listOptions(in))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return csvPage(ctx, page)
+}
+
+func (ab *AddressBook) ListUsersCSV(ctx context.Context, in *pb.ListUsersRequest) (*httpbody.HttpBody, error) {
+	page, err := ab.service.ListUsers(listOptions(in))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return csvPage(ctx, page)
+}
+
+// csvPage returns the users of a page as a CSV file and sends the next page
+// token and the total size as header metadata.
+func csvPage(ctx context.Context, page service.Page) (*httpbody.HttpBody, error) {
+	data, err := usercsv.Marshal(page.Users)
+	if err != nil {
+		return nil, toStatus(service.Internal(err))
+	}
+	md := metadata.Pairs(
+		NextPageTokenHeader, page.NextPageToken,
+		TotalSizeHeader, strconv.FormatInt(page.TotalSize, 10),
+		ContentDispositionHeader, `attachment; filename="users.csv"`,
+	)
+	if err := grpc.SetHeader(ctx, md); err != nil {
+		return nil, err
+	}
+	return &httpbody.HttpBody{ContentType: usercsv.ContentType, Data: data}, nil
+}
+
+// ImportCSV adds the rows of a CSV file with AddUser semantics: rows whose
+// phones are taken are rejected. The response is the error report of the
+// rejected rows. A file without a usable header fails with
+// InvalidArgument.
+func (ab *AddressBook) ImportCSV(ctx context.Context, in *pb.ImportCSVRequest) (*httpbody.HttpBody, error) {
+	reader, err := usercsv.NewReader(bytes.NewReader(in.GetCsv().GetData()), in.GetMapping())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, ErrInvalidCSV, err)
+	}
+
+	var rows []usercsv.Row
+	next := func() (service.ImportRow, error) {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return service.ImportRow{}, err
+		}
+		if err != nil {
+			return service.ImportRow{}, status.Errorf(codes.InvalidArgument, ErrInvalidCSV, err)
+		}
+		rows = append(rows, row)
+		if row.Err != nil {
+			return service.ImportRow{Err: service.Invalid("%s", row.Err)}, nil
+		}
+		user := toPBUser(row.User)
+		result := service.ImportRow{User: toModelUser(user)}
+		if reason := validateDecodedUser(user); reason != "" {
+			result.Err = service.Invalid("%s", reason)
+		}
+		return result, nil
+	}
+	report, err := ab.service.ImportUsers(model.OnConflictFail, next)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, toStatus(err)
+	}
+
+	var rejected []usercsv.Rejected
+	for _, r := range report.Rows {
+		if r.Status != model.ImportCreated {
+			rejected = append(rejected, usercsv.Rejected{Row: rows[r.Row], Reason: r.Reason})
+		}
+	}
+	data, err := usercsv.MarshalRejected(reader.Header(), rejected)
+	if err != nil {
+		return nil, toStatus(service.Internal(err))
+	}
+	md := metadata.Pairs(
+		ImportedRowsHeader, strconv.Itoa(report.Created),
+		RejectedRowsHeader, strconv.Itoa(len(rejected)),
+		ContentDispositionHeader, `attachment; filename="rejected.csv"`,
+	)
+	if err := grpc.SetHeader(ctx, md); err != nil {
+		return nil, err
+	}
+	setAffected(ctx, int64(report.Created))
+	return &httpbody.HttpBody{ContentType: usercsv.ContentType, Data: data}, nil
+}
+
+func (ab *AddressBook) SearchUsers(_ context.Context, in *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	if err := validateSearchUsers(in); err != nil {
+		return nil, err
+	}
+	hits, err := ab.service.SearchUsers(in.GetQuery(), in.GetPageSize())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	response := &pb.SearchUsersResponse{Hits: make([]*pb.SearchHit, 0, len(hits))}
+	for _, hit := range hits {
+		response.Hits = append(response.Hits, &pb.SearchHit{User: toPBUser(hit.User), Score: hit.Score})
+	}
+	return response, nil
+}
+
+func (ab *AddressBook) ExportUsers(in *pb.ExportUsersRequest, stream pb.AddressBookService_ExportUsersServer) error {
+	if err := validateExportUsers(in); err != nil {
+		return err
+	}
+	var sendErr error
+	err := ab.service.ExportUsers(in.GetFilter(), func(user model.User) error {
+		sendErr = stream.Send(toPBUser(user))
+		return sendErr
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+func (ab *AddressBook) ImportUsers(stream pb.AddressBookService_ImportUsersServer) error {
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return stream.SendAndClose(&pb.ImportUsersResponse{})
+	}
+	if err != nil {
+		return err
+	}
+	onConflict, ok := onConflictModes[first.GetOnConflict()]
+	if !ok {
+		onConflict = first.GetOnConflict().String()
+	}
 
-	usersFromDB, err := ab.service.FindUser(name, phone, address)
+	next := func() (service.ImportRow, error) {
+		in := first
+		first = nil
+		if in == nil {
+			var err error
+			if in, err = stream.Recv(); err != nil {
+				return service.ImportRow{}, err
+			}
+		}
+		row := service.ImportRow{User: toModelUser(in.GetUser())}
+		if reason := validateImportUser(in); reason != "" {
+			row.Err = service.Invalid("%s", reason)
+		}
+		return row, nil
+	}
+	report, err := ab.service.ImportUsers(onConflict, next)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		// Errors of the stream itself already are statuses.
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return toStatus(err)
+	}
+
+	return stream.SendAndClose(toPBImportReport(report))
+}
+
+func (ab *AddressBook) ExportVCard(in *pb.ExportVCardRequest, stream pb.AddressBookService_ExportVCardServer) error {
+	if err := validateExportVCard(in); err != nil {
+		return err
+	}
+	version := in.GetVersion()
+	if version == "" {
+		version = vcard.Version4
+	}
+	var sendErr error
+	err := ab.service.ExportUsers(in.GetFilter(), func(user model.User) error {
+		data, err := vcard.Marshal(user, version)
+		if err != nil {
+			return err
+		}
+		sendErr = stream.Send(&httpbody.HttpBody{ContentType: vcard.ContentType, Data: data})
+		return sendErr
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+// ImportVCard imports every vCard of the file as a row. A malformed file
+// stops the import with InvalidArgument, keeping the batches stored before.
+func (ab *AddressBook) ImportVCard(_ context.Context, in *pb.ImportVCardRequest) (*pb.ImportVCardResponse, error) {
+	onConflict, ok := onConflictModes[in.GetOnConflict()]
+	if !ok {
+		onConflict = in.GetOnConflict().String()
+	}
+
+	decoder := vcard.NewDecoder(bytes.NewReader(in.GetVcard().GetData()))
+	var unmapped []*pb.UnmappedProperties
+	row := 0
+	next := func() (service.ImportRow, error) {
+		card, err := decoder.Decode()
+		if err == io.EOF {
+			return service.ImportRow{}, err
+		}
+		if err != nil {
+			return service.ImportRow{}, status.Errorf(codes.InvalidArgument, ErrInvalidVCard, err)
+		}
+		if len(card.Unmapped) > 0 {
+			unmapped = append(unmapped, &pb.UnmappedProperties{Row: int32(row), Properties: card.Unmapped})
+		}
+		row++
+		// The round trip trims and cases the fields like other requests.
+		user := toPBUser(card.User)
+		result := service.ImportRow{User: toModelUser(user)}
+		if reason := validateDecodedUser(user); reason != "" {
+			result.Err = service.Invalid("%s", reason)
+		}
+		return result, nil
 	}
-	var users []*pb.User
-	for _, u := range usersFromDB {
-		user := &pb.User{}
-		user.UserName, user.Address, user.Phone = u.Name, u.Address, u.Phone
-		users = append(users, user)
+	report, err := ab.service.ImportUsers(onConflict, next)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, toStatus(err)
 	}
-	return &pb.FindUserResponse{Users: users}, nil
+	return &pb.ImportVCardResponse{Report: toPBImportReport(report), Unmapped: unmapped}, nil
 }
 
 func (ab *AddressBook) UpdateUser(_ context.Context, in *pb.UpdateUserRequest) (*pb.UpdateUserResponse, error) {
@@ -100,21 +382,512 @@ func (ab *AddressBook) UpdateUser(_ context.Context, in *pb.UpdateUserRequest) (
 	if strings.Contains(phone, "*") {
 		return nil, status.Error(codes.InvalidArgument, ErrUpdateUserMethod)
 	}
-	newUserName := format(in.GetUpdatedUser().GetUserName())
-	newAddress := format(in.GetUpdatedUser().GetAddress())
-	newPhone := format(in.GetUpdatedUser().GetPhone())
-	updatedUser := model.User{Name: newUserName, Phone: newPhone, Address: newAddress}
-	err := ab.service.UpdateUser(phone, updatedUser)
+	if err := validateUpdateUser(in); err != nil {
+		return nil, err
+	}
+	updatedUser := toModelUser(in.GetUpdatedUser())
+	fields := updateMaskFields(in.GetUpdateMask().GetPaths())
+	user, err := ab.service.UpdateUser(phone, updatedUser, fields)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &pb.UpdateUserResponse{
+		Response:    UpdateUserMethodResponse,
+		UpdatedUser: toPBUser(user),
+	}, nil
+}
+
+func (ab *AddressBook) GetUser(_ context.Context, in *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	user, err := ab.service.GetUser(uint(in.GetId()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.GetUserResponse{User: toPBUser(user)}, nil
+}
+
+func (ab *AddressBook) UpdateUserByID(_ context.Context, in *pb.UpdateUserByIDRequest) (*pb.UpdateUserResponse, error) {
+	if err := validateUpdateUserByID(in); err != nil {
+		return nil, err
+	}
+	updatedUser := toModelUser(in.GetUpdatedUser())
+	fields := updateMaskFields(in.GetUpdateMask().GetPaths())
+	user, err := ab.service.UpdateUserByID(uint(in.GetId()), updatedUser, fields)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, toStatus(err)
 	}
 
 	return &pb.UpdateUserResponse{
 		Response:    UpdateUserMethodResponse,
-		UpdatedUser: in.GetUpdatedUser(),
+		UpdatedUser: toPBUser(user),
 	}, nil
 }
 
+func (ab *AddressBook) DeleteUserByID(_ context.Context, in *pb.DeleteUserByIDRequest) (*pb.DeleteUserResponse, error) {
+	response, err := ab.service.DeleteUserByID(uint(in.GetId()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.DeleteUserResponse{Response: response, DeletedCount: 1}, nil
+}
+
+func (ab *AddressBook) ListDeletedUsers(_ context.Context, in *pb.ListDeletedUsersRequest) (*pb.ListDeletedUsersResponse, error) {
+	opts := service.ListOptions{PageSize: in.GetPageSize(), PageToken: in.GetPageToken()}
+	page, err := ab.service.ListDeletedUsers(opts)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &pb.ListDeletedUsersResponse{
+		Users:         toPBUsers(page.Users),
+		NextPageToken: page.NextPageToken,
+		TotalSize:     page.TotalSize,
+	}, nil
+}
+
+func (ab *AddressBook) RestoreUser(_ context.Context, in *pb.RestoreUserRequest) (*pb.RestoreUserResponse, error) {
+	user, err := ab.service.RestoreUser(uint(in.GetId()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.RestoreUserResponse{Response: RestoreUserMethodResponse, User: toPBUser(user)}, nil
+}
+
+func (ab *AddressBook) PurgeDeletedUsers(_ context.Context, in *pb.PurgeDeletedUsersRequest) (*pb.PurgeDeletedUsersResponse, error) {
+	var olderThan time.Time
+	if in.GetOlderThan() != nil {
+		olderThan = in.GetOlderThan().AsTime()
+	}
+	response, purged, err := ab.service.PurgeDeletedUsers(olderThan)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.PurgeDeletedUsersResponse{Response: response, PurgedCount: purged}, nil
+}
+
+func (ab *AddressBook) FindDuplicates(_ context.Context, in *pb.FindDuplicatesRequest) (*pb.FindDuplicatesResponse, error) {
+	groups, err := ab.service.FindDuplicates(in.GetMinScore(), in.GetPageSize())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	response := &pb.FindDuplicatesResponse{Groups: make([]*pb.DuplicateGroup, 0, len(groups))}
+	for _, g := range groups {
+		response.Groups = append(response.Groups, &pb.DuplicateGroup{Users: toPBUsers(g.Users), Reasons: g.Reasons, Score: g.Score})
+	}
+	return response, nil
+}
+
+func (ab *AddressBook) MergeUsers(_ context.Context, in *pb.MergeUsersRequest) (*pb.MergeUsersResponse, error) {
+	ids := toIDs(in.GetIds())
+	resolution := make(map[string]string, len(in.GetResolution()))
+	for field, strategy := range in.GetResolution() {
+		modelStrategy, ok := mergeStrategies[strategy]
+		if !ok {
+			modelStrategy = strategy.String()
+		}
+		resolution[mergeField(field)] = modelStrategy
+	}
+
+	user, merged, err := ab.service.MergeUsers(ids, uint(in.GetSurvivorId()), resolution)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	response := &pb.MergeUsersResponse{Response: MergeUsersMethodResponse, User: toPBUser(user)}
+	for _, id := range merged {
+		response.MergedIds = append(response.MergedIds, uint64(id))
+	}
+	return response, nil
+}
+
+func (ab *AddressBook) CreateGroup(_ context.Context, in *pb.CreateGroupRequest) (*pb.CreateGroupResponse, error) {
+	if err := validateCreateGroup(in); err != nil {
+		return nil, err
+	}
+	group, err := ab.service.AddGroup(toModelGroup(in.GetGroup()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.CreateGroupResponse{Response: CreateGroupMethodResponse, Group: toPBGroup(group)}, nil
+}
+
+func (ab *AddressBook) ListGroups(_ context.Context, _ *pb.ListGroupsRequest) (*pb.ListGroupsResponse, error) {
+	groups, err := ab.service.ListGroups()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	response := &pb.ListGroupsResponse{Groups: make([]*pb.Group, 0, len(groups))}
+	for _, g := range groups {
+		response.Groups = append(response.Groups, toPBGroup(g))
+	}
+	return response, nil
+}
+
+func (ab *AddressBook) GetGroup(_ context.Context, in *pb.GetGroupRequest) (*pb.GetGroupResponse, error) {
+	group, err := ab.service.GetGroup(uint(in.GetId()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.GetGroupResponse{Group: toPBGroup(group)}, nil
+}
+
+func (ab *AddressBook) UpdateGroup(_ context.Context, in *pb.UpdateGroupRequest) (*pb.UpdateGroupResponse, error) {
+	if err := validateUpdateGroup(in); err != nil {
+		return nil, err
+	}
+	group, err := ab.service.UpdateGroup(uint(in.GetId()), toModelGroup(in.GetGroup()), in.GetUpdateMask().GetPaths())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.UpdateGroupResponse{Response: UpdateGroupMethodResponse, Group: toPBGroup(group)}, nil
+}
+
+func (ab *AddressBook) DeleteGroup(_ context.Context, in *pb.DeleteGroupRequest) (*pb.DeleteGroupResponse, error) {
+	response, err := ab.service.DeleteGroup(uint(in.GetId()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.DeleteGroupResponse{Response: response}, nil
+}
+
+func (ab *AddressBook) AddGroupMembers(_ context.Context, in *pb.GroupMembersRequest) (*pb.GroupMembersResponse, error) {
+	response, added, err := ab.service.AddGroupMembers(uint(in.GetId()), toIDs(in.GetUserIds()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.GroupMembersResponse{Response: response, Count: added}, nil
+}
+
+func (ab *AddressBook) RemoveGroupMembers(_ context.Context, in *pb.GroupMembersRequest) (*pb.GroupMembersResponse, error) {
+	response, removed, err := ab.service.RemoveGroupMembers(uint(in.GetId()), toIDs(in.GetUserIds()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.GroupMembersResponse{Response: response, Count: removed}, nil
+}
+
+func (ab *AddressBook) GetUserHistory(_ context.Context, in *pb.GetUserHistoryRequest) (*pb.GetUserHistoryResponse, error) {
+	revisions, err := ab.service.GetUserHistory(uint(in.GetId()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	response := &pb.GetUserHistoryResponse{Revisions: make([]*pb.UserRevision, 0, len(revisions))}
+	for _, r := range revisions {
+		response.Revisions = append(response.Revisions, toPBRevision(r))
+	}
+	return response, nil
+}
+
+func (ab *AddressBook) RevertUser(_ context.Context, in *pb.RevertUserRequest) (*pb.RevertUserResponse, error) {
+	user, err := ab.service.RevertUser(uint(in.GetId()), uint(in.GetRevision()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.RevertUserResponse{Response: RevertUserMethodResponse, User: toPBUser(user)}, nil
+}
+
+// mergeField translates a pb.User field name of a merge resolution into a
+// model field name. The legacy address is merged with the postal address.
+// Unknown names are passed through for the service to reject.
+func mergeField(name string) string {
+	switch name {
+	case "userName", "user_name":
+		return model.FieldName
+	case "postalAddress", "postal_address", "address":
+		return model.FieldPostalAddress
+	}
+	return name
+}
+
+// maskFields are the model names of pb.User fields named differently.
+var maskFields = map[string]string{
+	"userName":      model.FieldName,
+	"postalAddress": model.FieldPostalAddress,
+}
+
+// updateMaskFields translates pb.User field mask paths, with JSON or
+// snake_case names, into model field names without duplicates. A path into
+// a message field, like the ones grpc-gateway infers from a PATCH body, e.g.
+// postalAddress.city, writes the whole field. Unknown paths are passed
+// through for the service to reject.
+func updateMaskFields(paths []string) []string {
+	fields := make([]string, 0, len(paths))
+	seen := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		field := maskField(path)
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func maskField(path string) string {
+	names := strings.Split(path, ".")
+	fields := (&pb.User{}).ProtoReflect().Descriptor().Fields()
+	var top protoreflect.FieldDescriptor
+	for i, name := range names {
+		fd := fields.ByJSONName(jsonName(name))
+		if fd == nil {
+			return path
+		}
+		if i == 0 {
+			top = fd
+		}
+		if i < len(names)-1 {
+			if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+				return path
+			}
+			fields = fd.Message().Fields()
+		}
+	}
+	if field, ok := maskFields[top.JSONName()]; ok {
+		return field
+	}
+	return top.JSONName()
+}
+
+// jsonName turns a snake_case field name into its JSON name.
+func jsonName(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+var (
+	phoneTypes = map[pb.PhoneType]string{
+		pb.PhoneType_PHONE_TYPE_UNSPECIFIED: "",
+		pb.PhoneType_PHONE_TYPE_MOBILE:      model.PhoneMobile,
+		pb.PhoneType_PHONE_TYPE_HOME:        model.PhoneHome,
+		pb.PhoneType_PHONE_TYPE_WORK:        model.PhoneWork,
+	}
+	onConflictModes = map[pb.OnConflict]string{
+		pb.OnConflict_ON_CONFLICT_UNSPECIFIED: "",
+		pb.OnConflict_ON_CONFLICT_FAIL:        model.OnConflictFail,
+		pb.OnConflict_ON_CONFLICT_SKIP:        model.OnConflictSkip,
+		pb.OnConflict_ON_CONFLICT_OVERWRITE:   model.OnConflictOverwrite,
+	}
+	importStatuses = map[string]pb.ImportStatus{
+		model.ImportCreated: pb.ImportStatus_IMPORT_STATUS_CREATED,
+		model.ImportUpdated: pb.ImportStatus_IMPORT_STATUS_UPDATED,
+		model.ImportSkipped: pb.ImportStatus_IMPORT_STATUS_SKIPPED,
+		model.ImportFailed:  pb.ImportStatus_IMPORT_STATUS_FAILED,
+	}
+	mergeStrategies = map[pb.MergeStrategy]string{
+		pb.MergeStrategy_MERGE_STRATEGY_UNSPECIFIED: "",
+		pb.MergeStrategy_MERGE_STRATEGY_SURVIVOR:    model.MergeSurvivor,
+		pb.MergeStrategy_MERGE_STRATEGY_NEWEST:      model.MergeNewest,
+		pb.MergeStrategy_MERGE_STRATEGY_LONGEST:     model.MergeLongest,
+		pb.MergeStrategy_MERGE_STRATEGY_UNION:       model.MergeUnion,
+	}
+	emailTypes = map[pb.EmailType]string{
+		pb.EmailType_EMAIL_TYPE_UNSPECIFIED: "",
+		pb.EmailType_EMAIL_TYPE_HOME:        model.EmailHome,
+		pb.EmailType_EMAIL_TYPE_WORK:        model.EmailWork,
+	}
+)
+
+// listRequest is a request for a sorted page of users.
+type listRequest interface {
+	GetPageSize() int32
+	GetPageToken() string
+	GetOrderBy() string
+	GetGroup() string
+}
+
+func listOptions(in listRequest) service.ListOptions {
+	return service.ListOptions{
+		PageSize:  in.GetPageSize(),
+		PageToken: in.GetPageToken(),
+		OrderBy:   in.GetOrderBy(),
+		Group:     in.GetGroup(),
+	}
+}
+
+// findFilter converts the fields of a FindUser request into a model user
+// to match against, trimming all of them but the name and address.
+func findFilter(in *pb.FindUserRequest) model.User {
+	return model.User{
+		Name:    in.GetName(),
+		Phone:   strings.TrimSpace(in.GetPhone()),
+		Address: in.GetAddress(),
+		PostalAddress: model.PostalAddress{
+			Street:      strings.TrimSpace(in.GetStreet()),
+			City:        strings.TrimSpace(in.GetCity()),
+			Region:      strings.TrimSpace(in.GetRegion()),
+			PostalCode:  strings.TrimSpace(in.GetPostalCode()),
+			CountryCode: strings.TrimSpace(in.GetCountryCode()),
+		},
+		Attributes: in.GetAttributes(),
+	}
+}
+
+// toModelUser converts an incoming user. Names and addresses are kept as they
+// were sent, the service derives search keys from them. Unknown phone and
+// email types are passed through for the service to reject.
+func toModelUser(u *pb.User) model.User {
+	user := model.User{
+		Name:    u.GetUserName(),
+		Phone:   strings.TrimSpace(u.GetPhone()),
+		Address: u.GetAddress(),
+		PostalAddress: model.PostalAddress{
+			Street:      strings.TrimSpace(u.GetPostalAddress().GetStreet()),
+			City:        strings.TrimSpace(u.GetPostalAddress().GetCity()),
+			Region:      strings.TrimSpace(u.GetPostalAddress().GetRegion()),
+			PostalCode:  strings.TrimSpace(u.GetPostalAddress().GetPostalCode()),
+			CountryCode: strings.ToUpper(strings.TrimSpace(u.GetPostalAddress().GetCountryCode())),
+		},
+		Attributes: u.GetAttributes(),
+	}
+	for _, p := range u.GetPhones() {
+		phoneType, ok := phoneTypes[p.GetType()]
+		if !ok {
+			phoneType = p.GetType().String()
+		}
+		user.Phones = append(user.Phones, model.PhoneNumber{Number: strings.TrimSpace(p.GetNumber()), Type: phoneType, Primary: p.GetPrimary()})
+	}
+	for _, e := range u.GetEmails() {
+		emailType, ok := emailTypes[e.GetType()]
+		if !ok {
+			emailType = e.GetType().String()
+		}
+		user.Emails = append(user.Emails, model.Email{Address: format(e.GetAddress()), Type: emailType, Primary: e.GetPrimary()})
+	}
+	return user
+}
+
+func toPBUser(u model.User) *pb.User {
+	user := &pb.User{
+		Id:           uint64(u.ID),
+		UserName:     u.Name,
+		Phone:        u.Phone,
+		PhoneDisplay: u.PhoneDisplay,
+		Address:      u.Address,
+		Attributes:   u.Attributes,
+	}
+	if u.DeletedAt.Valid {
+		user.DeletedAt = timestamppb.New(u.DeletedAt.Time)
+	}
+	if a := u.PostalAddress; !a.IsZero() {
+		user.PostalAddress = &pb.PostalAddress{
+			Street:      a.Street,
+			City:        a.City,
+			Region:      a.Region,
+			PostalCode:  a.PostalCode,
+			CountryCode: a.CountryCode,
+		}
+	}
+	for _, p := range u.Phones {
+		user.Phones = append(user.Phones, &pb.PhoneNumber{
+			Number:  p.Number,
+			Display: p.Display,
+			Type:    toPBPhoneType(p.Type),
+			Primary: p.Primary,
+		})
+	}
+	for _, e := range u.Emails {
+		user.Emails = append(user.Emails, &pb.Email{Address: e.Address, Type: toPBEmailType(e.Type), Primary: e.Primary})
+	}
+	for _, g := range u.Groups {
+		user.Groups = append(user.Groups, g.Name)
+	}
+	return user
+}
+
+func toPBRevision(r service.Revision) *pb.UserRevision {
+	user := r.Snapshot.User()
+	user.ID = r.UserID
+	user.Normalize()
+	revision := &pb.UserRevision{
+		Revision:  uint64(r.Revision),
+		Action:    r.Action,
+		CreatedAt: timestamppb.New(r.CreatedAt),
+		User:      toPBUser(user),
+	}
+	for _, field := range r.Changed {
+		switch field {
+		case model.FieldName:
+			revision.ChangedFields = append(revision.ChangedFields, "userName")
+		case model.FieldPostalAddress:
+			revision.ChangedFields = append(revision.ChangedFields, "postalAddress")
+		default:
+			revision.ChangedFields = append(revision.ChangedFields, field)
+		}
+	}
+	return revision
+}
+
+func toModelGroup(g *pb.Group) model.Group {
+	return model.Group{Name: g.GetName(), Description: strings.TrimSpace(g.GetDescription())}
+}
+
+func toPBGroup(g model.Group) *pb.Group {
+	return &pb.Group{
+		Id:          uint64(g.ID),
+		Name:        g.Name,
+		Description: g.Description,
+		CreatedAt:   timestamppb.New(g.CreatedAt),
+		UpdatedAt:   timestamppb.New(g.UpdatedAt),
+	}
+}
+
+func toIDs(ids []uint64) []uint {
+	converted := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		converted = append(converted, uint(id))
+	}
+	return converted
+}
+
+func toPBImportReport(report service.ImportReport) *pb.ImportUsersResponse {
+	response := &pb.ImportUsersResponse{
+		Results: make([]*pb.ImportResult, 0, len(report.Rows)),
+		Created: int32(report.Created),
+		Updated: int32(report.Updated),
+		Skipped: int32(report.Skipped),
+		Failed:  int32(report.Failed),
+	}
+	for _, r := range report.Rows {
+		response.Results = append(response.Results, &pb.ImportResult{
+			Row:    int32(r.Row),
+			Status: importStatuses[r.Status],
+			Id:     uint64(r.ID),
+			Reason: r.Reason,
+		})
+	}
+	return response
+}
+
+func toPBPhoneType(t string) pb.PhoneType {
+	for pbType, modelType := range phoneTypes {
+		if modelType == t {
+			return pbType
+		}
+	}
+	return pb.PhoneType_PHONE_TYPE_UNSPECIFIED
+}
+
+func toPBEmailType(t string) pb.EmailType {
+	for pbType, modelType := range emailTypes {
+		if modelType == t {
+			return pbType
+		}
+	}
+	return pb.EmailType_EMAIL_TYPE_UNSPECIFIED
+}
+
+func toPBUsers(users []model.User) []*pb.User {
+	pbUsers := make([]*pb.User, 0, len(users))
+	for _, u := range users {
+		pbUsers = append(pbUsers, toPBUser(u))
+	}
+	return pbUsers
+}
+
 func format(s string) string {
 	return strings.ToLower(strings.Trim(s, " "))
 }
@@ -2,29 +2,53 @@ package handler_test
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
 	"testing"
+	"time"
 
+	testifymock "github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/genproto/protobuf/field_mask"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
 
 	"github.com/vstarostin/infoblox-training-project-1/internal/handler"
 	"github.com/vstarostin/infoblox-training-project-1/internal/mock"
 	"github.com/vstarostin/infoblox-training-project-1/internal/model"
 	"github.com/vstarostin/infoblox-training-project-1/internal/pb"
+	"github.com/vstarostin/infoblox-training-project-1/internal/service"
+	"github.com/vstarostin/infoblox-training-project-1/internal/usercsv"
+	"github.com/vstarostin/infoblox-training-project-1/internal/vcard"
 )
 
 var (
 	name, phone, address = "name", "phone", "address"
 	err                  = errors.New("some error")
+	notFoundErr          = service.NotFound(service.ErrUserDoesNotExist)
+	conflictErr          = service.Conflict(service.ErrPhoneIsTaken, phone)
+	internalErr          = service.Internal(err)
 	user                 = &pb.User{UserName: name, Phone: phone, Address: address}
 	users                = []*pb.User{user}
 	modelUser            = model.User{Name: name, Phone: phone, Address: address}
 	modelUsers           = []model.User{modelUser}
 	emptyModelUsers      = []model.User{}
 	responseOK           = "OK"
+	listOptions          = service.ListOptions{PageSize: 10, PageToken: "token"}
 )
 
 type handlerTestSuite struct {
@@ -52,18 +76,23 @@ func (suite *handlerTestSuite) TestHandlerAddUser() {
 	}{
 		"without_error": {
 			serviceResponse:  nil,
-			expectedResponse: &pb.AddUserResponse{Response: handler.AddUserMethodResponse},
+			expectedResponse: &pb.AddUserResponse{Response: handler.AddUserMethodResponse, User: user},
 			expectedErr:      nil,
 		},
-		"error": {
-			serviceResponse:  err,
+		"conflict": {
+			serviceResponse:  conflictErr,
 			expectedResponse: nil,
-			expectedErr:      status.Error(codes.AlreadyExists, err.Error()),
+			expectedErr:      status.Error(codes.AlreadyExists, conflictErr.Error()),
+		},
+		"storage_error": {
+			serviceResponse:  internalErr,
+			expectedResponse: nil,
+			expectedErr:      status.Error(codes.Internal, service.ErrInternal),
 		},
 	}
 	for testCase, test := range tests {
 		suite.Run(testCase, func() {
-			suite.service.On("AddUser", name, phone, address).Once().Return(test.serviceResponse)
+			suite.service.On("AddUser", modelUser).Once().Return(modelUser, test.serviceResponse)
 			gotResponse, err := suite.handler.AddUser(context.Background(), &pb.AddUserRequest{NewUser: user})
 			suite.Equal(test.expectedResponse, gotResponse)
 			suite.Equal(test.expectedErr, err)
@@ -71,30 +100,87 @@ func (suite *handlerTestSuite) TestHandlerAddUser() {
 	}
 }
 
+func (suite *handlerTestSuite) TestHandlerAddUserKeepsCasing() {
+	newUser := model.User{Name: "John McDonald", Phone: phone, Address: "New York"}
+	suite.service.On("AddUser", newUser).Once().Return(newUser, nil)
+
+	gotResponse, err := suite.handler.AddUser(context.Background(), &pb.AddUserRequest{NewUser: &pb.User{
+		UserName: "John McDonald",
+		Phone:    " " + phone,
+		Address:  "New York",
+	}})
+	suite.NoError(err)
+	suite.Equal("John McDonald", gotResponse.GetUser().GetUserName())
+	suite.Equal("New York", gotResponse.GetUser().GetAddress())
+}
+
+func (suite *handlerTestSuite) TestHandlerAddUserContacts() {
+	newUser := model.User{
+		Name: name,
+		Phones: []model.PhoneNumber{
+			{Number: "1-111", Type: model.PhoneWork},
+			{Number: "2-222", Primary: true},
+		},
+		Emails: []model.Email{{Address: "name@example.com", Type: model.EmailHome, Primary: true}},
+	}
+	stored := newUser
+	stored.Phone = "2-222"
+	stored.Phones = []model.PhoneNumber{
+		{Number: "1-111", Type: model.PhoneWork},
+		{Number: "2-222", Type: model.PhoneMobile, Primary: true},
+	}
+	suite.service.On("AddUser", newUser).Once().Return(stored, nil)
+
+	gotResponse, err := suite.handler.AddUser(context.Background(), &pb.AddUserRequest{NewUser: &pb.User{
+		UserName: name,
+		Phones: []*pb.PhoneNumber{
+			{Number: " 1-111 ", Type: pb.PhoneType_PHONE_TYPE_WORK},
+			{Number: "2-222", Primary: true},
+		},
+		Emails: []*pb.Email{{Address: "Name@Example.com", Type: pb.EmailType_EMAIL_TYPE_HOME, Primary: true}},
+	}})
+	suite.NoError(err)
+	suite.Equal(&pb.User{
+		UserName: name,
+		Phone:    "2-222",
+		Phones: []*pb.PhoneNumber{
+			{Number: "1-111", Type: pb.PhoneType_PHONE_TYPE_WORK},
+			{Number: "2-222", Type: pb.PhoneType_PHONE_TYPE_MOBILE, Primary: true},
+		},
+		Emails: []*pb.Email{{Address: "name@example.com", Type: pb.EmailType_EMAIL_TYPE_HOME, Primary: true}},
+	}, gotResponse.GetUser())
+}
+
 func (suite *handlerTestSuite) TestHandlerListUsers() {
 	tests := map[string]struct {
-		serviceUsersResponse []model.User
-		serviceErrResponse   error
-		expectedResponse     *pb.ListUsersResponse
-		expectedErr          error
+		servicePageResponse service.Page
+		serviceErrResponse  error
+		expectedResponse    *pb.ListUsersResponse
+		expectedErr         error
 	}{
 		"without_error": {
-			serviceUsersResponse: modelUsers,
-			serviceErrResponse:   nil,
-			expectedResponse:     &pb.ListUsersResponse{Users: users},
-			expectedErr:          nil,
+			servicePageResponse: service.Page{Users: modelUsers, NextPageToken: "next", TotalSize: 11},
+			serviceErrResponse:  nil,
+			expectedResponse:    &pb.ListUsersResponse{Users: users, NextPageToken: "next", TotalSize: 11},
+			expectedErr:         nil,
+		},
+		"empty": {
+			servicePageResponse: service.Page{Users: emptyModelUsers},
+			serviceErrResponse:  nil,
+			expectedResponse:    &pb.ListUsersResponse{Users: []*pb.User{}},
+			expectedErr:         nil,
 		},
-		"error": {
-			serviceUsersResponse: emptyModelUsers,
-			serviceErrResponse:   err,
-			expectedResponse:     nil,
-			expectedErr:          status.Error(codes.NotFound, err.Error()),
+		"storage_error": {
+			servicePageResponse: service.Page{},
+			serviceErrResponse:  internalErr,
+			expectedResponse:    nil,
+			expectedErr:         status.Error(codes.Internal, service.ErrInternal),
 		},
 	}
 	for testCase, test := range tests {
 		suite.Run(testCase, func() {
-			suite.service.On("ListUsers").Once().Return(test.serviceUsersResponse, test.serviceErrResponse)
-			gotResponse, err := suite.handler.ListUsers(context.Background(), &emptypb.Empty{})
+			suite.service.On("ListUsers", listOptions).Once().Return(test.servicePageResponse, test.serviceErrResponse)
+			gotResponse, err := suite.handler.ListUsers(context.Background(), &pb.ListUsersRequest{PageSize: 10, PageToken: "token"})
 			suite.Equal(test.expectedResponse, gotResponse)
 			suite.Equal(test.expectedErr, err)
 		})
@@ -102,29 +188,39 @@ func (suite *handlerTestSuite) TestHandlerListUsers() {
 }
 
 func (suite *handlerTestSuite) TestHandlerDeleteUser() {
+	opts := service.DeleteOptions{DryRun: true, AllowBulk: true, ExpectedCount: 2}
 	tests := map[string]struct {
-		serviceResponse  string
+		serviceResponse  service.DeleteResult
 		serviceErr       error
 		expectedResponse *pb.DeleteUserResponse
 		expectedErr      error
 	}{
 		"without_error": {
-			serviceResponse:  responseOK,
+			serviceResponse:  service.DeleteResult{Response: responseOK, Users: modelUsers},
 			serviceErr:       nil,
-			expectedResponse: &pb.DeleteUserResponse{Response: responseOK},
+			expectedResponse: &pb.DeleteUserResponse{Response: responseOK, Users: users},
 			expectedErr:      nil,
 		},
-		"error": {
-			serviceResponse:  "",
-			serviceErr:       err,
+		"not_found": {
+			serviceErr:       notFoundErr,
+			expectedResponse: nil,
+			expectedErr:      status.Error(codes.NotFound, notFoundErr.Error()),
+		},
+		"bulk_not_allowed": {
+			serviceErr:       service.FailedPrecondition(service.ErrBulkDeleteNotAllowed, 2, name),
 			expectedResponse: nil,
-			expectedErr:      status.Error(codes.InvalidArgument, err.Error()),
+			expectedErr:      status.Error(codes.FailedPrecondition, fmt.Sprintf(service.ErrBulkDeleteNotAllowed, 2, name)),
 		},
 	}
 	for testCase, test := range tests {
 		suite.Run(testCase, func() {
-			suite.service.On("DeleteUser", name).Once().Return(test.serviceResponse, test.serviceErr)
-			gotResponse, err := suite.handler.DeleteUser(context.Background(), &pb.DeleteUserRequest{UserName: name})
+			suite.service.On("DeleteUser", name, opts).Once().Return(test.serviceResponse, test.serviceErr)
+			gotResponse, err := suite.handler.DeleteUser(context.Background(), &pb.DeleteUserRequest{
+				UserName:      name,
+				DryRun:        true,
+				AllowBulk:     true,
+				ExpectedCount: 2,
+			})
 			suite.Equal(test.expectedResponse, gotResponse)
 			suite.Equal(test.expectedErr, err)
 		})
@@ -133,34 +229,404 @@ func (suite *handlerTestSuite) TestHandlerDeleteUser() {
 
 func (suite *handlerTestSuite) TestHandlerFindUser() {
 	tests := map[string]struct {
-		serviceResponse  []model.User
+		serviceResponse  service.Page
 		serviceErr       error
 		expectedResponse *pb.FindUserResponse
 		expectedErr      error
 	}{
 		"without_error": {
-			serviceResponse:  modelUsers,
+			serviceResponse:  service.Page{Users: modelUsers, NextPageToken: "next", TotalSize: 11},
 			serviceErr:       nil,
-			expectedResponse: &pb.FindUserResponse{Users: users},
+			expectedResponse: &pb.FindUserResponse{Users: users, NextPageToken: "next", TotalSize: 11},
 			expectedErr:      nil,
 		},
-		"error": {
-			serviceResponse:  emptyModelUsers,
-			serviceErr:       err,
+		"not_found": {
+			serviceResponse:  service.Page{},
+			serviceErr:       notFoundErr,
 			expectedResponse: nil,
-			expectedErr:      status.Error(codes.InvalidArgument, err.Error()),
+			expectedErr:      status.Error(codes.NotFound, notFoundErr.Error()),
 		},
 	}
 	for testCase, test := range tests {
 		suite.Run(testCase, func() {
-			suite.service.On("FindUser", name, "", "").Once().Return(test.serviceResponse, test.serviceErr)
-			gotResponse, err := suite.handler.FindUser(context.Background(), &pb.FindUserRequest{Name: name})
+			suite.service.On("FindUser", model.User{Name: name}, "", listOptions).Once().Return(test.serviceResponse, test.serviceErr)
+			gotResponse, err := suite.handler.FindUser(context.Background(), &pb.FindUserRequest{Name: name, PageSize: 10, PageToken: "token"})
 			suite.Equal(test.expectedResponse, gotResponse)
 			suite.Equal(test.expectedErr, err)
 		})
 	}
 }
 
+func (suite *handlerTestSuite) TestHandlerFindUserByAddress() {
+	filter := model.User{PostalAddress: model.PostalAddress{City: "New York", CountryCode: "us"}}
+	suite.service.On("FindUser", filter, "", service.ListOptions{}).Once().Return(service.Page{}, notFoundErr)
+
+	_, err := suite.handler.FindUser(context.Background(), &pb.FindUserRequest{City: " New York ", CountryCode: "us"})
+	suite.Equal(status.Error(codes.NotFound, notFoundErr.Error()), err)
+}
+
+func (suite *handlerTestSuite) TestHandlerFindUserFilter() {
+	filter := `name:jo* AND (city:"new york" OR phone:+1*)`
+	invalidErr := service.Invalid(service.ErrInvalidFilter, `unknown field "age" at position 1`)
+	suite.service.On("FindUser", model.User{}, filter, service.ListOptions{}).Once().Return(service.Page{Users: []model.User{modelUser}, TotalSize: 1}, nil)
+	suite.service.On("FindUser", model.User{}, "age:3", service.ListOptions{}).Once().Return(service.Page{}, invalidErr)
+
+	gotResponse, err := suite.handler.FindUser(context.Background(), &pb.FindUserRequest{Filter: filter})
+	suite.NoError(err)
+	suite.Equal(int64(1), gotResponse.GetTotalSize())
+
+	_, err = suite.handler.FindUser(context.Background(), &pb.FindUserRequest{Filter: "age:3"})
+	suite.Equal(status.Error(codes.InvalidArgument, invalidErr.Error()), err)
+}
+
+func (suite *handlerTestSuite) TestHandlerOrderBy() {
+	opts := service.ListOptions{PageSize: 10, OrderBy: "name desc"}
+	suite.service.On("ListUsers", opts).Once().Return(service.Page{Users: modelUsers, TotalSize: 1}, nil)
+	suite.service.On("FindUser", model.User{Name: name}, "", opts).Once().Return(service.Page{Users: modelUsers, TotalSize: 1}, nil)
+
+	_, err := suite.handler.ListUsers(context.Background(), &pb.ListUsersRequest{PageSize: 10, OrderBy: "name desc"})
+	suite.NoError(err)
+	_, err = suite.handler.FindUser(context.Background(), &pb.FindUserRequest{Name: name, PageSize: 10, OrderBy: "name desc"})
+	suite.NoError(err)
+}
+
+// exportStream records the users sent by ExportUsers.
+type exportStream struct {
+	grpc.ServerStream
+	users []*pb.User
+}
+
+func (s *exportStream) Send(u *pb.User) error {
+	s.users = append(s.users, u)
+	return nil
+}
+
+func (suite *handlerTestSuite) TestHandlerExportUsers() {
+	suite.service.On("ExportUsers", "name:jo*", testifymock.Anything).Once().Run(func(args testifymock.Arguments) {
+		send := args.Get(1).(func(model.User) error)
+		_ = send(modelUser)
+		_ = send(modelUser)
+	}).Return(nil)
+	suite.service.On("ExportUsers", "", testifymock.Anything).Once().Return(internalErr)
+
+	stream := &exportStream{}
+	suite.NoError(suite.handler.ExportUsers(&pb.ExportUsersRequest{Filter: "name:jo*"}, stream))
+	suite.Require().Len(stream.users, 2)
+	suite.Equal(name, stream.users[0].GetUserName())
+
+	err := suite.handler.ExportUsers(&pb.ExportUsersRequest{}, &exportStream{})
+	suite.Equal(status.Error(codes.Internal, service.ErrInternal), err)
+}
+
+// importStream feeds requests to ImportUsers and records its response.
+type importStream struct {
+	grpc.ServerStream
+	requests []*pb.ImportUsersRequest
+	response *pb.ImportUsersResponse
+}
+
+func (s *importStream) Recv() (*pb.ImportUsersRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+	in := s.requests[0]
+	s.requests = s.requests[1:]
+	return in, nil
+}
+
+func (s *importStream) SendAndClose(response *pb.ImportUsersResponse) error {
+	s.response = response
+	return nil
+}
+
+func (suite *handlerTestSuite) TestHandlerImportUsers() {
+	report := service.ImportReport{
+		Rows: []service.ImportRowResult{
+			{Row: 0, Status: model.ImportCreated, ID: 7},
+			{Row: 1, Status: model.ImportFailed, Reason: "user.userName: must not be empty"},
+		},
+		Created: 1,
+		Failed:  1,
+	}
+	var rows []service.ImportRow
+	suite.service.On("ImportUsers", model.OnConflictSkip, testifymock.Anything).Once().Run(func(args testifymock.Arguments) {
+		next := args.Get(1).(func() (service.ImportRow, error))
+		for {
+			row, err := next()
+			if err != nil {
+				return
+			}
+			rows = append(rows, row)
+		}
+	}).Return(report, nil)
+
+	stream := &importStream{requests: []*pb.ImportUsersRequest{
+		{User: user, OnConflict: pb.OnConflict_ON_CONFLICT_SKIP},
+		{User: &pb.User{Phone: phone}},
+	}}
+	suite.NoError(suite.handler.ImportUsers(stream))
+	suite.Equal([]service.ImportRow{
+		{User: modelUser},
+		{User: model.User{Phone: phone}, Err: service.Invalid("%s", "user.userName: must not be empty")},
+	}, rows)
+	suite.Equal(&pb.ImportUsersResponse{
+		Results: []*pb.ImportResult{
+			{Row: 0, Status: pb.ImportStatus_IMPORT_STATUS_CREATED, Id: 7},
+			{Row: 1, Status: pb.ImportStatus_IMPORT_STATUS_FAILED, Reason: "user.userName: must not be empty"},
+		},
+		Created: 1,
+		Failed:  1,
+	}, stream.response)
+}
+
+func (suite *handlerTestSuite) TestHandlerImportUsersEmpty() {
+	stream := &importStream{}
+	suite.NoError(suite.handler.ImportUsers(stream))
+	suite.Equal(&pb.ImportUsersResponse{}, stream.response)
+}
+
+// vcardStream records the vCards sent by ExportVCard.
+type vcardStream struct {
+	grpc.ServerStream
+	bodies []*httpbody.HttpBody
+}
+
+func (s *vcardStream) Send(b *httpbody.HttpBody) error {
+	s.bodies = append(s.bodies, b)
+	return nil
+}
+
+func (suite *handlerTestSuite) TestHandlerExportVCard() {
+	suite.service.On("ExportUsers", "name:jo*", testifymock.Anything).Once().Run(func(args testifymock.Arguments) {
+		send := args.Get(1).(func(model.User) error)
+		_ = send(modelUser)
+	}).Return(nil)
+	suite.service.On("ExportUsers", "", testifymock.Anything).Once().Return(internalErr)
+
+	stream := &vcardStream{}
+	suite.NoError(suite.handler.ExportVCard(&pb.ExportVCardRequest{Filter: "name:jo*", Version: vcard.Version3}, stream))
+	expected, _ := vcard.Marshal(modelUser, vcard.Version3)
+	suite.Equal([]*httpbody.HttpBody{{ContentType: vcard.ContentType, Data: expected}}, stream.bodies)
+
+	err := suite.handler.ExportVCard(&pb.ExportVCardRequest{}, &vcardStream{})
+	suite.Equal(status.Error(codes.Internal, service.ErrInternal), err)
+}
+
+func (suite *handlerTestSuite) TestHandlerImportVCard() {
+	report := service.ImportReport{
+		Rows: []service.ImportRowResult{
+			{Row: 0, Status: model.ImportCreated, ID: 7},
+			{Row: 1, Status: model.ImportFailed, Reason: "user.userName: must not be empty"},
+		},
+		Created: 1,
+		Failed:  1,
+	}
+	var rows []service.ImportRow
+	suite.service.On("ImportUsers", model.OnConflictOverwrite, testifymock.Anything).Once().Run(func(args testifymock.Arguments) {
+		next := args.Get(1).(func() (service.ImportRow, error))
+		for {
+			row, err := next()
+			if err != nil {
+				return
+			}
+			rows = append(rows, row)
+		}
+	}).Return(report, nil)
+
+	data := "BEGIN:VCARD\r\nVERSION:4.0\r\nFN:John\r\nTEL:+78129878899\r\nEMAIL: John@Example.com\r\nBDAY:1980-01-01\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:4.0\r\nTEL:+78129878800\r\nEND:VCARD\r\n"
+	gotResponse, err := suite.handler.ImportVCard(context.Background(), &pb.ImportVCardRequest{
+		Vcard:      &httpbody.HttpBody{ContentType: vcard.ContentType, Data: []byte(data)},
+		OnConflict: pb.OnConflict_ON_CONFLICT_OVERWRITE,
+	})
+	suite.NoError(err)
+	suite.Equal([]service.ImportRow{
+		{User: model.User{
+			Name:   "John",
+			Phones: []model.PhoneNumber{{Number: "+78129878899"}},
+			Emails: []model.Email{{Address: "john@example.com"}},
+		}},
+		{
+			User: model.User{Phones: []model.PhoneNumber{{Number: "+78129878800"}}},
+			Err:  service.Invalid("%s", "user.userName: must not be empty"),
+		},
+	}, rows)
+	suite.Equal([]*pb.UnmappedProperties{{Row: 0, Properties: []string{"BDAY"}}}, gotResponse.GetUnmapped())
+	suite.Equal(int32(1), gotResponse.GetReport().GetCreated())
+	suite.Equal(pb.ImportStatus_IMPORT_STATUS_FAILED, gotResponse.GetReport().GetResults()[1].GetStatus())
+}
+
+func (suite *handlerTestSuite) TestHandlerImportVCardMalformed() {
+	expectedErr := status.Error(codes.InvalidArgument, "invalid vCard file: line 1: expected BEGIN:VCARD")
+	suite.service.On("ImportUsers", "", testifymock.Anything).Once().Run(func(args testifymock.Arguments) {
+		next := args.Get(1).(func() (service.ImportRow, error))
+		_, err := next()
+		suite.Equal(expectedErr, err)
+	}).Return(service.ImportReport{}, expectedErr)
+
+	_, err := suite.handler.ImportVCard(context.Background(), &pb.ImportVCardRequest{
+		Vcard: &httpbody.HttpBody{Data: []byte("FN:John\r\n")},
+	})
+	suite.Equal(expectedErr, err)
+}
+
+// headerStream records the header metadata set by unary handlers.
+type headerStream struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (suite *handlerTestSuite) TestHandlerUsersCSV() {
+	page := service.Page{Users: modelUsers, NextPageToken: "next", TotalSize: 3}
+	suite.service.On("ListUsers", listOptions).Once().Return(page, nil)
+	suite.service.On("FindUser", model.User{Name: "jo*"}, "city:paris", service.ListOptions{}).Once().Return(page, nil)
+	suite.service.On("FindUser", model.User{}, "", service.ListOptions{}).Once().Return(service.Page{}, notFoundErr)
+	expected, _ := usercsv.Marshal(modelUsers)
+
+	stream := &headerStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	gotResponse, err := suite.handler.ListUsersCSV(ctx, &pb.ListUsersRequest{PageSize: 10, PageToken: "token"})
+	suite.NoError(err)
+	suite.Equal(&httpbody.HttpBody{ContentType: usercsv.ContentType, Data: expected}, gotResponse)
+	suite.Equal([]string{"next"}, stream.header.Get(handler.NextPageTokenHeader))
+	suite.Equal([]string{"3"}, stream.header.Get(handler.TotalSizeHeader))
+
+	gotResponse, err = suite.handler.FindUserCSV(ctx, &pb.FindUserRequest{Name: "jo*", Filter: "city:paris"})
+	suite.NoError(err)
+	suite.Equal(expected, gotResponse.GetData())
+
+	_, err = suite.handler.FindUserCSV(ctx, &pb.FindUserRequest{})
+	suite.Equal(status.Error(codes.NotFound, notFoundErr.Error()), err)
+}
+
+func (suite *handlerTestSuite) TestHandlerImportCSV() {
+	var rows []service.ImportRow
+	suite.service.On("ImportUsers", model.OnConflictFail, testifymock.Anything).Once().Run(func(args testifymock.Arguments) {
+		next := args.Get(1).(func() (service.ImportRow, error))
+		for {
+			row, err := next()
+			if err != nil {
+				return
+			}
+			rows = append(rows, row)
+		}
+	}).Return(service.ImportReport{
+		Rows: []service.ImportRowResult{
+			{Row: 0, Status: model.ImportCreated, ID: 1},
+			{Row: 1, Status: model.ImportFailed, Reason: "user.userName: must not be empty"},
+			{Row: 2, Status: model.ImportFailed, Reason: "user with phone +78121112233 already exists"},
+		},
+		Created: 1,
+		Failed:  2,
+	}, nil)
+
+	data := "Full Name,Mobile,Notes\n" +
+		"John,+78129878899,friend\n" +
+		",+78129878800,\n" +
+		"Jack,+78121112233,\n"
+	stream := &headerStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	gotResponse, err := suite.handler.ImportCSV(ctx, &pb.ImportCSVRequest{
+		Csv:     &httpbody.HttpBody{ContentType: usercsv.ContentType, Data: []byte(data)},
+		Mapping: map[string]string{"Full Name": model.FieldName, "Mobile": usercsv.FieldMobilePhone},
+	})
+	suite.NoError(err)
+	suite.Equal([]service.ImportRow{
+		{User: model.User{Name: "John", Phones: []model.PhoneNumber{{Number: "+78129878899", Type: model.PhoneMobile}}}},
+		{
+			User: model.User{Phones: []model.PhoneNumber{{Number: "+78129878800", Type: model.PhoneMobile}}},
+			Err:  service.Invalid("%s", "user.userName: must not be empty"),
+		},
+		{User: model.User{Name: "Jack", Phones: []model.PhoneNumber{{Number: "+78121112233", Type: model.PhoneMobile}}}},
+	}, rows)
+	suite.Equal(usercsv.ContentType, gotResponse.GetContentType())
+	suite.Equal("line,error,Full Name,Mobile,Notes\n"+
+		"3,user.userName: must not be empty,,+78129878800,\n"+
+		"4,user with phone +78121112233 already exists,Jack,+78121112233,\n", string(gotResponse.GetData()))
+	suite.Equal([]string{"1"}, stream.header.Get(handler.ImportedRowsHeader))
+	suite.Equal([]string{"2"}, stream.header.Get(handler.RejectedRowsHeader))
+}
+
+func (suite *handlerTestSuite) TestHandlerImportCSVInvalidMapping() {
+	_, err := suite.handler.ImportCSV(context.Background(), &pb.ImportCSVRequest{
+		Csv:     &httpbody.HttpBody{Data: []byte("Name\n")},
+		Mapping: map[string]string{"Name": "nickname"},
+	})
+	suite.Equal(status.Error(codes.InvalidArgument, `invalid CSV file: invalid column mapping: unknown field "nickname" for column "Name"`), err)
+}
+
+func (suite *handlerTestSuite) TestBodyMarshaler() {
+	m := handler.NewBodyMarshaler(vcard.ContentType)
+
+	var in pb.ImportVCardRequest
+	suite.NoError(m.NewDecoder(strings.NewReader("BEGIN:VCARD")).Decode(&in.Vcard))
+	suite.Equal(vcard.ContentType, in.GetVcard().GetContentType())
+	suite.Equal("BEGIN:VCARD", string(in.GetVcard().GetData()))
+
+	var other pb.User
+	suite.NoError(m.NewDecoder(strings.NewReader(`{"userName": "john"}`)).Decode(&other))
+	suite.Equal("john", other.GetUserName())
+}
+
+func (suite *handlerTestSuite) TestHandlerSearchUsers() {
+	suite.service.On("SearchUsers", "jhon", int32(5)).Once().Return([]model.SearchHit{{User: modelUser, Score: 0.5}}, nil)
+	suite.service.On("SearchUsers", "zzz", int32(0)).Once().Return(nil, notFoundErr)
+
+	gotResponse, err := suite.handler.SearchUsers(context.Background(), &pb.SearchUsersRequest{Query: "jhon", PageSize: 5})
+	suite.NoError(err)
+	suite.Require().Len(gotResponse.GetHits(), 1)
+	suite.Equal(name, gotResponse.GetHits()[0].GetUser().GetUserName())
+	suite.Equal(0.5, gotResponse.GetHits()[0].GetScore())
+
+	_, err = suite.handler.SearchUsers(context.Background(), &pb.SearchUsersRequest{Query: "zzz"})
+	suite.Equal(status.Error(codes.NotFound, notFoundErr.Error()), err)
+}
+
+func (suite *handlerTestSuite) TestHandlerPostalAddress() {
+	postal := model.PostalAddress{Street: "5th Avenue 1", City: "New York", Region: "NY", PostalCode: "10001", CountryCode: "US"}
+	newUser := model.User{Name: name, Phone: phone, PostalAddress: postal}
+	stored := newUser
+	stored.Address = postal.Format()
+	suite.service.On("AddUser", newUser).Once().Return(stored, nil)
+
+	gotResponse, err := suite.handler.AddUser(context.Background(), &pb.AddUserRequest{NewUser: &pb.User{
+		UserName: name,
+		Phone:    phone,
+		PostalAddress: &pb.PostalAddress{
+			Street:      " 5th Avenue 1",
+			City:        "New York",
+			Region:      "NY",
+			PostalCode:  "10001",
+			CountryCode: "us",
+		},
+	}})
+	suite.NoError(err)
+	suite.Equal("5th Avenue 1, New York, NY, 10001, US", gotResponse.GetUser().GetAddress())
+	suite.Equal("New York", gotResponse.GetUser().GetPostalAddress().GetCity())
+
+	suite.service.On("UpdateUserByID", uint(7), model.User{}, []string{model.FieldPostalAddress}).Once().Return(stored, nil)
+	_, err = suite.handler.UpdateUserByID(context.Background(), &pb.UpdateUserByIDRequest{
+		Id:         7,
+		UpdateMask: &field_mask.FieldMask{Paths: []string{"postalAddress"}},
+	})
+	suite.NoError(err)
+
+	// grpc-gateway infers the leaf paths of a PATCH body as the mask.
+	boston := model.User{PostalAddress: model.PostalAddress{City: "Boston", CountryCode: "US"}}
+	suite.service.On("UpdateUserByID", uint(7), boston, []string{model.FieldPostalAddress}).Once().Return(stored, nil)
+	_, err = suite.handler.UpdateUserByID(context.Background(), &pb.UpdateUserByIDRequest{
+		Id:          7,
+		UpdatedUser: &pb.User{PostalAddress: &pb.PostalAddress{City: "Boston", CountryCode: "US"}},
+		UpdateMask:  &field_mask.FieldMask{Paths: []string{"postalAddress.city", "postalAddress.countryCode"}},
+	})
+	suite.NoError(err)
+}
+
 func (suite *handlerTestSuite) TestHandlerUpdateUser() {
 	tests := map[string]struct {
 		serviceResponse  error
@@ -172,18 +638,768 @@ func (suite *handlerTestSuite) TestHandlerUpdateUser() {
 			expectedResponse: &pb.UpdateUserResponse{Response: handler.UpdateUserMethodResponse, UpdatedUser: user},
 			expectedErr:      nil,
 		},
-		"error": {
-			serviceResponse:  err,
+		"conflict": {
+			serviceResponse:  conflictErr,
+			expectedResponse: nil,
+			expectedErr:      status.Error(codes.AlreadyExists, conflictErr.Error()),
+		},
+		"not_found": {
+			serviceResponse:  notFoundErr,
 			expectedResponse: nil,
-			expectedErr:      status.Error(codes.InvalidArgument, err.Error()),
+			expectedErr:      status.Error(codes.NotFound, notFoundErr.Error()),
 		},
 	}
 	for testCase, test := range tests {
 		suite.Run(testCase, func() {
-			suite.service.On("UpdateUser", phone, modelUser).Once().Return(test.serviceResponse)
+			suite.service.On("UpdateUser", phone, modelUser, []string{}).Once().Return(modelUser, test.serviceResponse)
 			gotResponse, err := suite.handler.UpdateUser(context.Background(), &pb.UpdateUserRequest{Phone: phone, UpdatedUser: user})
 			suite.Equal(test.expectedResponse, gotResponse)
 			suite.Equal(test.expectedErr, err)
 		})
 	}
 }
+
+func (suite *handlerTestSuite) TestHandlerUpdateUserMask() {
+	updated := model.User{Name: "new name", Phone: phone, Address: "new address"}
+	partial := model.User{Name: "new name", Address: "new address"}
+	suite.service.On("UpdateUser", phone, partial, []string{model.FieldName, model.FieldAddress}).Once().Return(updated, nil)
+
+	gotResponse, err := suite.handler.UpdateUser(context.Background(), &pb.UpdateUserRequest{
+		Phone:       phone,
+		UpdatedUser: &pb.User{UserName: "new name", Address: "new address"},
+		UpdateMask:  &field_mask.FieldMask{Paths: []string{"userName", "address"}},
+	})
+	suite.NoError(err)
+	suite.Equal(&pb.User{UserName: "new name", Phone: phone, Address: "new address"}, gotResponse.GetUpdatedUser())
+}
+
+func (suite *handlerTestSuite) TestHandlerGetUser() {
+	storedUser := model.User{Model: gorm.Model{ID: 7}, Name: name, Phone: phone, Address: address}
+	tests := map[string]struct {
+		serviceResponse  model.User
+		serviceErr       error
+		expectedResponse *pb.GetUserResponse
+		expectedErr      error
+	}{
+		"without_error": {
+			serviceResponse:  storedUser,
+			expectedResponse: &pb.GetUserResponse{User: &pb.User{Id: 7, UserName: name, Phone: phone, Address: address}},
+		},
+		"not_found": {
+			serviceErr:  notFoundErr,
+			expectedErr: status.Error(codes.NotFound, notFoundErr.Error()),
+		},
+	}
+	for testCase, test := range tests {
+		suite.Run(testCase, func() {
+			suite.service.On("GetUser", uint(7)).Once().Return(test.serviceResponse, test.serviceErr)
+			gotResponse, err := suite.handler.GetUser(context.Background(), &pb.GetUserRequest{Id: 7})
+			suite.Equal(test.expectedResponse, gotResponse)
+			suite.Equal(test.expectedErr, err)
+		})
+	}
+}
+
+func (suite *handlerTestSuite) TestHandlerUpdateUserByID() {
+	storedUser := model.User{Model: gorm.Model{ID: 7}, Name: name, Phone: phone, Address: address}
+	tests := map[string]struct {
+		serviceErr       error
+		expectedResponse *pb.UpdateUserResponse
+		expectedErr      error
+	}{
+		"without_error": {
+			expectedResponse: &pb.UpdateUserResponse{
+				Response:    handler.UpdateUserMethodResponse,
+				UpdatedUser: &pb.User{Id: 7, UserName: name, Phone: phone, Address: address},
+			},
+		},
+		"conflict": {
+			serviceErr:  conflictErr,
+			expectedErr: status.Error(codes.AlreadyExists, conflictErr.Error()),
+		},
+	}
+	for testCase, test := range tests {
+		suite.Run(testCase, func() {
+			suite.service.On("UpdateUserByID", uint(7), modelUser, []string{model.FieldPhone}).Once().Return(storedUser, test.serviceErr)
+			gotResponse, err := suite.handler.UpdateUserByID(context.Background(), &pb.UpdateUserByIDRequest{
+				Id:          7,
+				UpdatedUser: user,
+				UpdateMask:  &field_mask.FieldMask{Paths: []string{"phone"}},
+			})
+			suite.Equal(test.expectedResponse, gotResponse)
+			suite.Equal(test.expectedErr, err)
+		})
+	}
+}
+
+func (suite *handlerTestSuite) TestHandlerDeleteUserByID() {
+	tests := map[string]struct {
+		serviceResponse  string
+		serviceErr       error
+		expectedResponse *pb.DeleteUserResponse
+		expectedErr      error
+	}{
+		"without_error": {
+			serviceResponse:  responseOK,
+			expectedResponse: &pb.DeleteUserResponse{Response: responseOK, DeletedCount: 1},
+		},
+		"not_found": {
+			serviceErr:  notFoundErr,
+			expectedErr: status.Error(codes.NotFound, notFoundErr.Error()),
+		},
+	}
+	for testCase, test := range tests {
+		suite.Run(testCase, func() {
+			suite.service.On("DeleteUserByID", uint(7)).Once().Return(test.serviceResponse, test.serviceErr)
+			gotResponse, err := suite.handler.DeleteUserByID(context.Background(), &pb.DeleteUserByIDRequest{Id: 7})
+			suite.Equal(test.expectedResponse, gotResponse)
+			suite.Equal(test.expectedErr, err)
+		})
+	}
+}
+
+func (suite *handlerTestSuite) TestHandlerListDeletedUsers() {
+	deletedAt := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	deletedUser := model.User{Model: gorm.Model{ID: 7, DeletedAt: gorm.DeletedAt{Time: deletedAt, Valid: true}}, Name: name}
+	suite.service.On("ListDeletedUsers", listOptions).Once().Return(service.Page{Users: []model.User{deletedUser}, TotalSize: 1}, nil)
+
+	gotResponse, err := suite.handler.ListDeletedUsers(context.Background(), &pb.ListDeletedUsersRequest{PageSize: 10, PageToken: "token"})
+	suite.NoError(err)
+	suite.Equal(&pb.ListDeletedUsersResponse{
+		Users:     []*pb.User{{Id: 7, UserName: name, DeletedAt: timestamppb.New(deletedAt)}},
+		TotalSize: 1,
+	}, gotResponse)
+}
+
+func (suite *handlerTestSuite) TestHandlerRestoreUser() {
+	tests := map[string]struct {
+		serviceErr       error
+		expectedResponse *pb.RestoreUserResponse
+		expectedErr      error
+	}{
+		"without_error": {
+			expectedResponse: &pb.RestoreUserResponse{Response: handler.RestoreUserMethodResponse, User: user},
+		},
+		"phone_taken": {
+			serviceErr:  conflictErr,
+			expectedErr: status.Error(codes.AlreadyExists, conflictErr.Error()),
+		},
+	}
+	for testCase, test := range tests {
+		suite.Run(testCase, func() {
+			suite.service.On("RestoreUser", uint(7)).Once().Return(modelUser, test.serviceErr)
+			gotResponse, err := suite.handler.RestoreUser(context.Background(), &pb.RestoreUserRequest{Id: 7})
+			suite.Equal(test.expectedResponse, gotResponse)
+			suite.Equal(test.expectedErr, err)
+		})
+	}
+}
+
+func (suite *handlerTestSuite) TestHandlerPurgeDeletedUsers() {
+	olderThan := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	suite.service.On("PurgeDeletedUsers", olderThan).Once().Return(responseOK, int64(3), nil)
+	gotResponse, err := suite.handler.PurgeDeletedUsers(context.Background(), &pb.PurgeDeletedUsersRequest{OlderThan: timestamppb.New(olderThan)})
+	suite.NoError(err)
+	suite.Equal(&pb.PurgeDeletedUsersResponse{Response: responseOK, PurgedCount: 3}, gotResponse)
+
+	invalidErr := service.Invalid(service.ErrEmptyOlderThan)
+	suite.service.On("PurgeDeletedUsers", time.Time{}).Once().Return("", int64(0), invalidErr)
+	_, err = suite.handler.PurgeDeletedUsers(context.Background(), &pb.PurgeDeletedUsersRequest{})
+	suite.Equal(status.Error(codes.InvalidArgument, service.ErrEmptyOlderThan), err)
+}
+
+func (suite *handlerTestSuite) TestHandlerValidation() {
+	tests := map[string]struct {
+		call               func() error
+		expectedViolations []*errdetails.BadRequest_FieldViolation
+	}{
+		"add_empty_user": {
+			call: func() error {
+				_, err := suite.handler.AddUser(context.Background(), &pb.AddUserRequest{})
+				return err
+			},
+			expectedViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "newUser.userName", Description: handler.ErrEmptyField},
+				{Field: "newUser.phone", Description: handler.ErrEmptyField},
+			},
+		},
+		"add_invalid_contacts": {
+			call: func() error {
+				_, err := suite.handler.AddUser(context.Background(), &pb.AddUserRequest{NewUser: &pb.User{
+					UserName:      name,
+					Address:       strings.Repeat("a", handler.MaxAddressLength+1),
+					Phones:        []*pb.PhoneNumber{{Number: phone}, {Number: " "}},
+					Emails:        []*pb.Email{{Address: "not an email"}},
+					PostalAddress: &pb.PostalAddress{CountryCode: "usa"},
+				}})
+				return err
+			},
+			expectedViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "newUser.address", Description: fmt.Sprintf(handler.ErrTooLong, handler.MaxAddressLength)},
+				{Field: "newUser.phones[1].number", Description: handler.ErrEmptyField},
+				{Field: "newUser.emails[0].address", Description: handler.ErrInvalidEmail},
+				{Field: "newUser.postalAddress.countryCode", Description: handler.ErrInvalidCountryCode},
+			},
+		},
+		"update_masked_empty_name": {
+			call: func() error {
+				_, err := suite.handler.UpdateUserByID(context.Background(), &pb.UpdateUserByIDRequest{
+					Id:          7,
+					UpdatedUser: &pb.User{},
+					UpdateMask:  &field_mask.FieldMask{Paths: []string{"userName"}},
+				})
+				return err
+			},
+			expectedViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "updatedUser.userName", Description: handler.ErrEmptyField},
+			},
+		},
+		"update_snake_case_mask": {
+			call: func() error {
+				_, err := suite.handler.UpdateUserByID(context.Background(), &pb.UpdateUserByIDRequest{
+					Id:          7,
+					UpdatedUser: &pb.User{},
+					UpdateMask:  &field_mask.FieldMask{Paths: []string{"user_name"}},
+				})
+				return err
+			},
+			expectedViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "updatedUser.userName", Description: handler.ErrEmptyField},
+			},
+		},
+		"update_masked_empty_phones": {
+			call: func() error {
+				_, err := suite.handler.UpdateUserByID(context.Background(), &pb.UpdateUserByIDRequest{
+					Id:          7,
+					UpdatedUser: &pb.User{Phones: []*pb.PhoneNumber{}},
+					UpdateMask:  &field_mask.FieldMask{Paths: []string{"phones"}},
+				})
+				return err
+			},
+			expectedViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "updatedUser.phones", Description: handler.ErrEmptyField},
+			},
+		},
+		"update_masked_blank_phones": {
+			call: func() error {
+				_, err := suite.handler.UpdateUser(context.Background(), &pb.UpdateUserRequest{
+					Phone:       phone,
+					UpdatedUser: &pb.User{Phones: []*pb.PhoneNumber{{Number: " "}}},
+					UpdateMask:  &field_mask.FieldMask{Paths: []string{"phones"}},
+				})
+				return err
+			},
+			expectedViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "updatedUser.phones", Description: handler.ErrEmptyField},
+				{Field: "updatedUser.phones[0].number", Description: handler.ErrEmptyField},
+			},
+		},
+		"find_long_pattern": {
+			call: func() error {
+				_, err := suite.handler.FindUser(context.Background(), &pb.FindUserRequest{City: strings.Repeat("*", handler.MaxPatternLength+1)})
+				return err
+			},
+			expectedViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "city", Description: fmt.Sprintf(handler.ErrTooLong, handler.MaxPatternLength)},
+			},
+		},
+		"search_empty_query": {
+			call: func() error {
+				_, err := suite.handler.SearchUsers(context.Background(), &pb.SearchUsersRequest{Query: " "})
+				return err
+			},
+			expectedViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "query", Description: handler.ErrEmptyField},
+			},
+		},
+		"export_vcard_version": {
+			call: func() error {
+				return suite.handler.ExportVCard(&pb.ExportVCardRequest{Version: "2.1"}, &vcardStream{})
+			},
+			expectedViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "version", Description: fmt.Sprintf(handler.ErrInvalidVersion, "3.0, 4.0")},
+			},
+		},
+		"find_long_filter": {
+			call: func() error {
+				_, err := suite.handler.FindUser(context.Background(), &pb.FindUserRequest{Filter: strings.Repeat("a", handler.MaxFilterLength+1)})
+				return err
+			},
+			expectedViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "filter", Description: fmt.Sprintf(handler.ErrTooLong, handler.MaxFilterLength)},
+			},
+		},
+	}
+	for testCase, test := range tests {
+		suite.Run(testCase, func() {
+			st := status.Convert(test.call())
+			suite.Equal(codes.InvalidArgument, st.Code())
+			suite.Equal(handler.ErrInvalidRequest, st.Message())
+			suite.Require().Len(st.Details(), 1)
+			badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+			suite.Require().True(ok)
+			suite.Equal(len(test.expectedViolations), len(badRequest.GetFieldViolations()))
+			for i, violation := range badRequest.GetFieldViolations() {
+				suite.Equal(test.expectedViolations[i].GetField(), violation.GetField())
+				suite.Equal(test.expectedViolations[i].GetDescription(), violation.GetDescription())
+			}
+		})
+	}
+	suite.service.AssertNotCalled(suite.T(), "AddUser")
+}
+
+func (suite *handlerTestSuite) TestHandlerErrorCodes() {
+	tests := map[string]struct {
+		serviceErr   error
+		expectedCode codes.Code
+	}{
+		"not_found": {
+			serviceErr:   notFoundErr,
+			expectedCode: codes.NotFound,
+		},
+		"conflict": {
+			serviceErr:   conflictErr,
+			expectedCode: codes.AlreadyExists,
+		},
+		"validation": {
+			serviceErr:   service.Invalid("bad request"),
+			expectedCode: codes.InvalidArgument,
+		},
+		"internal": {
+			serviceErr:   internalErr,
+			expectedCode: codes.Internal,
+		},
+		"unavailable": {
+			serviceErr:   service.Internal(fmt.Errorf("%w: connection refused", model.ErrUnavailable)),
+			expectedCode: codes.Unavailable,
+		},
+		"untyped": {
+			serviceErr:   err,
+			expectedCode: codes.Internal,
+		},
+	}
+	for testCase, test := range tests {
+		suite.Run(testCase, func() {
+			suite.service.On("AddUser", modelUser).Once().Return(model.User{}, test.serviceErr)
+			_, err := suite.handler.AddUser(context.Background(), &pb.AddUserRequest{NewUser: user})
+			suite.Equal(test.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func (suite *handlerTestSuite) TestHandlerFindDuplicates() {
+	groups := []model.DuplicateGroup{{Users: modelUsers, Reasons: []string{model.DuplicatePhone}, Score: 1}}
+	suite.service.On("FindDuplicates", 0.7, int32(5)).Once().Return(groups, nil)
+	suite.service.On("FindDuplicates", 2.0, int32(0)).Once().Return(nil, service.Invalid(service.ErrInvalidMinScore))
+
+	gotResponse, err := suite.handler.FindDuplicates(context.Background(), &pb.FindDuplicatesRequest{MinScore: 0.7, PageSize: 5})
+	suite.NoError(err)
+	suite.Equal(&pb.FindDuplicatesResponse{Groups: []*pb.DuplicateGroup{{Users: users, Reasons: []string{model.DuplicatePhone}, Score: 1}}}, gotResponse)
+
+	_, err = suite.handler.FindDuplicates(context.Background(), &pb.FindDuplicatesRequest{MinScore: 2})
+	suite.Equal(status.Error(codes.InvalidArgument, service.ErrInvalidMinScore), err)
+}
+
+func (suite *handlerTestSuite) TestHandlerMergeUsers() {
+	resolution := map[string]string{
+		model.FieldName:          model.MergeLongest,
+		model.FieldPostalAddress: model.MergeNewest,
+		model.FieldPhones:        "",
+		"birthday":               model.MergeSurvivor,
+		model.FieldEmails:        "42",
+	}
+	suite.service.On("MergeUsers", []uint{1, 2, 3}, uint(2), resolution).Once().Return(modelUser, []uint{1, 3}, nil)
+	gotResponse, err := suite.handler.MergeUsers(context.Background(), &pb.MergeUsersRequest{
+		Ids:        []uint64{1, 2, 3},
+		SurvivorId: 2,
+		Resolution: map[string]pb.MergeStrategy{
+			"userName":      pb.MergeStrategy_MERGE_STRATEGY_LONGEST,
+			"postalAddress": pb.MergeStrategy_MERGE_STRATEGY_NEWEST,
+			"phones":        pb.MergeStrategy_MERGE_STRATEGY_UNSPECIFIED,
+			"birthday":      pb.MergeStrategy_MERGE_STRATEGY_SURVIVOR,
+			"emails":        pb.MergeStrategy(42),
+		},
+	})
+	suite.NoError(err)
+	suite.Equal(&pb.MergeUsersResponse{Response: handler.MergeUsersMethodResponse, User: user, MergedIds: []uint64{1, 3}}, gotResponse)
+
+	legacy := map[string]string{model.FieldPostalAddress: model.MergeLongest}
+	suite.service.On("MergeUsers", []uint{1, 2}, uint(0), legacy).Once().Return(modelUser, []uint{2}, nil)
+	_, err = suite.handler.MergeUsers(context.Background(), &pb.MergeUsersRequest{
+		Ids:        []uint64{1, 2},
+		Resolution: map[string]pb.MergeStrategy{"address": pb.MergeStrategy_MERGE_STRATEGY_LONGEST},
+	})
+	suite.NoError(err)
+
+	notFound := service.NotFound(service.ErrMergedUsersNotFound)
+	suite.service.On("MergeUsers", []uint{1, 9}, uint(0), map[string]string{}).Once().Return(model.User{}, nil, notFound)
+	_, err = suite.handler.MergeUsers(context.Background(), &pb.MergeUsersRequest{Ids: []uint64{1, 9}})
+	suite.Equal(status.Error(codes.NotFound, service.ErrMergedUsersNotFound), err)
+}
+
+func (suite *handlerTestSuite) TestHandlerListUsersInGroup() {
+	member := modelUser
+	member.Groups = []model.Group{{ID: 1, Name: "Family"}, {ID: 2, Name: "Friends"}}
+	opts := service.ListOptions{PageSize: 10, Group: "friends"}
+	suite.service.On("ListUsers", opts).Once().Return(service.Page{Users: []model.User{member}, TotalSize: 1}, nil)
+
+	gotResponse, err := suite.handler.ListUsers(context.Background(), &pb.ListUsersRequest{PageSize: 10, Group: "friends"})
+	suite.NoError(err)
+	suite.Require().Len(gotResponse.GetUsers(), 1)
+	suite.Equal([]string{"Family", "Friends"}, gotResponse.GetUsers()[0].GetGroups())
+}
+
+func (suite *handlerTestSuite) TestHandlerCreateGroup() {
+	created := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	group := model.Group{ID: 1, Name: "Friends", Description: "close ones", CreatedAt: created, UpdatedAt: created}
+	suite.service.On("AddGroup", model.Group{Name: "Friends", Description: "close ones"}).Once().Return(group, nil)
+
+	gotResponse, err := suite.handler.CreateGroup(context.Background(), &pb.CreateGroupRequest{Group: &pb.Group{Name: "Friends", Description: " close ones "}})
+	suite.NoError(err)
+	suite.Equal(&pb.CreateGroupResponse{
+		Response: handler.CreateGroupMethodResponse,
+		Group: &pb.Group{
+			Id:          1,
+			Name:        "Friends",
+			Description: "close ones",
+			CreatedAt:   timestamppb.New(created),
+			UpdatedAt:   timestamppb.New(created),
+		},
+	}, gotResponse)
+
+	_, err = suite.handler.CreateGroup(context.Background(), &pb.CreateGroupRequest{Group: &pb.Group{Description: strings.Repeat("a", handler.MaxDescriptionLength+1)}})
+	st, ok := status.FromError(err)
+	suite.Require().True(ok)
+	suite.Equal(codes.InvalidArgument, st.Code())
+	suite.Require().Len(st.Details(), 1)
+	suite.Len(st.Details()[0].(*errdetails.BadRequest).GetFieldViolations(), 2)
+
+	suite.service.On("AddGroup", model.Group{Name: "Friends"}).Once().Return(model.Group{}, service.Conflict(service.ErrGroupAlreadyExists, "Friends"))
+	_, err = suite.handler.CreateGroup(context.Background(), &pb.CreateGroupRequest{Group: &pb.Group{Name: "Friends"}})
+	suite.Equal(status.Error(codes.AlreadyExists, fmt.Sprintf(service.ErrGroupAlreadyExists, "Friends")), err)
+}
+
+func (suite *handlerTestSuite) TestHandlerUpdateGroup() {
+	group := model.Group{ID: 1, Name: "Friends", Description: "pals"}
+	suite.service.On("UpdateGroup", uint(1), model.Group{Description: "pals"}, []string{model.FieldGroupDescription}).Once().Return(group, nil)
+
+	gotResponse, err := suite.handler.UpdateGroup(context.Background(), &pb.UpdateGroupRequest{
+		Id:         1,
+		Group:      &pb.Group{Description: "pals"},
+		UpdateMask: &field_mask.FieldMask{Paths: []string{"description"}},
+	})
+	suite.NoError(err)
+	suite.Equal(handler.UpdateGroupMethodResponse, gotResponse.GetResponse())
+	suite.Equal("pals", gotResponse.GetGroup().GetDescription())
+
+	_, err = suite.handler.UpdateGroup(context.Background(), &pb.UpdateGroupRequest{Id: 1, Group: &pb.Group{Description: "pals"}})
+	st, ok := status.FromError(err)
+	suite.Require().True(ok)
+	suite.Equal(codes.InvalidArgument, st.Code())
+}
+
+func (suite *handlerTestSuite) TestHandlerGroups() {
+	groups := []model.Group{{ID: 2, Name: "Family"}, {ID: 1, Name: "Friends"}}
+	suite.service.On("ListGroups").Once().Return(groups, nil)
+	listResponse, err := suite.handler.ListGroups(context.Background(), &pb.ListGroupsRequest{})
+	suite.NoError(err)
+	suite.Require().Len(listResponse.GetGroups(), 2)
+	suite.Equal(uint64(2), listResponse.GetGroups()[0].GetId())
+
+	suite.service.On("GetGroup", uint(9)).Once().Return(model.Group{}, service.NotFound(service.ErrNoSuchGroupWithID, uint(9)))
+	_, err = suite.handler.GetGroup(context.Background(), &pb.GetGroupRequest{Id: 9})
+	suite.Equal(status.Error(codes.NotFound, fmt.Sprintf(service.ErrNoSuchGroupWithID, 9)), err)
+
+	suite.service.On("DeleteGroup", uint(1)).Once().Return(responseOK, nil)
+	deleteResponse, err := suite.handler.DeleteGroup(context.Background(), &pb.DeleteGroupRequest{Id: 1})
+	suite.NoError(err)
+	suite.Equal(&pb.DeleteGroupResponse{Response: responseOK}, deleteResponse)
+}
+
+func (suite *handlerTestSuite) TestHandlerGroupMembers() {
+	suite.service.On("AddGroupMembers", uint(1), []uint{2, 3}).Once().Return(responseOK, int64(2), nil)
+	gotResponse, err := suite.handler.AddGroupMembers(context.Background(), &pb.GroupMembersRequest{Id: 1, UserIds: []uint64{2, 3}})
+	suite.NoError(err)
+	suite.Equal(&pb.GroupMembersResponse{Response: responseOK, Count: 2}, gotResponse)
+
+	suite.service.On("RemoveGroupMembers", uint(1), []uint{2}).Once().Return(responseOK, int64(1), nil)
+	gotResponse, err = suite.handler.RemoveGroupMembers(context.Background(), &pb.GroupMembersRequest{Id: 1, UserIds: []uint64{2}})
+	suite.NoError(err)
+	suite.Equal(&pb.GroupMembersResponse{Response: responseOK, Count: 1}, gotResponse)
+
+	suite.service.On("AddGroupMembers", uint(1), []uint{9}).Once().Return("", int64(0), service.NotFound(service.ErrMembersNotFound))
+	_, err = suite.handler.AddGroupMembers(context.Background(), &pb.GroupMembersRequest{Id: 1, UserIds: []uint64{9}})
+	suite.Equal(status.Error(codes.NotFound, service.ErrMembersNotFound), err)
+}
+
+func (suite *handlerTestSuite) TestHandlerAttributes() {
+	attributes := map[string]string{"customerId": "42", "manager": "jane"}
+	withAttributes := modelUser
+	withAttributes.Attributes = attributes
+	suite.service.On("AddUser", withAttributes).Once().Return(withAttributes, nil)
+
+	addResponse, err := suite.handler.AddUser(context.Background(), &pb.AddUserRequest{
+		NewUser: &pb.User{UserName: name, Phone: phone, Address: address, Attributes: attributes},
+	})
+	suite.NoError(err)
+	suite.Equal(attributes, addResponse.GetUser().GetAttributes())
+
+	filter := model.User{Attributes: model.Attributes{"manager": "ja*"}}
+	opts := service.ListOptions{}
+	suite.service.On("FindUser", filter, "attributes.customerId:*", opts).Once().Return(service.Page{Users: []model.User{withAttributes}, TotalSize: 1}, nil)
+	findResponse, err := suite.handler.FindUser(context.Background(), &pb.FindUserRequest{
+		Filter:     "attributes.customerId:*",
+		Attributes: map[string]string{"manager": "ja*"},
+	})
+	suite.NoError(err)
+	suite.Require().Len(findResponse.GetUsers(), 1)
+	suite.Equal(attributes, findResponse.GetUsers()[0].GetAttributes())
+}
+
+func (suite *handlerTestSuite) TestHandlerGetUserHistory() {
+	createdAt := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	snapshot := model.Snapshot{
+		Name:          name,
+		PostalAddress: model.PostalAddress{City: "London"},
+		Phones:        []model.SnapshotPhone{{Number: phone, Primary: true}},
+	}
+	revisions := []service.Revision{{
+		UserRevision: model.UserRevision{UserID: 7, Revision: 2, Action: model.RevisionUpdated, CreatedAt: createdAt, Snapshot: snapshot},
+		Changed:      []string{model.FieldName, model.FieldPostalAddress, model.FieldPhones},
+	}}
+	suite.service.On("GetUserHistory", uint(7)).Once().Return(revisions, nil)
+	gotResponse, err := suite.handler.GetUserHistory(context.Background(), &pb.GetUserHistoryRequest{Id: 7})
+	suite.NoError(err)
+	suite.Require().Len(gotResponse.GetRevisions(), 1)
+	revision := gotResponse.GetRevisions()[0]
+	suite.Equal(uint64(2), revision.GetRevision())
+	suite.Equal(model.RevisionUpdated, revision.GetAction())
+	suite.Equal(timestamppb.New(createdAt), revision.GetCreatedAt())
+	suite.Equal([]string{"userName", "postalAddress", "phones"}, revision.GetChangedFields())
+	suite.Equal(uint64(7), revision.GetUser().GetId())
+	suite.Equal(name, revision.GetUser().GetUserName())
+	suite.Equal(phone, revision.GetUser().GetPhone())
+	suite.Equal("London", revision.GetUser().GetAddress())
+
+	suite.service.On("GetUserHistory", uint(8)).Once().Return(nil, notFoundErr)
+	_, err = suite.handler.GetUserHistory(context.Background(), &pb.GetUserHistoryRequest{Id: 8})
+	suite.Equal(status.Error(codes.NotFound, notFoundErr.Error()), err)
+}
+
+func (suite *handlerTestSuite) TestHandlerRevertUser() {
+	suite.service.On("RevertUser", uint(7), uint(2)).Once().Return(modelUser, nil)
+	gotResponse, err := suite.handler.RevertUser(context.Background(), &pb.RevertUserRequest{Id: 7, Revision: 2})
+	suite.NoError(err)
+	suite.Equal(&pb.RevertUserResponse{Response: handler.RevertUserMethodResponse, User: user}, gotResponse)
+
+	suite.service.On("RevertUser", uint(7), uint(3)).Once().Return(model.User{}, conflictErr)
+	_, err = suite.handler.RevertUser(context.Background(), &pb.RevertUserRequest{Id: 7, Revision: 3})
+	suite.Equal(status.Error(codes.AlreadyExists, conflictErr.Error()), err)
+}
+
+// payloadDigest returns the digest the audit interceptors record for the
+// request messages.
+func payloadDigest(messages ...proto.Message) string {
+	digest := sha256.New()
+	for _, m := range messages {
+		data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(len(data)))
+		digest.Write(size[:])
+		digest.Write(data)
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+func (suite *handlerTestSuite) TestHandlerAuditInterceptor() {
+	req := &pb.DeleteUserRequest{UserName: name, AllowBulk: true}
+	deleteInfo := &grpc.UnaryServerInfo{FullMethod: "/pb.AddressBookService/DeleteUser"}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(handler.ActorHeader, "alice", handler.ForwardedForHeader, "10.0.0.2"))
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000}})
+
+	suite.service.On("RecordAuditEvent", model.AuditEvent{
+		Actor:    "alice",
+		Peer:     "127.0.0.1:5000 for 10.0.0.2",
+		Method:   deleteInfo.FullMethod,
+		Digest:   payloadDigest(req),
+		Code:     codes.OK.String(),
+		Affected: 2,
+	}).Once().Return(nil)
+	resp, err := suite.handler.AuditInterceptor(ctx, req, deleteInfo, func(context.Context, interface{}) (interface{}, error) {
+		return &pb.DeleteUserResponse{Response: responseOK, DeletedCount: 2}, nil
+	})
+	suite.NoError(err)
+	suite.Equal(&pb.DeleteUserResponse{Response: responseOK, DeletedCount: 2}, resp)
+
+	addInfo := &grpc.UnaryServerInfo{FullMethod: "/pb.AddressBookService/AddUser"}
+	notFound := status.Error(codes.NotFound, notFoundErr.Error())
+	suite.service.On("RecordAuditEvent", testifymock.MatchedBy(func(e model.AuditEvent) bool {
+		return e.Actor == handler.AnonymousActor && e.Method == addInfo.FullMethod && e.Code == codes.NotFound.String() && e.Affected == 0
+	})).Once().Return(internalErr)
+	_, err = suite.handler.AuditInterceptor(context.Background(), &pb.AddUserRequest{}, addInfo, func(context.Context, interface{}) (interface{}, error) {
+		return nil, notFound
+	})
+	suite.Equal(notFound, err)
+
+	getInfo := &grpc.UnaryServerInfo{FullMethod: "/pb.AddressBookService/GetUser"}
+	_, err = suite.handler.AuditInterceptor(ctx, &pb.GetUserRequest{Id: 1}, getInfo, func(context.Context, interface{}) (interface{}, error) {
+		return &pb.GetUserResponse{}, nil
+	})
+	suite.NoError(err)
+	suite.service.AssertNumberOfCalls(suite.T(), "RecordAuditEvent", 2)
+}
+
+func (suite *handlerTestSuite) TestHandlerAuditImportCSV() {
+	report := service.ImportReport{Rows: []service.ImportRowResult{{Row: 0, Status: model.ImportCreated, ID: 7}}, Created: 1}
+	suite.service.On("ImportUsers", model.OnConflictFail, testifymock.Anything).Once().Return(report, nil)
+	suite.service.On("RecordAuditEvent", testifymock.MatchedBy(func(e model.AuditEvent) bool {
+		return e.Method == "/pb.AddressBookService/ImportCSV" && e.Code == codes.OK.String() && e.Affected == 1
+	})).Once().Return(nil)
+
+	req := &pb.ImportCSVRequest{Csv: &httpbody.HttpBody{Data: []byte("name,phone\njohn,+78129878899\n")}}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), &headerStream{})
+	_, err := suite.handler.AuditInterceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: "/pb.AddressBookService/ImportCSV"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return suite.handler.ImportCSV(ctx, req.(*pb.ImportCSVRequest))
+	})
+	suite.NoError(err)
+	suite.service.AssertNumberOfCalls(suite.T(), "RecordAuditEvent", 1)
+}
+
+// messageStream feeds request messages to a streaming handler and records
+// the messages it sends.
+type messageStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	requests []proto.Message
+	sent     []interface{}
+}
+
+func (s *messageStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *messageStream) RecvMsg(m interface{}) error {
+	if len(s.requests) == 0 {
+		return io.EOF
+	}
+	proto.Merge(m.(proto.Message), s.requests[0])
+	s.requests = s.requests[1:]
+	return nil
+}
+
+func (s *messageStream) SendMsg(m interface{}) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func (suite *handlerTestSuite) TestHandlerAuditStreamInterceptor() {
+	requests := []proto.Message{
+		&pb.ImportUsersRequest{User: &pb.User{UserName: name, Phone: phone}, OnConflict: pb.OnConflict_ON_CONFLICT_OVERWRITE},
+		&pb.ImportUsersRequest{User: &pb.User{UserName: "jack", Phone: "+78120000000"}},
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(handler.ActorHeader, "alice"))
+	stream := &messageStream{ctx: ctx, requests: append([]proto.Message(nil), requests...)}
+	info := &grpc.StreamServerInfo{FullMethod: "/pb.AddressBookService/ImportUsers", IsClientStream: true}
+
+	suite.service.On("RecordAuditEvent", model.AuditEvent{
+		Actor:    "alice",
+		Method:   info.FullMethod,
+		Digest:   payloadDigest(requests...),
+		Code:     codes.OK.String(),
+		Affected: 2,
+	}).Once().Return(nil)
+	err := suite.handler.AuditStreamInterceptor(nil, stream, info, func(_ interface{}, ss grpc.ServerStream) error {
+		for {
+			if err := ss.RecvMsg(&pb.ImportUsersRequest{}); err == io.EOF {
+				break
+			}
+		}
+		return ss.SendMsg(&pb.ImportUsersResponse{Created: 1, Updated: 1, Failed: 1})
+	})
+	suite.NoError(err)
+	suite.Len(stream.sent, 1)
+
+	exportInfo := &grpc.StreamServerInfo{FullMethod: "/pb.AddressBookService/ExportUsers", IsServerStream: true}
+	err = suite.handler.AuditStreamInterceptor(nil, &messageStream{ctx: ctx}, exportInfo, func(interface{}, grpc.ServerStream) error {
+		return nil
+	})
+	suite.NoError(err)
+	suite.service.AssertNumberOfCalls(suite.T(), "RecordAuditEvent", 1)
+}
+
+// readOnlyMethods are the RPCs that change no users or groups and are not
+// audited. A new RPC has to be added here or to the audited methods.
+var readOnlyMethods = map[string]bool{
+	"FindUser":         true,
+	"SearchUsers":      true,
+	"ExportUsers":      true,
+	"ExportVCard":      true,
+	"FindUserCSV":      true,
+	"ListUsersCSV":     true,
+	"ListUsers":        true,
+	"GetUser":          true,
+	"ListDeletedUsers": true,
+	"FindDuplicates":   true,
+	"ListGroups":       true,
+	"GetGroup":         true,
+	"GetUserHistory":   true,
+	"ListAuditEvents":  true,
+}
+
+func (suite *handlerTestSuite) TestHandlerAuditedMethods() {
+	suite.service.On("RecordAuditEvent", testifymock.Anything).Return(nil)
+	desc := pb.AddressBookService_ServiceDesc
+	audited := func(fullMethod string, record func()) bool {
+		before := len(suite.service.Calls)
+		record()
+		return len(suite.service.Calls) > before
+	}
+	for _, m := range desc.Methods {
+		fullMethod := "/" + desc.ServiceName + "/" + m.MethodName
+		suite.Equal(!readOnlyMethods[m.MethodName], audited(fullMethod, func() {
+			_, _ = suite.handler.AuditInterceptor(context.Background(), &pb.GetUserRequest{}, &grpc.UnaryServerInfo{FullMethod: fullMethod}, func(context.Context, interface{}) (interface{}, error) {
+				return nil, nil
+			})
+		}), "audit of %s", m.MethodName)
+	}
+	for _, st := range desc.Streams {
+		fullMethod := "/" + desc.ServiceName + "/" + st.StreamName
+		suite.Equal(!readOnlyMethods[st.StreamName], audited(fullMethod, func() {
+			_ = suite.handler.AuditStreamInterceptor(nil, &messageStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: fullMethod}, func(interface{}, grpc.ServerStream) error {
+				return nil
+			})
+		}), "audit of %s", st.StreamName)
+	}
+}
+
+func (suite *handlerTestSuite) TestHandlerListAuditEvents() {
+	from := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	opts := service.AuditOptions{From: from, Actor: "alice", PageSize: 10, PageToken: "token"}
+	event := model.AuditEvent{ID: 3, CreatedAt: from, Actor: "alice", Peer: "127.0.0.1:5000", Method: "/pb.AddressBookService/AddUser", Digest: "digest", Code: "OK", Affected: 1}
+	suite.service.On("ListAuditEvents", opts).Once().Return(service.AuditPage{Events: []model.AuditEvent{event}, NextPageToken: "next"}, nil)
+	gotResponse, err := suite.handler.ListAuditEvents(context.Background(), &pb.ListAuditEventsRequest{
+		From:      timestamppb.New(from),
+		Actor:     "alice",
+		PageSize:  10,
+		PageToken: "token",
+	})
+	suite.NoError(err)
+	suite.Equal(&pb.ListAuditEventsResponse{
+		Events: []*pb.AuditEvent{{
+			Id:            3,
+			CreatedAt:     timestamppb.New(from),
+			ClaimedActor:  "alice",
+			Peer:          "127.0.0.1:5000",
+			Method:        "/pb.AddressBookService/AddUser",
+			PayloadDigest: "digest",
+			Code:          "OK",
+			AffectedRows:  1,
+		}},
+		NextPageToken: "next",
+	}, gotResponse)
+
+	invalidErr := service.Invalid(service.ErrInvalidTimeRange)
+	suite.service.On("ListAuditEvents", service.AuditOptions{From: from, To: from}).Once().Return(service.AuditPage{}, invalidErr)
+	_, err = suite.handler.ListAuditEvents(context.Background(), &pb.ListAuditEventsRequest{From: timestamppb.New(from), To: timestamppb.New(from)})
+	suite.Equal(status.Error(codes.InvalidArgument, service.ErrInvalidTimeRange), err)
+}
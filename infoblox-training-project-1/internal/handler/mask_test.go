@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+type maskTestSuite struct {
+	suite.Suite
+}
+
+func TestMask(t *testing.T) {
+	suite.Run(t, new(maskTestSuite))
+}
+
+func (suite *maskTestSuite) TestUpdateMaskFields() {
+	tests := map[string]struct {
+		paths          []string
+		expectedFields []string
+	}{
+		"empty": {
+			expectedFields: []string{},
+		},
+		"json_names": {
+			paths:          []string{"userName", "phone", "address", "phones", "emails", "postalAddress", "attributes"},
+			expectedFields: []string{model.FieldName, model.FieldPhone, model.FieldAddress, model.FieldPhones, model.FieldEmails, model.FieldPostalAddress, model.FieldAttributes},
+		},
+		"snake_case_names": {
+			paths:          []string{"user_name", "postal_address"},
+			expectedFields: []string{model.FieldName, model.FieldPostalAddress},
+		},
+		"nested_message": {
+			paths:          []string{"postalAddress.city", "postal_address.postal_code", "address"},
+			expectedFields: []string{model.FieldPostalAddress, model.FieldAddress},
+		},
+		"duplicates": {
+			paths:          []string{"address", "userName", "user_name", "address"},
+			expectedFields: []string{model.FieldAddress, model.FieldName},
+		},
+		"unknown": {
+			paths:          []string{"email", "postalAddress.country", "phone.number", "phones.number", "attributes.tier"},
+			expectedFields: []string{"email", "postalAddress.country", "phone.number", "phones.number", "attributes.tier"},
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			suite.Equal(test.expectedFields, updateMaskFields(test.paths))
+		})
+	}
+}
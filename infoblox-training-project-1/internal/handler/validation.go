@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+	"github.com/vstarostin/infoblox-training-project-1/internal/pb"
+	"github.com/vstarostin/infoblox-training-project-1/internal/vcard"
+)
+
+const (
+	MaxNameLength      = 256
+	MaxPhoneLength     = 32
+	MaxAddressLength   = 1024
+	MaxComponentLength = 256
+	MaxPatternLength   = 256
+	MaxFilterLength    = 1024
+	MaxPhones          = 10
+	MaxEmails          = 10
+
+	MaxDescriptionLength = 1024
+
+	ErrInvalidRequest     = "request has invalid fields"
+	ErrEmptyField         = "must not be empty"
+	ErrTooLong            = "must be at most %d characters long"
+	ErrTooMany            = "must have at most %d items"
+	ErrInvalidEmail       = "must be a valid email address"
+	ErrInvalidCountryCode = "must be a two-letter ISO 3166-1 code"
+	ErrInvalidVersion     = "must be one of %s"
+)
+
+// validator collects field violations of a request, field paths use the
+// JSON names of the request, e.g. "newUser.phones[1].number".
+type validator struct {
+	violations []*errdetails.BadRequest_FieldViolation
+}
+
+func (v *validator) add(field, format string, a ...interface{}) {
+	v.violations = append(v.violations, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, a...),
+	})
+}
+
+func (v *validator) required(field, value string) {
+	if format(value) == "" {
+		v.add(field, ErrEmptyField)
+	}
+}
+
+func (v *validator) maxLength(field, value string, max int) {
+	if utf8.RuneCountInString(value) > max {
+		v.add(field, ErrTooLong, max)
+	}
+}
+
+// summary joins the violations into a single line, for reports that have
+// no room for details.
+func (v *validator) summary() string {
+	parts := make([]string, 0, len(v.violations))
+	for _, violation := range v.violations {
+		parts = append(parts, violation.GetField()+": "+violation.GetDescription())
+	}
+	return strings.Join(parts, "; ")
+}
+
+// err returns an InvalidArgument status carrying a google.rpc.BadRequest
+// with the violations, or nil if there are none.
+func (v *validator) err() error {
+	if len(v.violations) == 0 {
+		return nil
+	}
+	st, err := status.New(codes.InvalidArgument, ErrInvalidRequest).WithDetails(&errdetails.BadRequest{FieldViolations: v.violations})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, ErrInvalidRequest)
+	}
+	return st.Err()
+}
+
+// user checks the fields of u that an update of the model fields writes,
+// all of them without a mask. The name is required when it is written, and
+// writing the phone or the phones must leave the user with a number.
+func (v *validator) user(field string, u *pb.User, fields []string) {
+	if writes(fields, model.FieldName) {
+		v.required(field+".userName", u.GetUserName())
+	}
+	if writes(fields, model.FieldPhone) && len(u.GetPhones()) == 0 {
+		v.required(field+".phone", u.GetPhone())
+	}
+	if len(fields) > 0 && writes(fields, model.FieldPhones) && !hasNumber(u.GetPhones()) &&
+		!(writes(fields, model.FieldPhone) && format(u.GetPhone()) != "") {
+		v.add(field+".phones", ErrEmptyField)
+	}
+	v.maxLength(field+".userName", u.GetUserName(), MaxNameLength)
+	v.maxLength(field+".phone", u.GetPhone(), MaxPhoneLength)
+	v.maxLength(field+".address", u.GetAddress(), MaxAddressLength)
+
+	if len(u.GetPhones()) > MaxPhones {
+		v.add(field+".phones", ErrTooMany, MaxPhones)
+	}
+	for i, p := range u.GetPhones() {
+		number := fmt.Sprintf("%s.phones[%d].number", field, i)
+		v.required(number, p.GetNumber())
+		v.maxLength(number, p.GetNumber(), MaxPhoneLength)
+	}
+
+	if len(u.GetEmails()) > MaxEmails {
+		v.add(field+".emails", ErrTooMany, MaxEmails)
+	}
+	for i, e := range u.GetEmails() {
+		address := fmt.Sprintf("%s.emails[%d].address", field, i)
+		value := strings.TrimSpace(e.GetAddress())
+		if parsed, err := mail.ParseAddress(value); err != nil || parsed.Address != value {
+			v.add(address, ErrInvalidEmail)
+		}
+	}
+
+	if a := u.GetPostalAddress(); a != nil {
+		components := []struct{ name, value string }{
+			{"street", a.GetStreet()},
+			{"city", a.GetCity()},
+			{"region", a.GetRegion()},
+			{"postalCode", a.GetPostalCode()},
+		}
+		for _, c := range components {
+			v.maxLength(field+".postalAddress."+c.name, c.value, MaxComponentLength)
+		}
+		if code := a.GetCountryCode(); code != "" && !isCountryCode(format(code)) {
+			v.add(field+".postalAddress.countryCode", ErrInvalidCountryCode)
+		}
+	}
+}
+
+// hasNumber reports whether any of the phones has a number.
+func hasNumber(phones []*pb.PhoneNumber) bool {
+	for _, p := range phones {
+		if format(p.GetNumber()) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// writes reports whether an update with the mask paths writes any of the fields.
+func writes(paths []string, fields ...string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, path := range paths {
+		for _, field := range fields {
+			if path == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func validateAddUser(in *pb.AddUserRequest) error {
+	v := &validator{}
+	v.user("newUser", in.GetNewUser(), nil)
+	return v.err()
+}
+
+func validateUpdateUser(in *pb.UpdateUserRequest) error {
+	v := &validator{}
+	v.user("updatedUser", in.GetUpdatedUser(), updateMaskFields(in.GetUpdateMask().GetPaths()))
+	return v.err()
+}
+
+func validateUpdateUserByID(in *pb.UpdateUserByIDRequest) error {
+	v := &validator{}
+	v.user("updatedUser", in.GetUpdatedUser(), updateMaskFields(in.GetUpdateMask().GetPaths()))
+	return v.err()
+}
+
+func validateFindUser(in *pb.FindUserRequest) error {
+	v := &validator{}
+	patterns := []struct{ field, value string }{
+		{"name", in.GetName()},
+		{"phone", in.GetPhone()},
+		{"address", in.GetAddress()},
+		{"street", in.GetStreet()},
+		{"city", in.GetCity()},
+		{"region", in.GetRegion()},
+		{"postalCode", in.GetPostalCode()},
+		{"countryCode", in.GetCountryCode()},
+		{"group", in.GetGroup()},
+	}
+	for _, p := range patterns {
+		v.maxLength(p.field, p.value, MaxPatternLength)
+	}
+	v.maxLength("filter", in.GetFilter(), MaxFilterLength)
+	return v.err()
+}
+
+// group checks the fields of g. The name is required when it is written.
+func (v *validator) group(field string, g *pb.Group, paths []string) {
+	if writes(paths, "name") {
+		v.required(field+".name", g.GetName())
+	}
+	v.maxLength(field+".name", g.GetName(), MaxNameLength)
+	v.maxLength(field+".description", g.GetDescription(), MaxDescriptionLength)
+}
+
+func validateCreateGroup(in *pb.CreateGroupRequest) error {
+	v := &validator{}
+	v.group("group", in.GetGroup(), nil)
+	return v.err()
+}
+
+func validateUpdateGroup(in *pb.UpdateGroupRequest) error {
+	v := &validator{}
+	v.group("group", in.GetGroup(), in.GetUpdateMask().GetPaths())
+	return v.err()
+}
+
+func validateSearchUsers(in *pb.SearchUsersRequest) error {
+	v := &validator{}
+	v.required("query", in.GetQuery())
+	v.maxLength("query", in.GetQuery(), MaxPatternLength)
+	return v.err()
+}
+
+func validateExportUsers(in *pb.ExportUsersRequest) error {
+	v := &validator{}
+	v.maxLength("filter", in.GetFilter(), MaxFilterLength)
+	return v.err()
+}
+
+// validateImportUser returns the violations of an imported user as a
+// report reason, or an empty string if there are none.
+func validateImportUser(in *pb.ImportUsersRequest) string {
+	v := &validator{}
+	v.user("user", in.GetUser(), nil)
+	return v.summary()
+}
+
+func validateExportVCard(in *pb.ExportVCardRequest) error {
+	v := &validator{}
+	v.maxLength("filter", in.GetFilter(), MaxFilterLength)
+	if version := in.GetVersion(); version != "" && version != vcard.Version3 && version != vcard.Version4 {
+		v.add("version", ErrInvalidVersion, strings.Join(vcard.Versions, ", "))
+	}
+	return v.err()
+}
+
+// validateDecodedUser checks a user read from a vCard or CSV file like
+// validateImportUser.
+func validateDecodedUser(u *pb.User) string {
+	v := &validator{}
+	v.user("user", u, nil)
+	return v.summary()
+}
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// AuditEvent records a call of a mutating RPC: who made it, a digest of the
+// request, the resulting gRPC status code and how many users it affected.
+// Events are only ever appended. Actor is what the caller claimed to be and
+// is not verified, Peer is the network address the call came from.
+type AuditEvent struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	Actor     string    `gorm:"index;not null"`
+	Peer      string    `gorm:"not null"`
+	Method    string    `gorm:"not null"`
+	Digest    string    `gorm:"not null"`
+	Code      string    `gorm:"not null"`
+	Affected  int64     `gorm:"not null"`
+}
+
+// AuditQuery selects events created in [From, To) by Actor, newest first.
+// Zero fields do not filter, a non-zero BeforeID continues a previous page.
+type AuditQuery struct {
+	From     time.Time
+	To       time.Time
+	Actor    string
+	BeforeID uint
+	Limit    int
+}
@@ -0,0 +1,28 @@
+package model
+
+// Reasons why users are considered duplicates.
+const (
+	// DuplicatePhone marks users sharing a phone number, compared by its
+	// last ten digits so that numbers stored in different formats match.
+	DuplicatePhone = "phone"
+	// DuplicateName marks users with similar names and, when both have one,
+	// similar addresses.
+	DuplicateName = "name"
+)
+
+// DuplicateQuery looks for clusters of live users that are likely the same
+// person. Names and addresses are compared by trigram similarity, which
+// must reach MinScore. At most Limit groups are returned, all when it is 0.
+type DuplicateQuery struct {
+	MinScore float64
+	Limit    int
+}
+
+// DuplicateGroup is a cluster of likely duplicates ordered by ID. Score is
+// the closest similarity within the group, 1 for shared phone numbers.
+// Groups are ordered by descending score, then by their first ID.
+type DuplicateGroup struct {
+	Users   []User
+	Reasons []string
+	Score   float64
+}
@@ -0,0 +1,14 @@
+package model
+
+import "errors"
+
+var (
+	ErrNotFound       = errors.New("user not found")
+	ErrDuplicatePhone = errors.New("phone is already taken")
+	ErrUnavailable    = errors.New("storage is unavailable")
+	ErrSeveralOwners  = errors.New("phones belong to several users")
+	ErrGroupNotFound  = errors.New("group not found")
+	ErrDuplicateGroup = errors.New("group name is already taken")
+	ErrNoRevision     = errors.New("revision not found")
+	ErrCountMismatch  = errors.New("unexpected number of matching users")
+)
@@ -0,0 +1,36 @@
+package model
+
+// Fields that can be used in filter expressions besides name, phone and address.
+const (
+	FieldStreet      = "street"
+	FieldCity        = "city"
+	FieldRegion      = "region"
+	FieldPostalCode  = "postal_code"
+	FieldCountryCode = "country_code"
+	FieldEmail       = "email"
+	FieldAttribute   = "attribute"
+)
+
+type ExprKind int
+
+const (
+	// ExprMatch holds when any value of Field matches the LIKE Pattern.
+	ExprMatch ExprKind = iota
+	// ExprExists holds when Field has a non-empty value.
+	ExprExists
+	ExprAnd
+	ExprOr
+	ExprNot
+)
+
+// Expr is a boolean filter over users. Patterns of name and address are
+// matched against the search keys and must be folded with SearchKey,
+// address components, emails and attribute values are matched
+// case-insensitively. Key names the attribute of FieldAttribute.
+type Expr struct {
+	Kind     ExprKind
+	Field    string
+	Key      string
+	Pattern  string
+	Operands []Expr
+}
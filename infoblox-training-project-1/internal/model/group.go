@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// Fields of a group that can be updated.
+const (
+	FieldGroupName        = "name"
+	FieldGroupDescription = "description"
+)
+
+var UpdatableGroupFields = []string{FieldGroupName, FieldGroupDescription}
+
+// Group labels users, e.g. "family" or "suppliers". Names are unique
+// ignoring case and width, Key is their SearchKey form.
+type Group struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Name        string
+	Key         string `gorm:"uniqueIndex"`
+	Description string
+}
+
+// Normalize derives the key of the group from its name.
+func (g *Group) Normalize() {
+	g.Key = SearchKey(g.Name)
+}
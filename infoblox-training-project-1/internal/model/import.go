@@ -0,0 +1,27 @@
+package model
+
+// What an import does with a user whose phone numbers are already taken.
+const (
+	OnConflictFail      = "fail"
+	OnConflictSkip      = "skip"
+	OnConflictOverwrite = "overwrite"
+)
+
+var OnConflictModes = []string{OnConflictFail, OnConflictSkip, OnConflictOverwrite}
+
+// Outcomes of importing a single user.
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportSkipped = "skipped"
+	ImportFailed  = "failed"
+)
+
+// ImportResult is the outcome of importing one user. User is the stored
+// user for created and updated rows, Err the reason for skipped and failed
+// ones.
+type ImportResult struct {
+	Status string
+	User   User
+	Err    error
+}
@@ -0,0 +1,164 @@
+package model
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Strategies that resolve the value of a field when users are merged.
+const (
+	// MergeSurvivor takes the value of the survivor, or of the first merged
+	// user that has one.
+	MergeSurvivor = "survivor"
+	// MergeNewest takes the value of the most recently updated user that has one.
+	MergeNewest = "newest"
+	// MergeLongest takes the longest name or address, or the most phones,
+	// emails or attributes.
+	MergeLongest = "longest"
+	// MergeUnion keeps all distinct phones or emails, the survivor's first,
+	// or all attributes, each with the value of the first user that has it.
+	MergeUnion = "union"
+)
+
+// MergeFields are the fields resolved when users are merged. The postal
+// address is resolved together with the legacy one.
+var MergeFields = []string{FieldName, FieldPostalAddress, FieldPhones, FieldEmails, FieldAttributes}
+
+// MergeStrategies lists the strategies every merge field allows, the first
+// one is the default.
+var MergeStrategies = map[string][]string{
+	FieldName:          {MergeSurvivor, MergeNewest, MergeLongest},
+	FieldPostalAddress: {MergeSurvivor, MergeNewest, MergeLongest},
+	FieldPhones:        {MergeUnion, MergeSurvivor, MergeNewest, MergeLongest},
+	FieldEmails:        {MergeUnion, MergeSurvivor, MergeNewest, MergeLongest},
+	FieldAttributes:    {MergeUnion, MergeSurvivor, MergeNewest, MergeLongest},
+}
+
+// Merge folds the Merged users into the Survivor, which keeps its ID while
+// the others are deleted. Resolution maps fields to strategies, fields
+// missing from it use their default one.
+type Merge struct {
+	Survivor   uint
+	Merged     []uint
+	Resolution map[string]string
+}
+
+// MergeUsers returns the survivor with every merge field resolved from it
+// and the others, which are listed in the order of Merge.Merged. Only the
+// primary phone and email of the user a list comes from stay primary.
+func MergeUsers(survivor User, others []User, resolution map[string]string) User {
+	candidates := append([]User{survivor}, others...)
+	strategy := func(field string) string {
+		if s, ok := resolution[field]; ok && s != "" {
+			return s
+		}
+		return MergeStrategies[field][0]
+	}
+
+	var src User
+	name := candidates[pick(candidates, strategy(FieldName), func(u User) int {
+		return utf8.RuneCountInString(u.Name)
+	})]
+	src.Name = name.Name
+
+	address := candidates[pick(candidates, strategy(FieldPostalAddress), func(u User) int {
+		return utf8.RuneCountInString(u.Address)
+	})]
+	src.Address, src.PostalAddress = address.Address, address.PostalAddress
+
+	if s := strategy(FieldPhones); s == MergeUnion {
+		src.Phones = unionPhones(candidates)
+	} else {
+		src.Phones = candidates[pick(candidates, s, func(u User) int { return len(u.Phones) })].Phones
+	}
+	if s := strategy(FieldEmails); s == MergeUnion {
+		src.Emails = unionEmails(candidates)
+	} else {
+		src.Emails = candidates[pick(candidates, s, func(u User) int { return len(u.Emails) })].Emails
+	}
+	if s := strategy(FieldAttributes); s == MergeUnion {
+		src.Attributes = unionAttributes(candidates)
+	} else {
+		src.Attributes = candidates[pick(candidates, s, func(u User) int { return len(u.Attributes) })].Attributes
+	}
+
+	merged := survivor
+	merged.Merge(src, []string{FieldName, FieldPostalAddress, FieldPhones, FieldEmails, FieldAttributes})
+	return merged
+}
+
+// pick returns the index of the candidate whose value the strategy takes.
+// size measures the value, users where it is zero have none.
+func pick(candidates []User, strategy string, size func(User) int) int {
+	best := 0
+	for i, u := range candidates {
+		n := size(u)
+		if n == 0 {
+			continue
+		}
+		if size(candidates[best]) == 0 {
+			best = i
+			continue
+		}
+		switch strategy {
+		case MergeNewest:
+			if u.UpdatedAt.After(candidates[best].UpdatedAt) {
+				best = i
+			}
+		case MergeLongest:
+			if n > size(candidates[best]) {
+				best = i
+			}
+		}
+	}
+	return best
+}
+
+func unionPhones(candidates []User) []PhoneNumber {
+	var phones []PhoneNumber
+	seen := map[string]bool{}
+	for i, u := range candidates {
+		for _, p := range u.Phones {
+			if seen[p.Number] {
+				continue
+			}
+			seen[p.Number] = true
+			p.Primary = p.Primary && i == 0
+			phones = append(phones, p)
+		}
+	}
+	return phones
+}
+
+func unionEmails(candidates []User) []Email {
+	var emails []Email
+	seen := map[string]bool{}
+	for i, u := range candidates {
+		for _, e := range u.Emails {
+			key := strings.ToLower(e.Address)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			e.Primary = e.Primary && i == 0
+			emails = append(emails, e)
+		}
+	}
+	return emails
+}
+
+func unionAttributes(candidates []User) Attributes {
+	var attributes Attributes
+	for _, u := range candidates {
+		for k, v := range u.Attributes {
+			if _, ok := attributes[k]; ok {
+				continue
+			}
+			if attributes == nil {
+				attributes = Attributes{}
+			}
+			attributes[k] = v
+		}
+	}
+	return attributes
+}
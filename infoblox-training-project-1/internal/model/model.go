@@ -1,10 +1,213 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+const (
+	FieldName          = "name"
+	FieldPhone         = "phone"
+	FieldAddress       = "address"
+	FieldPhones        = "phones"
+	FieldEmails        = "emails"
+	FieldPostalAddress = "postal_address"
+	FieldAttributes    = "attributes"
+)
+
+var UpdatableFields = []string{FieldName, FieldPhone, FieldAddress, FieldPhones, FieldEmails, FieldPostalAddress, FieldAttributes}
+
+const (
+	PhoneMobile = "mobile"
+	PhoneHome   = "home"
+	PhoneWork   = "work"
+
+	EmailHome = "home"
+	EmailWork = "work"
+)
+
+var (
+	PhoneTypes = []string{PhoneMobile, PhoneHome, PhoneWork}
+	EmailTypes = []string{EmailHome, EmailWork}
+)
 
 type User struct {
 	gorm.Model
+	// Name and Address keep what the client sent, NameKey and AddressKey are
+	// their SearchKey forms used for filtering.
 	Name    string
-	Phone   string `gorm:"unique"`
+	NameKey string `gorm:"index"`
+	// Phone and PhoneDisplay mirror the primary PhoneNumber.
+	Phone        string `gorm:"uniqueIndex:idx_users_phone,where:deleted_at IS NULL"`
+	PhoneDisplay string
+	// Address is the formatted PostalAddress, or free text for users
+	// created before addresses were structured.
+	Address       string
+	AddressKey    string
+	PostalAddress PostalAddress `gorm:"embedded;embeddedPrefix:address_"`
+	Phones        []PhoneNumber
+	Emails        []Email
+	// Groups are ordered by key and only changed through group membership.
+	Groups     []Group    `gorm:"many2many:user_groups"`
+	Attributes Attributes `gorm:"type:jsonb;not null;default:'{}'"`
+}
+
+type PostalAddress struct {
+	Street      string
+	City        string
+	Region      string
+	PostalCode  string
+	CountryCode string
+}
+
+func (a PostalAddress) IsZero() bool {
+	return a == PostalAddress{}
+}
+
+// Format joins the non-empty components into a single line.
+func (a PostalAddress) Format() string {
+	parts := make([]string, 0, 5)
+	for _, part := range []string{a.Street, a.City, a.Region, a.PostalCode, a.CountryCode} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// PhoneNumber keeps the canonical E.164 Number, which is unique among live
+// users, and the Display form shown to clients.
+type PhoneNumber struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"index"`
+	Number    string `gorm:"uniqueIndex:idx_phone_numbers_number,where:deleted_at IS NULL"`
+	Display   string
+	Type      string
+	Primary   bool
+	DeletedAt gorm.DeletedAt
+}
+
+type Email struct {
+	ID      uint `gorm:"primarykey"`
+	UserID  uint `gorm:"index"`
 	Address string
+	Type    string
+	Primary bool
+}
+
+// Merge copies the listed fields of src into u.
+func (u *User) Merge(src User, fields []string) {
+	for _, field := range fields {
+		switch field {
+		case FieldName:
+			u.Name = src.Name
+		case FieldAddress:
+			u.Address = src.Address
+			u.PostalAddress = PostalAddress{}
+		case FieldPostalAddress:
+			// The formatted address is derived below; a zero PostalAddress
+			// falls back to the legacy text.
+			u.PostalAddress = src.PostalAddress
+			u.Address = src.Address
+		case FieldPhones:
+			u.Phones = append([]PhoneNumber(nil), src.Phones...)
+			u.Phone, u.PhoneDisplay = "", ""
+		case FieldEmails:
+			u.Emails = append([]Email(nil), src.Emails...)
+		case FieldAttributes:
+			u.Attributes = src.Attributes.Clone()
+		}
+	}
+	// The legacy phone field replaces the primary number, so it is applied
+	// after the full list of numbers.
+	for _, field := range fields {
+		if field == FieldPhone {
+			u.setPrimaryPhone(src.Phone, src.PhoneDisplay)
+		}
+	}
+	u.Normalize()
+}
+
+// Normalize fills in the derived fields of the user: primary contacts, the
+// formatted address and the search keys.
+func (u *User) Normalize() {
+	u.normalizeContacts()
+	if !u.PostalAddress.IsZero() {
+		u.Address = u.PostalAddress.Format()
+	}
+	u.NameKey = SearchKey(u.Name)
+	u.AddressKey = SearchKey(u.Address)
+}
+
+// SearchKey folds s for case and width insensitive matching and collapses
+// its whitespace.
+func SearchKey(s string) string {
+	return cases.Fold().String(norm.NFKC.String(strings.Join(strings.Fields(s), " ")))
+}
+
+// normalizeContacts drops empty numbers, fills in default types, makes sure
+// exactly one phone number and at most one email are primary, and keeps
+// Phone in sync with the primary number.
+func (u *User) normalizeContacts() {
+	if len(u.Phones) == 0 && u.Phone != "" {
+		u.Phones = []PhoneNumber{{Number: u.Phone, Display: u.PhoneDisplay, Type: PhoneMobile, Primary: true}}
+	}
+	phones := u.Phones[:0]
+	for _, p := range u.Phones {
+		if p.Number != "" {
+			phones = append(phones, p)
+		}
+	}
+	u.Phones = phones
+	u.Phone, u.PhoneDisplay = "", ""
+
+	primary := -1
+	for i := range u.Phones {
+		if u.Phones[i].Type == "" {
+			u.Phones[i].Type = PhoneMobile
+		}
+		if u.Phones[i].Display == "" {
+			u.Phones[i].Display = u.Phones[i].Number
+		}
+		if u.Phones[i].Primary && primary < 0 {
+			primary = i
+		}
+		u.Phones[i].Primary = false
+	}
+	if len(u.Phones) > 0 {
+		if primary < 0 {
+			primary = 0
+		}
+		u.Phones[primary].Primary = true
+		u.Phone = u.Phones[primary].Number
+		u.PhoneDisplay = u.Phones[primary].Display
+	}
+
+	primary = -1
+	for i := range u.Emails {
+		if u.Emails[i].Type == "" {
+			u.Emails[i].Type = EmailHome
+		}
+		if u.Emails[i].Primary && primary < 0 {
+			primary = i
+		}
+		u.Emails[i].Primary = false
+	}
+	if primary >= 0 {
+		u.Emails[primary].Primary = true
+	}
+}
+
+func (u *User) setPrimaryPhone(number, display string) {
+	for i := range u.Phones {
+		if u.Phones[i].Primary {
+			u.Phones[i].Number = number
+			u.Phones[i].Display = display
+			return
+		}
+	}
+	u.Phones = append([]PhoneNumber{{Number: number, Display: display, Type: PhoneMobile, Primary: true}}, u.Phones...)
 }
@@ -0,0 +1,36 @@
+package model
+
+// Fields users can be ordered by besides name, phone and address.
+const (
+	FieldID        = "id"
+	FieldCreatedAt = "created_at"
+	FieldUpdatedAt = "updated_at"
+)
+
+// Order sorts users by a field, names and addresses by their search keys.
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+// Query selects users whose fields match the LIKE patterns of Filter.
+// Name and Address patterns are matched against the search keys, so they
+// must be folded with SearchKey. PostalAddress components are matched
+// case-insensitively and empty ones do not filter at all. A non-nil Expr
+// must hold as well, and a non-empty Group, the key of a group, must be
+// one of the user's.
+// Results are sorted by OrderBy and then by ID. AfterID and Limit implement
+// keyset pagination: a non-zero AfterID skips users up to and including
+// the one with that ID, whose sort keys are taken from After. A zero Limit
+// means no limit. Deleted switches the query from live users to soft
+// deleted ones.
+type Query struct {
+	Filter  User
+	Expr    *Expr
+	Group   string
+	Deleted bool
+	OrderBy []Order
+	AfterID uint
+	After   User
+	Limit   int
+}
@@ -0,0 +1,146 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Actions that create revisions.
+const (
+	RevisionCreated  = "created"
+	RevisionUpdated  = "updated"
+	RevisionDeleted  = "deleted"
+	RevisionRestored = "restored"
+	RevisionMerged   = "merged"
+	RevisionReverted = "reverted"
+)
+
+// UserRevision is the state of a user right after a change. Revisions are
+// numbered per user from 1 and written in the same transaction as the
+// change, they are only removed when the user is purged.
+type UserRevision struct {
+	ID        uint `gorm:"primarykey"`
+	UserID    uint `gorm:"uniqueIndex:idx_user_revisions_user_revision"`
+	Revision  uint `gorm:"uniqueIndex:idx_user_revisions_user_revision"`
+	Action    string
+	CreatedAt time.Time
+	Snapshot  Snapshot `gorm:"type:jsonb;not null"`
+}
+
+// Snapshot is the content of a user kept by a revision, stored as JSON.
+// Group membership is not part of it.
+type Snapshot struct {
+	Name          string          `json:"name"`
+	Address       string          `json:"address,omitempty"`
+	PostalAddress PostalAddress   `json:"postalAddress"`
+	Phones        []SnapshotPhone `json:"phones,omitempty"`
+	Emails        []SnapshotEmail `json:"emails,omitempty"`
+	Attributes    Attributes      `json:"attributes,omitempty"`
+}
+
+type SnapshotPhone struct {
+	Number  string `json:"number"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type SnapshotEmail struct {
+	Address string `json:"address"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// NewSnapshot takes the content of u.
+func NewSnapshot(u User) Snapshot {
+	s := Snapshot{
+		Name:          u.Name,
+		Address:       u.Address,
+		PostalAddress: u.PostalAddress,
+		Attributes:    u.Attributes.Clone(),
+	}
+	for _, p := range u.Phones {
+		s.Phones = append(s.Phones, SnapshotPhone{Number: p.Number, Display: p.Display, Type: p.Type, Primary: p.Primary})
+	}
+	for _, e := range u.Emails {
+		s.Emails = append(s.Emails, SnapshotEmail{Address: e.Address, Type: e.Type, Primary: e.Primary})
+	}
+	return s
+}
+
+// User returns a user with the content of the snapshot, to be merged into
+// the live one with SnapshotFields.
+func (s Snapshot) User() User {
+	u := User{
+		Name:          s.Name,
+		Address:       s.Address,
+		PostalAddress: s.PostalAddress,
+		Attributes:    s.Attributes.Clone(),
+	}
+	for _, p := range s.Phones {
+		u.Phones = append(u.Phones, PhoneNumber{Number: p.Number, Display: p.Display, Type: p.Type, Primary: p.Primary})
+	}
+	for _, e := range s.Emails {
+		u.Emails = append(u.Emails, Email{Address: e.Address, Type: e.Type, Primary: e.Primary})
+	}
+	return u
+}
+
+// SnapshotFields are the fields of a user a snapshot replaces as a whole.
+var SnapshotFields = []string{FieldName, FieldPostalAddress, FieldPhones, FieldEmails, FieldAttributes}
+
+// Changes lists the fields that differ from prev, FieldPostalAddress when
+// the postal address changed and FieldAddress when only the legacy one did.
+func (s Snapshot) Changes(prev Snapshot) []string {
+	var fields []string
+	if s.Name != prev.Name {
+		fields = append(fields, FieldName)
+	}
+	if s.PostalAddress != prev.PostalAddress {
+		fields = append(fields, FieldPostalAddress)
+	} else if s.Address != prev.Address {
+		fields = append(fields, FieldAddress)
+	}
+	if !sameList(s.Phones, prev.Phones) {
+		fields = append(fields, FieldPhones)
+	}
+	if !sameList(s.Emails, prev.Emails) {
+		fields = append(fields, FieldEmails)
+	}
+	if !sameList(s.Attributes, prev.Attributes) {
+		fields = append(fields, FieldAttributes)
+	}
+	return fields
+}
+
+// sameList compares slices or maps, treating nil and empty ones as equal.
+func sameList(a, b interface{}) bool {
+	if reflect.ValueOf(a).Len() == 0 && reflect.ValueOf(b).Len() == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func (s Snapshot) Value() (driver.Value, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (s *Snapshot) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into snapshot", src)
+	}
+	return json.Unmarshal(data, s)
+}
@@ -0,0 +1,19 @@
+package model
+
+// SearchQuery looks users up by trigram similarity of Text to the name and
+// address search keys and of Phone to their phone numbers. Text must be
+// folded with SearchKey and Phone normalized like a phone pattern, an empty
+// Phone matches no number. Hits scoring below MinScore are dropped.
+type SearchQuery struct {
+	Text     string
+	Phone    string
+	MinScore float64
+	Limit    int
+}
+
+// SearchHit is a user found by a search together with its relevance score
+// between 0 and 1. Hits are ordered by descending score, then by ID.
+type SearchHit struct {
+	User  User
+	Score float64
+}
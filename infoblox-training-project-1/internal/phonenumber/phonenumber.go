@@ -0,0 +1,60 @@
+package phonenumber
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+var ErrInvalid = errors.New("invalid phone number")
+
+// Number is a phone number in its canonical E.164 form, used for storage and
+// comparison, together with a human readable international form.
+type Number struct {
+	E164    string
+	Display string
+}
+
+// Normalizer parses phone numbers, reading numbers without a country code
+// as numbers of the default region.
+type Normalizer struct {
+	region string
+}
+
+func NewNormalizer(defaultRegion string) (*Normalizer, error) {
+	region := strings.ToUpper(defaultRegion)
+	if !phonenumbers.GetSupportedRegions()[region] {
+		return nil, fmt.Errorf("unsupported phone region %q", defaultRegion)
+	}
+	return &Normalizer{region: region}, nil
+}
+
+func (n *Normalizer) Normalize(raw string) (Number, error) {
+	parsed, err := phonenumbers.Parse(raw, n.region)
+	if err != nil || !phonenumbers.IsValidNumber(parsed) {
+		return Number{}, ErrInvalid
+	}
+	return Number{
+		E164:    phonenumbers.Format(parsed, phonenumbers.E164),
+		Display: phonenumbers.Format(parsed, phonenumbers.INTERNATIONAL),
+	}, nil
+}
+
+// NormalizePattern strips formatting characters from a search pattern, so
+// that "+7 812*" matches canonical numbers. Patterns without wildcards are
+// normalized like full numbers when they are valid.
+func (n *Normalizer) NormalizePattern(pattern string) string {
+	if !strings.ContainsAny(pattern, "*%_") {
+		if number, err := n.Normalize(pattern); err == nil {
+			return number.E164
+		}
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" -().", r) {
+			return -1
+		}
+		return r
+	}, pattern)
+}
@@ -0,0 +1,71 @@
+package phonenumber_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/phonenumber"
+)
+
+type phoneNumberTestSuite struct {
+	suite.Suite
+	normalizer *phonenumber.Normalizer
+}
+
+func (suite *phoneNumberTestSuite) SetupTest() {
+	normalizer, err := phonenumber.NewNormalizer("ru")
+	suite.Require().NoError(err)
+	suite.normalizer = normalizer
+}
+
+func TestPhoneNumber(t *testing.T) {
+	suite.Run(t, new(phoneNumberTestSuite))
+}
+
+func (suite *phoneNumberTestSuite) TestNewNormalizer() {
+	_, err := phonenumber.NewNormalizer("xx")
+	suite.Error(err)
+}
+
+func (suite *phoneNumberTestSuite) TestNormalize() {
+	tests := map[string]struct {
+		raw            string
+		expectedNumber phonenumber.Number
+		expectedErr    error
+	}{
+		"national_prefix": {
+			raw:            "8-812-987-88-99",
+			expectedNumber: phonenumber.Number{E164: "+78129878899", Display: "+7 812 987-88-99"},
+		},
+		"international": {
+			raw:            "+7 (812) 987 88 99",
+			expectedNumber: phonenumber.Number{E164: "+78129878899", Display: "+7 812 987-88-99"},
+		},
+		"other_region": {
+			raw:            "+1 650-253-0000",
+			expectedNumber: phonenumber.Number{E164: "+16502530000", Display: "+1 650-253-0000"},
+		},
+		"too_short": {
+			raw:         "123",
+			expectedErr: phonenumber.ErrInvalid,
+		},
+		"not_a_number": {
+			raw:         "phone",
+			expectedErr: phonenumber.ErrInvalid,
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			number, err := suite.normalizer.Normalize(test.raw)
+			suite.Equal(test.expectedNumber, number)
+			suite.Equal(test.expectedErr, err)
+		})
+	}
+}
+
+func (suite *phoneNumberTestSuite) TestNormalizePattern() {
+	suite.Equal("+78129878899", suite.normalizer.NormalizePattern("8 812 987 88 99"))
+	suite.Equal("+7812*", suite.normalizer.NormalizePattern("+7 (812)*"))
+	suite.Equal("*8899", suite.normalizer.NormalizePattern("*88-99"))
+}
@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+// phoneKeyDigits is the number of trailing digits duplicate phone numbers
+// are compared by, enough for national numbers without the country or
+// trunk prefix. Numbers with fewer than minPhoneKeyDigits digits are not
+// compared at all.
+const (
+	phoneKeyDigits    = 10
+	minPhoneKeyDigits = 7
+)
+
+// duplicatePair is a pair of likely duplicate users, A < B.
+type duplicatePair struct {
+	A, B   uint
+	Reason string
+	Score  float64
+}
+
+// duplicateCluster is a group of user IDs joined by pairs.
+type duplicateCluster struct {
+	ids     []uint
+	reasons []string
+	score   float64
+}
+
+// clusterDuplicates joins pairs sharing a user into clusters, ordered and
+// limited like model.DuplicateGroup.
+func clusterDuplicates(pairs []duplicatePair, limit int) []duplicateCluster {
+	parent := map[uint]uint{}
+	var find func(id uint) uint
+	find = func(id uint) uint {
+		p, ok := parent[id]
+		if !ok || p == id {
+			return id
+		}
+		root := find(p)
+		parent[id] = root
+		return root
+	}
+	for _, p := range pairs {
+		a, b := find(p.A), find(p.B)
+		if b < a {
+			a, b = b, a
+		}
+		parent[a], parent[b] = a, a
+	}
+
+	byRoot := map[uint]*duplicateCluster{}
+	for id := range parent {
+		root := find(id)
+		if byRoot[root] == nil {
+			byRoot[root] = &duplicateCluster{}
+		}
+		byRoot[root].ids = append(byRoot[root].ids, id)
+	}
+	for _, p := range pairs {
+		c := byRoot[find(p.A)]
+		if p.Score > c.score {
+			c.score = p.Score
+		}
+		if !contains(c.reasons, p.Reason) {
+			c.reasons = append(c.reasons, p.Reason)
+		}
+	}
+
+	clusters := make([]duplicateCluster, 0, len(byRoot))
+	for _, c := range byRoot {
+		sort.Slice(c.ids, func(i, j int) bool { return c.ids[i] < c.ids[j] })
+		// Reasons are listed in a fixed order, not in the one pairs came in.
+		reasons := make([]string, 0, len(c.reasons))
+		for _, r := range []string{model.DuplicatePhone, model.DuplicateName} {
+			if contains(c.reasons, r) {
+				reasons = append(reasons, r)
+			}
+		}
+		c.reasons = reasons
+		clusters = append(clusters, *c)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].score != clusters[j].score {
+			return clusters[i].score > clusters[j].score
+		}
+		return clusters[i].ids[0] < clusters[j].ids[0]
+	})
+	if limit > 0 && len(clusters) > limit {
+		clusters = clusters[:limit]
+	}
+	return clusters
+}
+
+// duplicateGroups pairs the clusters with their users.
+func duplicateGroups(clusters []duplicateCluster, users []model.User) []model.DuplicateGroup {
+	byID := make(map[uint]model.User, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+	groups := make([]model.DuplicateGroup, 0, len(clusters))
+	for _, c := range clusters {
+		group := model.DuplicateGroup{Reasons: c.reasons, Score: c.score}
+		for _, id := range c.ids {
+			if u, ok := byID[id]; ok {
+				group.Users = append(group.Users, u)
+			}
+		}
+		if len(group.Users) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// clusterIDs lists the users of all clusters.
+func clusterIDs(clusters []duplicateCluster) []uint {
+	var ids []uint
+	for _, c := range clusters {
+		ids = append(ids, c.ids...)
+	}
+	return ids
+}
+
+// phoneKey returns the trailing digits of a number duplicates are compared
+// by, or an empty string for numbers too short to compare.
+func phoneKey(number string) string {
+	digits := make([]byte, 0, len(number))
+	for i := 0; i < len(number); i++ {
+		if number[i] >= '0' && number[i] <= '9' {
+			digits = append(digits, number[i])
+		}
+	}
+	if len(digits) < minPhoneKeyDigits {
+		return ""
+	}
+	if len(digits) > phoneKeyDigits {
+		digits = digits[len(digits)-phoneKeyDigits:]
+	}
+	return string(digits)
+}
+
+// nameScore is the similarity of two users by name and, when both have
+// one, by address, or 0 if either is below minScore.
+func nameScore(a, b model.User, minScore float64) float64 {
+	score := similarity(a.NameKey, b.NameKey)
+	if score == 0 || score < minScore {
+		return 0
+	}
+	if a.AddressKey == "" || b.AddressKey == "" {
+		return score
+	}
+	addressScore := similarity(a.AddressKey, b.AddressKey)
+	if addressScore < minScore {
+		return 0
+	}
+	return (score + addressScore) / 2
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
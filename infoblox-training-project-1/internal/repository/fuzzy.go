@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxEditLength is the longest word compared by edit distance, the limit
+// of fuzzystrmatch levenshtein().
+const maxEditLength = 255
+
+// words splits s into runs of letters and digits, the way pg_trgm does.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// trigrams returns the set of trigrams of s the way pg_trgm extracts them:
+// every word is padded with two spaces in front and one behind.
+func trigrams(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range words(s) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// sharesTrigram reports whether a and b have a trigram in common, which
+// Search requires of every hit so that the indexes can find it.
+func sharesTrigram(a, b string) bool {
+	tb := trigrams(b)
+	for t := range trigrams(a) {
+		if tb[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// oneTrigram is the pg_trgm word similarity of query to a string sharing a
+// single trigram with it, rounded down, 1 for a query without trigrams.
+func oneTrigram(query string) float64 {
+	n := len(trigrams(query))
+	if n == 0 {
+		return 1
+	}
+	return 0.99 / float64(n)
+}
+
+// similarity mirrors pg_trgm similarity(): the number of shared trigrams
+// divided by the number of distinct trigrams of both strings.
+func similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	union := len(ta) + len(tb) - shared
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
+
+// wordSimilarity scores a single word query against the closest word of s
+// as one minus their edit distance relative to the longer one. Trigrams
+// barely overlap for short words with swapped letters, e.g. "jhon" and
+// "john", which this catches. Queries of several words score 0.
+func wordSimilarity(s, query string) float64 {
+	q := []rune(query)
+	if qw := words(query); len(qw) != 1 || qw[0] != query || len(q) > maxEditLength {
+		return 0
+	}
+	best := 0.0
+	for _, word := range words(s) {
+		w := []rune(word)
+		if len(w) > maxEditLength {
+			continue
+		}
+		longer := len(w)
+		if len(q) > longer {
+			longer = len(q)
+		}
+		if score := 1 - float64(levenshtein(w, q))/float64(longer); score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,870 @@
+package repository
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+type MemoryStorage struct {
+	mu          sync.RWMutex
+	users       []model.User
+	lastID      uint
+	groups      []model.Group
+	lastGroupID uint
+	revisions   []model.UserRevision
+	lastRevID   uint
+	audit       []model.AuditEvent
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) Store(user model.User) (model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.store(user)
+}
+
+func (s *MemoryStorage) store(user model.User) (model.User, error) {
+	stored := clone(user)
+	stored.Normalize()
+	if s.phoneIsTaken(stored, 0) {
+		return model.User{}, model.ErrDuplicatePhone
+	}
+	s.lastID++
+	now := time.Now()
+	stored.Model = gorm.Model{ID: s.lastID, CreatedAt: now, UpdatedAt: now}
+	s.users = append(s.users, stored)
+	s.record(stored, model.RevisionCreated)
+	return clone(stored), nil
+}
+
+// Import stores the users like Storage.Import does.
+func (s *MemoryStorage) Import(users []model.User, onConflict string) ([]model.ImportResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]model.ImportResult, len(users))
+	for i, user := range users {
+		results[i] = s.importUser(user, onConflict)
+	}
+	return results, nil
+}
+
+func (s *MemoryStorage) importUser(user model.User, onConflict string) model.ImportResult {
+	stored, err := s.store(user)
+	switch {
+	case err == nil:
+		return model.ImportResult{Status: model.ImportCreated, User: stored}
+	case onConflict == model.OnConflictSkip:
+		return model.ImportResult{Status: model.ImportSkipped, Err: err}
+	case onConflict != model.OnConflictOverwrite:
+		return model.ImportResult{Status: model.ImportFailed, Err: err}
+	}
+
+	user.Normalize()
+	owner := -1
+	for i, u := range s.users {
+		if u.DeletedAt.Valid || !sharesPhone(u, user) {
+			continue
+		}
+		if owner >= 0 {
+			return model.ImportResult{Status: model.ImportFailed, Err: model.ErrSeveralOwners}
+		}
+		owner = i
+	}
+	if owner < 0 {
+		return model.ImportResult{Status: model.ImportFailed, Err: model.ErrDuplicatePhone}
+	}
+	updated, err := s.update(owner, user, model.UpdatableFields, model.RevisionUpdated)
+	if err != nil {
+		return model.ImportResult{Status: model.ImportFailed, Err: err}
+	}
+	return model.ImportResult{Status: model.ImportUpdated, User: updated}
+}
+
+func (s *MemoryStorage) Load(q model.Query) ([]model.User, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	match := matcher(q)
+	var matched []model.User
+	for _, user := range s.users {
+		if match(user) {
+			matched = append(matched, user)
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return compareUsers(matched[i], matched[j], q.OrderBy) < 0
+	})
+	after := q.After
+	after.ID = q.AfterID
+	users := []model.User{}
+	for _, user := range matched {
+		if q.AfterID > 0 && compareUsers(user, after, q.OrderBy) <= 0 {
+			continue
+		}
+		if q.Limit > 0 && len(users) == q.Limit {
+			break
+		}
+		users = append(users, clone(user))
+	}
+	return users, int64(len(matched)), nil
+}
+
+// Export calls fn for every user matching the filters of q in ID order.
+// The lock is held only while copying a batch, not while fn runs.
+func (s *MemoryStorage) Export(q model.Query, fn func(model.User) error) error {
+	match := matcher(q)
+	var lastID uint
+	for {
+		batch := s.exportBatch(match, lastID)
+		if len(batch) == 0 {
+			return nil
+		}
+		for _, user := range batch {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+}
+
+func (s *MemoryStorage) exportBatch(match func(model.User) bool, afterID uint) []model.User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var batch []model.User
+	for _, user := range s.users {
+		if user.ID > afterID && match(user) {
+			batch = append(batch, clone(user))
+			if len(batch) == ExportBatchSize {
+				break
+			}
+		}
+	}
+	return batch
+}
+
+// matcher returns a function that reports whether a user passes the
+// filters of q.
+func matcher(q model.Query) func(model.User) bool {
+	u := q.Filter
+	name, phone, address := likeToRegexp(u.Name), likeToRegexp(u.Phone), likeToRegexp(u.Address)
+	return func(user model.User) bool {
+		if user.DeletedAt.Valid != q.Deleted {
+			return false
+		}
+		if !name.MatchString(user.NameKey) || !hasPhone(user, phone) || !address.MatchString(user.AddressKey) {
+			return false
+		}
+		if !matchAddress(user.PostalAddress, u.PostalAddress) {
+			return false
+		}
+		if q.Group != "" && groupIndex(user.Groups, q.Group) < 0 {
+			return false
+		}
+		return q.Expr == nil || matchExpr(user, *q.Expr)
+	}
+}
+
+func (s *MemoryStorage) Search(q model.SearchQuery) ([]model.SearchHit, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hits := []model.SearchHit{}
+	for _, user := range s.users {
+		if user.DeletedAt.Valid {
+			continue
+		}
+		score := searchScore(user, q)
+		if score > 0 && score >= q.MinScore {
+			hits = append(hits, model.SearchHit{User: clone(user), Score: score})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Score > hits[j].Score
+	})
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, nil
+}
+
+// FindDuplicates compares every pair of live users the way
+// Storage.FindDuplicates does in SQL.
+func (s *MemoryStorage) FindDuplicates(q model.DuplicateQuery) ([]model.DuplicateGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var live []model.User
+	for _, user := range s.users {
+		if !user.DeletedAt.Valid {
+			live = append(live, user)
+		}
+	}
+	var pairs []duplicatePair
+	for i, a := range live {
+		for _, b := range live[i+1:] {
+			if sharesPhoneKey(a, b) {
+				pairs = append(pairs, duplicatePair{A: a.ID, B: b.ID, Reason: model.DuplicatePhone, Score: 1})
+			}
+			if score := nameScore(a, b, q.MinScore); score > 0 {
+				pairs = append(pairs, duplicatePair{A: a.ID, B: b.ID, Reason: model.DuplicateName, Score: score})
+			}
+		}
+	}
+
+	clusters := clusterDuplicates(pairs, q.Limit)
+	users := make([]model.User, 0, len(clusters))
+	for _, id := range clusterIDs(clusters) {
+		users = append(users, clone(s.users[s.indexOf(id, false)]))
+	}
+	return duplicateGroups(clusters, users), nil
+}
+
+// Merge resolves the fields of the survivor from all users of m and deletes
+// the others, changing nothing if any of them fails.
+func (s *MemoryStorage) Merge(m model.Merge) (model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	survivor := s.indexOf(m.Survivor, false)
+	if survivor < 0 {
+		return model.User{}, model.ErrNotFound
+	}
+	merged := make([]int, 0, len(m.Merged))
+	others := make([]model.User, 0, len(m.Merged))
+	for _, id := range m.Merged {
+		i := s.indexOf(id, false)
+		if i < 0 {
+			return model.User{}, model.ErrNotFound
+		}
+		merged = append(merged, i)
+		others = append(others, clone(s.users[i]))
+	}
+
+	result := model.MergeUsers(clone(s.users[survivor]), others, m.Resolution)
+	now := time.Now()
+	for _, i := range merged {
+		s.users[i].DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+	}
+	user, err := s.update(survivor, result, mergeFields, model.RevisionMerged)
+	if err != nil {
+		for _, i := range merged {
+			s.users[i].DeletedAt = gorm.DeletedAt{}
+		}
+		return model.User{}, err
+	}
+	for _, i := range merged {
+		s.record(s.users[i], model.RevisionDeleted)
+	}
+	return user, nil
+}
+
+// Delete deletes the matching users like Storage.Delete does.
+func (s *MemoryStorage) Delete(name string, expected int64) ([]model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pattern := likeToRegexp(name)
+	var matched []int
+	for i, user := range s.users {
+		if !user.DeletedAt.Valid && pattern.MatchString(user.NameKey) {
+			matched = append(matched, i)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, model.ErrNotFound
+	}
+	users := make([]model.User, 0, len(matched))
+	if expected != 0 && int64(len(matched)) != expected {
+		for _, i := range matched {
+			users = append(users, clone(s.users[i]))
+		}
+		return users, model.ErrCountMismatch
+	}
+	now := time.Now()
+	for _, i := range matched {
+		s.users[i].DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+		s.record(s.users[i], model.RevisionDeleted)
+		users = append(users, clone(s.users[i]))
+	}
+	return users, nil
+}
+
+func (s *MemoryStorage) Get(id uint) (model.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.indexOf(id, false)
+	if i < 0 {
+		return model.User{}, model.ErrNotFound
+	}
+	return clone(s.users[i]), nil
+}
+
+func (s *MemoryStorage) DeleteByID(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(id, false)
+	if i < 0 {
+		return model.ErrNotFound
+	}
+	s.users[i].DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	s.record(s.users[i], model.RevisionDeleted)
+	return nil
+}
+
+func (s *MemoryStorage) Restore(id uint) (model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(id, true)
+	if i < 0 {
+		return model.User{}, model.ErrNotFound
+	}
+	if s.phoneIsTaken(s.users[i], id) {
+		return model.User{}, model.ErrDuplicatePhone
+	}
+	s.users[i].DeletedAt = gorm.DeletedAt{}
+	s.record(s.users[i], model.RevisionRestored)
+	return clone(s.users[i]), nil
+}
+
+func (s *MemoryStorage) Purge(olderThan time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := s.users[:0]
+	kept := map[uint]bool{}
+	for _, user := range s.users {
+		if !user.DeletedAt.Valid || !user.DeletedAt.Time.Before(olderThan) {
+			users = append(users, user)
+			kept[user.ID] = true
+		}
+	}
+	purged := int64(len(s.users) - len(users))
+	s.users = users
+	revisions := s.revisions[:0]
+	for _, r := range s.revisions {
+		if kept[r.UserID] {
+			revisions = append(revisions, r)
+		}
+	}
+	s.revisions = revisions
+	return purged, nil
+}
+
+func (s *MemoryStorage) Update(phone string, updatedUser model.User, fields []string) (model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if !s.users[i].DeletedAt.Valid && s.users[i].Phone == phone {
+			return s.update(i, updatedUser, fields, model.RevisionUpdated)
+		}
+	}
+	return model.User{}, model.ErrNotFound
+}
+
+func (s *MemoryStorage) UpdateByID(id uint, updatedUser model.User, fields []string) (model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(id, false)
+	if i < 0 {
+		return model.User{}, model.ErrNotFound
+	}
+	return s.update(i, updatedUser, fields, model.RevisionUpdated)
+}
+
+func (s *MemoryStorage) update(i int, updatedUser model.User, fields []string, action string) (model.User, error) {
+	user := clone(s.users[i])
+	user.Merge(updatedUser, fields)
+	if s.phoneIsTaken(user, user.ID) {
+		return model.User{}, model.ErrDuplicatePhone
+	}
+	user.UpdatedAt = time.Now()
+	s.users[i] = user
+	s.record(user, action)
+	return clone(user), nil
+}
+
+func (s *MemoryStorage) History(id uint) ([]model.UserRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.indexOf(id, false) < 0 && s.indexOf(id, true) < 0 {
+		return nil, model.ErrNotFound
+	}
+	revisions := []model.UserRevision{}
+	for _, r := range s.revisions {
+		if r.UserID == id {
+			revisions = append(revisions, r)
+		}
+	}
+	return revisions, nil
+}
+
+func (s *MemoryStorage) Revert(id, revision uint) (model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.revisions {
+		if r.UserID != id || r.Revision != revision {
+			continue
+		}
+		i := s.indexOf(id, false)
+		if i < 0 {
+			return model.User{}, model.ErrNotFound
+		}
+		return s.update(i, r.Snapshot.User(), model.SnapshotFields, model.RevisionReverted)
+	}
+	return model.User{}, model.ErrNoRevision
+}
+
+// record adds a revision with the action to the history of the user.
+func (s *MemoryStorage) record(user model.User, action string) {
+	var latest uint
+	for _, r := range s.revisions {
+		if r.UserID == user.ID {
+			latest = r.Revision
+		}
+	}
+	s.lastRevID++
+	s.revisions = append(s.revisions, model.UserRevision{
+		ID:        s.lastRevID,
+		UserID:    user.ID,
+		Revision:  latest + 1,
+		Action:    action,
+		CreatedAt: time.Now(),
+		Snapshot:  model.NewSnapshot(user),
+	})
+}
+
+func (s *MemoryStorage) StoreGroup(group model.Group) (model.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group.Normalize()
+	if groupIndex(s.groups, group.Key) >= 0 {
+		return model.Group{}, model.ErrDuplicateGroup
+	}
+	s.lastGroupID++
+	now := time.Now()
+	group.ID, group.CreatedAt, group.UpdatedAt = s.lastGroupID, now, now
+	s.groups = append(s.groups, group)
+	return group, nil
+}
+
+func (s *MemoryStorage) StoreAuditEvent(event model.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event.ID = uint(len(s.audit) + 1)
+	event.CreatedAt = time.Now()
+	s.audit = append(s.audit, event)
+	return nil
+}
+
+func (s *MemoryStorage) LoadAuditEvents(q model.AuditQuery) ([]model.AuditEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := []model.AuditEvent{}
+	for i := len(s.audit) - 1; i >= 0 && (q.Limit <= 0 || len(events) < q.Limit); i-- {
+		e := s.audit[i]
+		switch {
+		case !q.From.IsZero() && e.CreatedAt.Before(q.From),
+			!q.To.IsZero() && !e.CreatedAt.Before(q.To),
+			q.Actor != "" && e.Actor != q.Actor,
+			q.BeforeID > 0 && e.ID >= q.BeforeID:
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (s *MemoryStorage) LoadGroups() ([]model.Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	groups := append([]model.Group{}, s.groups...)
+	sortGroups(groups)
+	return groups, nil
+}
+
+func (s *MemoryStorage) GetGroup(id uint) (model.Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.groupIndexOf(id)
+	if i < 0 {
+		return model.Group{}, model.ErrGroupNotFound
+	}
+	return s.groups[i], nil
+}
+
+// UpdateGroup changes the group and its copies held by the members.
+func (s *MemoryStorage) UpdateGroup(id uint, updatedGroup model.Group, fields []string) (model.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.groupIndexOf(id)
+	if i < 0 {
+		return model.Group{}, model.ErrGroupNotFound
+	}
+	group := s.groups[i]
+	for _, field := range fields {
+		switch field {
+		case model.FieldGroupName:
+			group.Name = updatedGroup.Name
+		case model.FieldGroupDescription:
+			group.Description = updatedGroup.Description
+		}
+	}
+	group.Normalize()
+	if j := groupIndex(s.groups, group.Key); j >= 0 && j != i {
+		return model.Group{}, model.ErrDuplicateGroup
+	}
+	group.UpdatedAt = time.Now()
+	s.groups[i] = group
+	for u := range s.users {
+		for g := range s.users[u].Groups {
+			if s.users[u].Groups[g].ID == id {
+				s.users[u].Groups[g] = group
+			}
+		}
+		sortGroups(s.users[u].Groups)
+	}
+	return group, nil
+}
+
+func (s *MemoryStorage) DeleteGroup(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.groupIndexOf(id)
+	if i < 0 {
+		return model.ErrGroupNotFound
+	}
+	s.groups = append(s.groups[:i], s.groups[i+1:]...)
+	for u := range s.users {
+		s.users[u].Groups = withoutGroup(s.users[u].Groups, id)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) AddGroupMembers(id uint, userIDs []uint) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := s.groupIndexOf(id)
+	if g < 0 {
+		return 0, model.ErrGroupNotFound
+	}
+	members := make([]int, 0, len(userIDs))
+	for _, userID := range userIDs {
+		i := s.indexOf(userID, false)
+		if i < 0 {
+			return 0, model.ErrNotFound
+		}
+		members = append(members, i)
+	}
+	var added int64
+	for _, i := range members {
+		if groupIndex(s.users[i].Groups, s.groups[g].Key) >= 0 {
+			continue
+		}
+		groups := append(append([]model.Group(nil), s.users[i].Groups...), s.groups[g])
+		sortGroups(groups)
+		s.users[i].Groups = groups
+		added++
+	}
+	return added, nil
+}
+
+func (s *MemoryStorage) RemoveGroupMembers(id uint, userIDs []uint) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.groupIndexOf(id) < 0 {
+		return 0, model.ErrGroupNotFound
+	}
+	var removed int64
+	for i, user := range s.users {
+		for _, userID := range userIDs {
+			if user.ID != userID {
+				continue
+			}
+			if groups := withoutGroup(user.Groups, id); len(groups) < len(user.Groups) {
+				s.users[i].Groups = groups
+				removed++
+			}
+		}
+	}
+	return removed, nil
+}
+
+func (s *MemoryStorage) groupIndexOf(id uint) int {
+	for i, group := range s.groups {
+		if group.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// groupIndex returns the position of the group with the given key or -1.
+func groupIndex(groups []model.Group, key string) int {
+	for i, group := range groups {
+		if group.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// withoutGroup returns a copy of groups without the one with the given id.
+func withoutGroup(groups []model.Group, id uint) []model.Group {
+	var kept []model.Group
+	for _, group := range groups {
+		if group.ID != id {
+			kept = append(kept, group)
+		}
+	}
+	return kept
+}
+
+func sortGroups(groups []model.Group) {
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Key != groups[j].Key {
+			return groups[i].Key < groups[j].Key
+		}
+		return groups[i].ID < groups[j].ID
+	})
+}
+
+// indexOf returns the position of the live or soft deleted user with the given id or -1.
+func (s *MemoryStorage) indexOf(id uint, deleted bool) int {
+	for i, user := range s.users {
+		if user.ID == id && user.DeletedAt.Valid == deleted {
+			return i
+		}
+	}
+	return -1
+}
+
+// phoneIsTaken reports whether any number of u belongs to a live user other than the one with the given id.
+func (s *MemoryStorage) phoneIsTaken(u model.User, id uint) bool {
+	for _, user := range s.users {
+		if !user.DeletedAt.Valid && user.ID != id && sharesPhone(user, u) {
+			return true
+		}
+	}
+	return false
+}
+
+func sharesPhone(a, b model.User) bool {
+	for _, p := range a.Phones {
+		for _, q := range b.Phones {
+			if p.Number == q.Number {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sharesPhoneKey reports whether two users have numbers with the same
+// phoneKey.
+func sharesPhoneKey(a, b model.User) bool {
+	for _, p := range a.Phones {
+		key := phoneKey(p.Number)
+		if key == "" {
+			continue
+		}
+		for _, q := range b.Phones {
+			if phoneKey(q.Number) == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasPhone reports whether any number of the user matches pattern.
+func hasPhone(user model.User, pattern *regexp.Regexp) bool {
+	if pattern.MatchString(user.Phone) {
+		return true
+	}
+	for _, p := range user.Phones {
+		if pattern.MatchString(p.Number) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAddress reports whether every non-empty component pattern of filter
+// matches the address, ignoring case.
+func matchAddress(a, filter model.PostalAddress) bool {
+	components := [][2]string{
+		{a.Street, filter.Street},
+		{a.City, filter.City},
+		{a.Region, filter.Region},
+		{a.PostalCode, filter.PostalCode},
+		{a.CountryCode, filter.CountryCode},
+	}
+	for _, c := range components {
+		if c[1] != "" && !likeToRegexp(strings.ToLower(c[1])).MatchString(strings.ToLower(c[0])) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchExpr evaluates a filter expression the way Storage does in SQL.
+func matchExpr(user model.User, e model.Expr) bool {
+	switch e.Kind {
+	case model.ExprAnd:
+		for _, operand := range e.Operands {
+			if !matchExpr(user, operand) {
+				return false
+			}
+		}
+		return true
+	case model.ExprOr:
+		for _, operand := range e.Operands {
+			if matchExpr(user, operand) {
+				return true
+			}
+		}
+		return false
+	case model.ExprNot:
+		return len(e.Operands) == 1 && !matchExpr(user, e.Operands[0])
+	case model.ExprExists:
+		for _, value := range exprValues(user, e) {
+			if value != "" {
+				return true
+			}
+		}
+		return false
+	}
+	pattern := likeToRegexp(strings.ToLower(e.Pattern))
+	for _, value := range exprValues(user, e) {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// exprValues returns the values a filter expression matches its field
+// against, lowercased where the match ignores case.
+func exprValues(user model.User, e model.Expr) []string {
+	a := user.PostalAddress
+	switch e.Field {
+	case model.FieldName:
+		return []string{user.NameKey}
+	case model.FieldAddress:
+		return []string{user.AddressKey}
+	case model.FieldPhone:
+		values := []string{user.Phone}
+		for _, p := range user.Phones {
+			values = append(values, p.Number)
+		}
+		return values
+	case model.FieldStreet:
+		return []string{strings.ToLower(a.Street)}
+	case model.FieldCity:
+		return []string{strings.ToLower(a.City)}
+	case model.FieldRegion:
+		return []string{strings.ToLower(a.Region)}
+	case model.FieldPostalCode:
+		return []string{strings.ToLower(a.PostalCode)}
+	case model.FieldCountryCode:
+		return []string{strings.ToLower(a.CountryCode)}
+	case model.FieldEmail:
+		values := make([]string, 0, len(user.Emails))
+		for _, email := range user.Emails {
+			values = append(values, strings.ToLower(email.Address))
+		}
+		return values
+	case model.FieldAttribute:
+		return []string{strings.ToLower(user.Attributes[e.Key])}
+	}
+	return nil
+}
+
+// searchScore is the best similarity of the query to the name, the address
+// or any phone number of the user, as computed by Storage.Search.
+func searchScore(user model.User, q model.SearchQuery) float64 {
+	score := similarity(user.NameKey, q.Text)
+	if s := similarity(user.AddressKey, q.Text); s > score {
+		score = s
+	}
+	if key := user.NameKey + " " + user.AddressKey; sharesTrigram(key, q.Text) {
+		if s := wordSimilarity(key, q.Text); s > score {
+			score = s
+		}
+	}
+	if q.Phone == "" {
+		return score
+	}
+	for _, p := range user.Phones {
+		if s := similarity(p.Number, q.Phone); s > score {
+			score = s
+		}
+	}
+	return score
+}
+
+// clone returns a copy of the user that shares no slices with the original.
+func clone(user model.User) model.User {
+	user.Phones = append([]model.PhoneNumber(nil), user.Phones...)
+	user.Emails = append([]model.Email(nil), user.Emails...)
+	user.Groups = append([]model.Group(nil), user.Groups...)
+	user.Attributes = user.Attributes.Clone()
+	return user
+}
+
+// likeToRegexp compiles a SQL LIKE pattern, where % matches any sequence
+// of characters, _ matches a single character and \ escapes the next one,
+// into a regular expression.
+func likeToRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
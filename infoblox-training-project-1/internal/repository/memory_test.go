@@ -0,0 +1,760 @@
+package repository_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+	"github.com/vstarostin/infoblox-training-project-1/internal/repository"
+)
+
+var (
+	john = model.User{Name: "john", Phone: "8-812-987-88-99", Address: "moscow"}
+	jane = model.User{Name: "jane", Phone: "1-343-122-43-56", Address: "new york"}
+)
+
+type memoryTestSuite struct {
+	suite.Suite
+	storage *repository.MemoryStorage
+}
+
+func (suite *memoryTestSuite) SetupTest() {
+	suite.storage = repository.NewMemoryStorage()
+	_, err := suite.storage.Store(john)
+	suite.Require().NoError(err)
+	_, err = suite.storage.Store(jane)
+	suite.Require().NoError(err)
+}
+
+func TestMemoryStorage(t *testing.T) {
+	suite.Run(t, new(memoryTestSuite))
+}
+
+func (suite *memoryTestSuite) TestMemoryStore() {
+	_, err := suite.storage.Store(model.User{Name: "jack", Phone: john.Phone, Address: "paris"})
+	suite.Equal(model.ErrDuplicatePhone, err)
+
+	stored, err := suite.storage.Store(model.User{Name: "jack", Phone: "2-222-222-22-22", Address: "paris"})
+	suite.NoError(err)
+	suite.Equal(uint(3), stored.ID)
+	suite.Equal("jack", stored.Name)
+
+	users, total, err := suite.storage.Load(model.Query{Filter: model.User{Name: "%", Phone: "%", Address: "%"}})
+	suite.NoError(err)
+	suite.Len(users, 3)
+	suite.Equal(int64(3), total)
+}
+
+func (suite *memoryTestSuite) TestMemoryLoad() {
+	tests := map[string]struct {
+		pattern       model.User
+		expectedNames []string
+	}{
+		"all": {
+			pattern:       model.User{Name: "%", Phone: "%", Address: "%"},
+			expectedNames: []string{"john", "jane"},
+		},
+		"prefix": {
+			pattern:       model.User{Name: "j%", Phone: "8%", Address: "%"},
+			expectedNames: []string{"john"},
+		},
+		"single_char": {
+			pattern:       model.User{Name: "ja_e", Phone: "%", Address: "new%"},
+			expectedNames: []string{"jane"},
+		},
+		"exact_phone": {
+			pattern:       model.User{Name: "%", Phone: jane.Phone, Address: "%"},
+			expectedNames: []string{"jane"},
+		},
+		"no_match": {
+			pattern:       model.User{Name: "jo", Phone: "%", Address: "%"},
+			expectedNames: []string{},
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			users, total, err := suite.storage.Load(model.Query{Filter: test.pattern})
+			suite.NoError(err)
+			suite.Equal(int64(len(test.expectedNames)), total)
+			names := []string{}
+			for _, u := range users {
+				names = append(names, u.Name)
+			}
+			suite.Equal(test.expectedNames, names)
+		})
+	}
+}
+
+func (suite *memoryTestSuite) TestMemoryLoadPage() {
+	_, err := suite.storage.Store(model.User{Name: "jack", Phone: "2-222-222-22-22", Address: "paris"})
+	suite.Require().NoError(err)
+	all := model.User{Name: "%", Phone: "%", Address: "%"}
+
+	users, total, err := suite.storage.Load(model.Query{Filter: all, Limit: 2})
+	suite.NoError(err)
+	suite.Equal(int64(3), total)
+	suite.Require().Len(users, 2)
+	suite.Equal("jane", users[1].Name)
+
+	users, total, err = suite.storage.Load(model.Query{Filter: all, AfterID: users[1].ID, Limit: 2})
+	suite.NoError(err)
+	suite.Equal(int64(3), total)
+	suite.Require().Len(users, 1)
+	suite.Equal("jack", users[0].Name)
+}
+
+func (suite *memoryTestSuite) TestMemoryLoadOrdered() {
+	_, err := suite.storage.Store(model.User{Name: "jack", Phone: "2-222-222-22-22", Address: "moscow"})
+	suite.Require().NoError(err)
+	all := model.User{Name: "%", Phone: "%", Address: "%"}
+	order := []model.Order{{Field: model.FieldAddress}, {Field: model.FieldName, Desc: true}}
+
+	users, total, err := suite.storage.Load(model.Query{Filter: all, OrderBy: order, Limit: 2})
+	suite.NoError(err)
+	suite.Equal(int64(3), total)
+	suite.Require().Len(users, 2)
+	suite.Equal("john", users[0].Name)
+	suite.Equal("jack", users[1].Name)
+
+	users, _, err = suite.storage.Load(model.Query{Filter: all, OrderBy: order, AfterID: users[1].ID, After: users[1], Limit: 2})
+	suite.NoError(err)
+	suite.Require().Len(users, 1)
+	suite.Equal("jane", users[0].Name)
+}
+
+func (suite *memoryTestSuite) TestMemoryDelete() {
+	matched, err := suite.storage.Delete("j%", 1)
+	suite.Equal(model.ErrCountMismatch, err)
+	suite.Len(matched, 2)
+	_, err = suite.storage.Delete("j%", 3)
+	suite.Equal(model.ErrCountMismatch, err)
+
+	deleted, err := suite.storage.Delete("j%", 2)
+	suite.NoError(err)
+	suite.Equal([]string{"john", "jane"}, []string{deleted[0].Name, deleted[1].Name})
+	suite.True(deleted[0].DeletedAt.Valid)
+
+	_, err = suite.storage.Delete("j%", 0)
+	suite.Equal(model.ErrNotFound, err)
+
+	all := model.User{Name: "%", Phone: "%", Address: "%"}
+	users, _, err := suite.storage.Load(model.Query{Filter: all})
+	suite.NoError(err)
+	suite.Empty(users)
+	users, _, err = suite.storage.Load(model.Query{Filter: all, Deleted: true})
+	suite.NoError(err)
+	suite.Len(users, 2)
+	suite.True(users[0].DeletedAt.Valid)
+}
+
+func (suite *memoryTestSuite) TestMemoryUpdate() {
+	updated := model.User{Name: "john doe", Phone: "3-333-333-33-33", Address: "london"}
+	user, err := suite.storage.Update(john.Phone, updated, model.UpdatableFields)
+	suite.NoError(err)
+	suite.Equal(updated.Name, user.Name)
+
+	users, _, err := suite.storage.Load(model.Query{Filter: model.User{Name: "%", Phone: updated.Phone, Address: "%"}})
+	suite.NoError(err)
+	suite.Require().Len(users, 1)
+	suite.Equal(updated.Name, users[0].Name)
+	suite.Equal(updated.Address, users[0].Address)
+
+	_, err = suite.storage.Update(updated.Phone, model.User{Phone: jane.Phone}, []string{model.FieldPhone})
+	suite.Equal(model.ErrDuplicatePhone, err)
+
+	_, err = suite.storage.Update(john.Phone, updated, model.UpdatableFields)
+	suite.Equal(model.ErrNotFound, err)
+}
+
+func (suite *memoryTestSuite) TestMemoryUpdateFields() {
+	user, err := suite.storage.Update(jane.Phone, model.User{Address: "boston"}, []string{model.FieldAddress})
+	suite.NoError(err)
+	suite.Equal(jane.Name, user.Name)
+	suite.Equal(jane.Phone, user.Phone)
+	suite.Equal("boston", user.Address)
+}
+
+func (suite *memoryTestSuite) TestMemoryByID() {
+	user, err := suite.storage.Get(2)
+	suite.NoError(err)
+	suite.Equal(jane.Name, user.Name)
+
+	user, err = suite.storage.UpdateByID(2, model.User{Name: "jane doe"}, []string{model.FieldName})
+	suite.NoError(err)
+	suite.Equal("jane doe", user.Name)
+	suite.Equal(jane.Phone, user.Phone)
+
+	_, err = suite.storage.UpdateByID(2, model.User{Phone: john.Phone}, []string{model.FieldPhone})
+	suite.Equal(model.ErrDuplicatePhone, err)
+
+	suite.NoError(suite.storage.DeleteByID(2))
+	_, err = suite.storage.Get(2)
+	suite.Equal(model.ErrNotFound, err)
+	suite.Equal(model.ErrNotFound, suite.storage.DeleteByID(2))
+}
+
+func (suite *memoryTestSuite) TestMemoryRestore() {
+	suite.Require().NoError(suite.storage.DeleteByID(1))
+	_, err := suite.storage.Store(model.User{Name: "jack", Phone: john.Phone, Address: "paris"})
+	suite.Require().NoError(err)
+
+	_, err = suite.storage.Restore(1)
+	suite.Equal(model.ErrDuplicatePhone, err)
+
+	suite.Require().NoError(suite.storage.DeleteByID(3))
+	user, err := suite.storage.Restore(1)
+	suite.NoError(err)
+	suite.Equal(john.Name, user.Name)
+	suite.False(user.DeletedAt.Valid)
+
+	_, err = suite.storage.Restore(1)
+	suite.Equal(model.ErrNotFound, err)
+}
+
+func (suite *memoryTestSuite) TestMemoryPurge() {
+	suite.Require().NoError(suite.storage.DeleteByID(1))
+
+	purged, err := suite.storage.Purge(time.Now().Add(-time.Hour))
+	suite.NoError(err)
+	suite.Equal(int64(0), purged)
+
+	purged, err = suite.storage.Purge(time.Now().Add(time.Second))
+	suite.NoError(err)
+	suite.Equal(int64(1), purged)
+	_, err = suite.storage.Restore(1)
+	suite.Equal(model.ErrNotFound, err)
+	_, err = suite.storage.Get(2)
+	suite.NoError(err)
+}
+
+func (suite *memoryTestSuite) TestMemoryContacts() {
+	jack := model.User{
+		Name:   "jack",
+		Phones: []model.PhoneNumber{{Number: "2-222-222-22-22"}, {Number: "3-333-333-33-33", Type: model.PhoneWork, Primary: true}},
+		Emails: []model.Email{{Address: "jack@example.com"}},
+	}
+	stored, err := suite.storage.Store(jack)
+	suite.Require().NoError(err)
+	suite.Equal("3-333-333-33-33", stored.Phone)
+
+	users, _, err := suite.storage.Load(model.Query{Filter: model.User{Name: "%", Phone: "2-222%", Address: "%"}})
+	suite.NoError(err)
+	suite.Require().Len(users, 1)
+	suite.Equal("jack", users[0].Name)
+	suite.Len(users[0].Emails, 1)
+
+	_, err = suite.storage.Store(model.User{Name: "jill", Phones: []model.PhoneNumber{{Number: "2-222-222-22-22"}}})
+	suite.Equal(model.ErrDuplicatePhone, err)
+
+	_, err = suite.storage.UpdateByID(1, model.User{Phones: []model.PhoneNumber{{Number: "9-999"}, {Number: "3-333-333-33-33"}}}, []string{model.FieldPhones})
+	suite.Equal(model.ErrDuplicatePhone, err)
+
+	user, err := suite.storage.UpdateByID(stored.ID, model.User{Phone: "4-444"}, []string{model.FieldPhone})
+	suite.NoError(err)
+	suite.Equal("4-444", user.Phone)
+	suite.Require().Len(user.Phones, 2)
+	suite.Equal("2-222-222-22-22", user.Phones[0].Number)
+	suite.Equal(model.PhoneWork, user.Phones[1].Type)
+
+	suite.Require().NoError(suite.storage.DeleteByID(stored.ID))
+	_, err = suite.storage.Store(model.User{Name: "jill", Phones: []model.PhoneNumber{{Number: "2-222-222-22-22"}}})
+	suite.NoError(err)
+}
+
+func (suite *memoryTestSuite) TestMemoryPostalAddress() {
+	postal := model.PostalAddress{Street: "Baker Street 221b", City: "London", PostalCode: "NW1 6XE", CountryCode: "GB"}
+	stored, err := suite.storage.Store(model.User{Name: "sherlock", Phone: "4-444", PostalAddress: postal})
+	suite.Require().NoError(err)
+	suite.Equal("Baker Street 221b, London, NW1 6XE, GB", stored.Address)
+
+	all := model.User{Name: "%", Phone: "%", Address: "%"}
+	byCity := all
+	byCity.PostalAddress = model.PostalAddress{City: "lon%", CountryCode: "gb"}
+	users, _, err := suite.storage.Load(model.Query{Filter: byCity})
+	suite.NoError(err)
+	suite.Require().Len(users, 1)
+	suite.Equal("sherlock", users[0].Name)
+
+	byAddress := all
+	byAddress.Address = "%london%"
+	users, _, err = suite.storage.Load(model.Query{Filter: byAddress})
+	suite.NoError(err)
+	suite.Len(users, 1)
+
+	user, err := suite.storage.UpdateByID(stored.ID, model.User{Address: "paris"}, []string{model.FieldAddress})
+	suite.NoError(err)
+	suite.Equal("paris", user.Address)
+	suite.True(user.PostalAddress.IsZero())
+}
+
+func (suite *memoryTestSuite) TestMemorySearchKeys() {
+	stored, err := suite.storage.Store(model.User{Name: "John McDonald", Phone: "4-444", Address: "Straße 1, Berlin"})
+	suite.Require().NoError(err)
+	suite.Equal("John McDonald", stored.Name)
+
+	users, _, err := suite.storage.Load(model.Query{Filter: model.User{Name: "john mc%", Phone: "%", Address: "strasse%"}})
+	suite.NoError(err)
+	suite.Require().Len(users, 1)
+	suite.Equal("John McDonald", users[0].Name)
+	suite.Equal("Straße 1, Berlin", users[0].Address)
+
+	deleted, err := suite.storage.Delete("john mcdonald", 1)
+	suite.NoError(err)
+	suite.Len(deleted, 1)
+}
+
+func (suite *memoryTestSuite) TestMemoryExpr() {
+	_, err := suite.storage.Store(model.User{
+		Name:          "sherlock",
+		Phone:         "3-333",
+		PostalAddress: model.PostalAddress{Street: "Baker St 221b", City: "London"},
+		Emails:        []model.Email{{Address: "Sherlock@example.com"}},
+		Attributes:    model.Attributes{"customerId": "C-42", "manager": "Lestrade"},
+	})
+	suite.Require().NoError(err)
+	all := model.User{Name: "%", Phone: "%", Address: "%"}
+	tests := map[string]struct {
+		expr          model.Expr
+		expectedNames []string
+	}{
+		"match": {
+			expr:          model.Expr{Kind: model.ExprMatch, Field: model.FieldName, Pattern: "j%"},
+			expectedNames: []string{"john", "jane"},
+		},
+		"exists": {
+			expr:          model.Expr{Kind: model.ExprExists, Field: model.FieldEmail},
+			expectedNames: []string{"sherlock"},
+		},
+		"not_exists": {
+			expr:          model.Expr{Kind: model.ExprNot, Operands: []model.Expr{{Kind: model.ExprExists, Field: model.FieldStreet}}},
+			expectedNames: []string{"john", "jane"},
+		},
+		"case_insensitive_component": {
+			expr:          model.Expr{Kind: model.ExprMatch, Field: model.FieldEmail, Pattern: "sherlock@%"},
+			expectedNames: []string{"sherlock"},
+		},
+		"and_or": {
+			expr: model.Expr{Kind: model.ExprAnd, Operands: []model.Expr{
+				{Kind: model.ExprMatch, Field: model.FieldName, Pattern: "j%"},
+				{Kind: model.ExprOr, Operands: []model.Expr{
+					{Kind: model.ExprMatch, Field: model.FieldAddress, Pattern: "new york"},
+					{Kind: model.ExprMatch, Field: model.FieldCity, Pattern: "london"},
+				}},
+			}},
+			expectedNames: []string{"jane"},
+		},
+		"escaped_wildcard": {
+			expr:          model.Expr{Kind: model.ExprMatch, Field: model.FieldName, Pattern: `j\%`},
+			expectedNames: nil,
+		},
+		"attribute": {
+			expr:          model.Expr{Kind: model.ExprMatch, Field: model.FieldAttribute, Key: "customerId", Pattern: "c-4%"},
+			expectedNames: []string{"sherlock"},
+		},
+		"attribute_exists": {
+			expr:          model.Expr{Kind: model.ExprExists, Field: model.FieldAttribute, Key: "manager"},
+			expectedNames: []string{"sherlock"},
+		},
+		"other_attribute": {
+			expr:          model.Expr{Kind: model.ExprMatch, Field: model.FieldAttribute, Key: "manager", Pattern: "c-4%"},
+			expectedNames: nil,
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			expr := test.expr
+			users, total, err := suite.storage.Load(model.Query{Filter: all, Expr: &expr})
+			suite.NoError(err)
+			suite.Equal(int64(len(test.expectedNames)), total)
+			var names []string
+			for _, u := range users {
+				names = append(names, u.Name)
+			}
+			suite.Equal(test.expectedNames, names)
+		})
+	}
+}
+
+func (suite *memoryTestSuite) TestMemorySearch() {
+	tests := map[string]struct {
+		query         model.SearchQuery
+		expectedNames []string
+		expectedScore float64
+	}{
+		"typo_in_name": {
+			query:         model.SearchQuery{Text: "jhon", MinScore: 0.3},
+			expectedNames: []string{"john"},
+			expectedScore: 0.5,
+		},
+		"typo_in_address": {
+			query:         model.SearchQuery{Text: "new yrok", MinScore: 0.3},
+			expectedNames: []string{"jane"},
+			expectedScore: 5.0 / 13,
+		},
+		"phone": {
+			query:         model.SearchQuery{Text: john.Phone, Phone: john.Phone, MinScore: 0.3},
+			expectedNames: []string{"john"},
+			expectedScore: 1,
+		},
+		"ranked_and_limited": {
+			query:         model.SearchQuery{Text: "ja", MinScore: 0.1, Limit: 1},
+			expectedNames: []string{"jane"},
+			expectedScore: 0.5,
+		},
+		"no_match": {
+			query: model.SearchQuery{Text: "zzz", MinScore: 0.3},
+		},
+		"typo_without_shared_trigram": {
+			query: model.SearchQuery{Text: "xohx", MinScore: 0.3},
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			hits, err := suite.storage.Search(test.query)
+			suite.NoError(err)
+			var names []string
+			for _, hit := range hits {
+				names = append(names, hit.User.Name)
+			}
+			suite.Equal(test.expectedNames, names)
+			if len(hits) > 0 {
+				suite.InDelta(test.expectedScore, hits[0].Score, 1e-9)
+			}
+		})
+	}
+}
+
+func (suite *memoryTestSuite) TestMemoryExport() {
+	all := model.User{Name: "%", Phone: "%", Address: "%"}
+	expr := model.Expr{Kind: model.ExprMatch, Field: model.FieldName, Pattern: "j%"}
+	var names []string
+	err := suite.storage.Export(model.Query{Filter: all, Expr: &expr}, func(u model.User) error {
+		names = append(names, u.Name)
+		return nil
+	})
+	suite.NoError(err)
+	suite.Equal([]string{"john", "jane"}, names)
+
+	stop := errors.New("stop")
+	names = nil
+	err = suite.storage.Export(model.Query{Filter: all}, func(u model.User) error {
+		names = append(names, u.Name)
+		return stop
+	})
+	suite.Equal(stop, err)
+	suite.Equal([]string{"john"}, names)
+}
+
+func (suite *memoryTestSuite) TestMemoryImport() {
+	jack := model.User{Name: "jack", Phone: "2-222-222-22-22", Address: "paris"}
+	takenByJohn := model.User{Name: "johnny", Phone: john.Phone, Address: "london"}
+	takenByBoth := model.User{Name: "both", Phones: []model.PhoneNumber{{Number: john.Phone}, {Number: jane.Phone}}}
+
+	results, err := suite.storage.Import([]model.User{jack, takenByJohn, takenByBoth}, model.OnConflictSkip)
+	suite.NoError(err)
+	suite.Require().Len(results, 3)
+	suite.Equal(model.ImportCreated, results[0].Status)
+	suite.Equal(uint(3), results[0].User.ID)
+	suite.Equal(model.ImportResult{Status: model.ImportSkipped, Err: model.ErrDuplicatePhone}, results[1])
+	suite.Equal(model.ImportSkipped, results[2].Status)
+
+	results, err = suite.storage.Import([]model.User{takenByJohn}, model.OnConflictFail)
+	suite.NoError(err)
+	suite.Equal([]model.ImportResult{{Status: model.ImportFailed, Err: model.ErrDuplicatePhone}}, results)
+
+	results, err = suite.storage.Import([]model.User{takenByJohn, takenByBoth}, model.OnConflictOverwrite)
+	suite.NoError(err)
+	suite.Require().Len(results, 2)
+	suite.Equal(model.ImportUpdated, results[0].Status)
+	suite.Equal(uint(1), results[0].User.ID)
+	suite.Equal("johnny", results[0].User.Name)
+	suite.Equal("london", results[0].User.Address)
+	suite.Equal(model.ImportResult{Status: model.ImportFailed, Err: model.ErrSeveralOwners}, results[1])
+}
+
+func (suite *memoryTestSuite) TestMemoryFindDuplicates() {
+	for _, u := range []model.User{
+		{Name: "ivan", Phone: "+78129878899", Address: "kazan"},
+		{Name: "Jane", Phone: "2-222-222-22-22", Address: "New York, NY"},
+		{Name: "jane", Phone: "3-333-333-33-33", Address: "paris"},
+	} {
+		_, err := suite.storage.Store(u)
+		suite.Require().NoError(err)
+	}
+	suite.Require().NoError(suite.storage.DeleteByID(5))
+
+	groups, err := suite.storage.FindDuplicates(model.DuplicateQuery{MinScore: 0.5})
+	suite.NoError(err)
+	suite.Require().Len(groups, 2)
+	suite.Equal([]uint{1, 3}, ids(groups[0].Users))
+	suite.Equal([]string{model.DuplicatePhone}, groups[0].Reasons)
+	suite.Equal(1.0, groups[0].Score)
+	suite.Equal([]uint{2, 4}, ids(groups[1].Users))
+	suite.Equal([]string{model.DuplicateName}, groups[1].Reasons)
+	suite.InDelta((1+9.0/11)/2, groups[1].Score, 1e-9)
+
+	groups, err = suite.storage.FindDuplicates(model.DuplicateQuery{MinScore: 0.95, Limit: 1})
+	suite.NoError(err)
+	suite.Require().Len(groups, 1)
+	suite.Equal([]uint{1, 3}, ids(groups[0].Users))
+}
+
+func (suite *memoryTestSuite) TestMemoryMerge() {
+	jack := model.User{
+		Name:          "jack london",
+		Phones:        []model.PhoneNumber{{Number: "+15551234567", Primary: true}},
+		Emails:        []model.Email{{Address: "jack@example.com", Primary: true}},
+		PostalAddress: model.PostalAddress{City: "Boston", CountryCode: "US"},
+	}
+	tests := map[string]struct {
+		resolution      map[string]string
+		expectedName    string
+		expectedAddress string
+		expectedPostal  model.PostalAddress
+		expectedPhones  []string
+	}{
+		"defaults": {
+			expectedName:    "john",
+			expectedAddress: "moscow",
+			expectedPhones:  []string{john.Phone, "+15551234567"},
+		},
+		"strategies": {
+			resolution: map[string]string{
+				model.FieldName:          model.MergeLongest,
+				model.FieldPostalAddress: model.MergeNewest,
+				model.FieldPhones:        model.MergeSurvivor,
+			},
+			expectedName:    "jack london",
+			expectedAddress: "Boston, US",
+			expectedPostal:  model.PostalAddress{City: "Boston", CountryCode: "US"},
+			expectedPhones:  []string{john.Phone},
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			suite.SetupTest()
+			stored, err := suite.storage.Store(jack)
+			suite.Require().NoError(err)
+
+			merged, err := suite.storage.Merge(model.Merge{Survivor: 1, Merged: []uint{stored.ID}, Resolution: test.resolution})
+			suite.NoError(err)
+			suite.Equal(uint(1), merged.ID)
+			suite.Equal(test.expectedName, merged.Name)
+			suite.Equal(test.expectedAddress, merged.Address)
+			suite.Equal(test.expectedPostal, merged.PostalAddress)
+			var phones []string
+			for _, p := range merged.Phones {
+				phones = append(phones, p.Number)
+			}
+			suite.Equal(test.expectedPhones, phones)
+			suite.Equal(john.Phone, merged.Phone)
+			suite.Equal([]model.Email{{Address: "jack@example.com", Type: model.EmailHome, Primary: false}}, merged.Emails)
+
+			_, err = suite.storage.Get(stored.ID)
+			suite.Equal(model.ErrNotFound, err)
+			got, err := suite.storage.Get(1)
+			suite.NoError(err)
+			suite.Equal(merged, got)
+		})
+	}
+}
+
+func (suite *memoryTestSuite) TestMemoryMergeNotFound() {
+	_, err := suite.storage.Merge(model.Merge{Survivor: 1, Merged: []uint{2, 99}})
+	suite.Equal(model.ErrNotFound, err)
+
+	_, err = suite.storage.Get(2)
+	suite.NoError(err)
+}
+
+func (suite *memoryTestSuite) TestMemoryGroups() {
+	friends, err := suite.storage.StoreGroup(model.Group{Name: "Friends"})
+	suite.Require().NoError(err)
+	suite.Equal(uint(1), friends.ID)
+	_, err = suite.storage.StoreGroup(model.Group{Name: "friends"})
+	suite.Equal(model.ErrDuplicateGroup, err)
+	family, err := suite.storage.StoreGroup(model.Group{Name: "Family", Description: "relatives"})
+	suite.Require().NoError(err)
+
+	groups, err := suite.storage.LoadGroups()
+	suite.NoError(err)
+	suite.Equal([]model.Group{family, friends}, groups)
+
+	_, err = suite.storage.UpdateGroup(family.ID, model.Group{Name: "FRIENDS"}, []string{model.FieldGroupName})
+	suite.Equal(model.ErrDuplicateGroup, err)
+	updated, err := suite.storage.UpdateGroup(family.ID, model.Group{Description: "kin"}, []string{model.FieldGroupDescription})
+	suite.NoError(err)
+	suite.Equal("Family", updated.Name)
+	suite.Equal("kin", updated.Description)
+
+	suite.NoError(suite.storage.DeleteGroup(family.ID))
+	_, err = suite.storage.GetGroup(family.ID)
+	suite.Equal(model.ErrGroupNotFound, err)
+	suite.Equal(model.ErrGroupNotFound, suite.storage.DeleteGroup(family.ID))
+}
+
+func (suite *memoryTestSuite) TestMemoryGroupMembers() {
+	friends, err := suite.storage.StoreGroup(model.Group{Name: "Friends"})
+	suite.Require().NoError(err)
+
+	_, err = suite.storage.AddGroupMembers(friends.ID, []uint{1, 99})
+	suite.Equal(model.ErrNotFound, err)
+	_, err = suite.storage.AddGroupMembers(99, []uint{1})
+	suite.Equal(model.ErrGroupNotFound, err)
+
+	added, err := suite.storage.AddGroupMembers(friends.ID, []uint{1})
+	suite.NoError(err)
+	suite.Equal(int64(1), added)
+	added, err = suite.storage.AddGroupMembers(friends.ID, []uint{1, 2})
+	suite.NoError(err)
+	suite.Equal(int64(1), added)
+
+	users, total, err := suite.storage.Load(model.Query{Filter: model.User{Name: "%", Phone: "%", Address: "%"}, Group: "friends"})
+	suite.NoError(err)
+	suite.Equal(int64(2), total)
+	suite.Equal([]uint{1, 2}, ids(users))
+	suite.Equal([]model.Group{friends}, users[0].Groups)
+
+	_, err = suite.storage.UpdateGroup(friends.ID, model.Group{Name: "Pals"}, []string{model.FieldGroupName})
+	suite.NoError(err)
+	john, err := suite.storage.Get(1)
+	suite.NoError(err)
+	suite.Require().Len(john.Groups, 1)
+	suite.Equal("Pals", john.Groups[0].Name)
+
+	removed, err := suite.storage.RemoveGroupMembers(friends.ID, []uint{2, 99})
+	suite.NoError(err)
+	suite.Equal(int64(1), removed)
+	users, _, err = suite.storage.Load(model.Query{Filter: model.User{Name: "%", Phone: "%", Address: "%"}, Group: "pals"})
+	suite.NoError(err)
+	suite.Equal([]uint{1}, ids(users))
+
+	suite.NoError(suite.storage.DeleteGroup(friends.ID))
+	john, err = suite.storage.Get(1)
+	suite.NoError(err)
+	suite.Empty(john.Groups)
+}
+
+func ids(users []model.User) []uint {
+	var ids []uint
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	return ids
+}
+
+func (suite *memoryTestSuite) TestMemoryAttributes() {
+	attributes := model.Attributes{"customerId": "42"}
+	stored, err := suite.storage.Store(model.User{Name: "jack", Phone: "2-222-222-22-22", Attributes: attributes})
+	suite.Require().NoError(err)
+	attributes["customerId"] = "43"
+	suite.Equal(model.Attributes{"customerId": "42"}, stored.Attributes)
+
+	updated, err := suite.storage.UpdateByID(stored.ID, model.User{Attributes: model.Attributes{"manager": "jane"}}, []string{model.FieldAttributes})
+	suite.NoError(err)
+	suite.Equal(model.Attributes{"manager": "jane"}, updated.Attributes)
+	updated.Attributes["manager"] = "john"
+	got, err := suite.storage.Get(stored.ID)
+	suite.NoError(err)
+	suite.Equal(model.Attributes{"manager": "jane"}, got.Attributes)
+
+	_, err = suite.storage.UpdateByID(1, model.User{Attributes: model.Attributes{"manager": "jim", "tier": "gold"}}, []string{model.FieldAttributes})
+	suite.NoError(err)
+	merged, err := suite.storage.Merge(model.Merge{Survivor: stored.ID, Merged: []uint{1}})
+	suite.NoError(err)
+	suite.Equal(model.Attributes{"manager": "jane", "tier": "gold"}, merged.Attributes)
+}
+
+func (suite *memoryTestSuite) TestMemoryHistory() {
+	_, err := suite.storage.UpdateByID(1, model.User{Address: "london"}, []string{model.FieldAddress})
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.storage.DeleteByID(1))
+	_, err = suite.storage.Restore(1)
+	suite.Require().NoError(err)
+
+	revisions, err := suite.storage.History(1)
+	suite.NoError(err)
+	suite.Require().Len(revisions, 4)
+	for i, action := range []string{model.RevisionCreated, model.RevisionUpdated, model.RevisionDeleted, model.RevisionRestored} {
+		suite.Equal(uint(i+1), revisions[i].Revision)
+		suite.Equal(action, revisions[i].Action)
+		suite.Equal(uint(1), revisions[i].UserID)
+	}
+	suite.Equal("moscow", revisions[0].Snapshot.Address)
+	suite.Equal("london", revisions[1].Snapshot.Address)
+	suite.Equal(john.Phone, revisions[1].Snapshot.Phones[0].Number)
+
+	_, err = suite.storage.History(3)
+	suite.Equal(model.ErrNotFound, err)
+
+	suite.Require().NoError(suite.storage.DeleteByID(1))
+	_, err = suite.storage.Purge(time.Now().Add(time.Second))
+	suite.Require().NoError(err)
+	_, err = suite.storage.History(1)
+	suite.Equal(model.ErrNotFound, err)
+	revisions, err = suite.storage.History(2)
+	suite.NoError(err)
+	suite.Len(revisions, 1)
+}
+
+func (suite *memoryTestSuite) TestMemoryRevert() {
+	_, err := suite.storage.UpdateByID(1, model.User{
+		Name:       "john doe",
+		Phones:     []model.PhoneNumber{{Number: "3-333-333-33-33"}},
+		Attributes: model.Attributes{"tier": "gold"},
+	}, []string{model.FieldName, model.FieldPhones, model.FieldAttributes})
+	suite.Require().NoError(err)
+
+	user, err := suite.storage.Revert(1, 1)
+	suite.NoError(err)
+	suite.Equal(john.Name, user.Name)
+	suite.Equal(john.Phone, user.Phone)
+	suite.Empty(user.Attributes)
+	revisions, err := suite.storage.History(1)
+	suite.NoError(err)
+	suite.Require().Len(revisions, 3)
+	suite.Equal(model.RevisionReverted, revisions[2].Action)
+
+	_, err = suite.storage.Revert(1, 4)
+	suite.Equal(model.ErrNoRevision, err)
+
+	_, err = suite.storage.UpdateByID(2, model.User{Phone: "3-333-333-33-33"}, []string{model.FieldPhone})
+	suite.Require().NoError(err)
+	_, err = suite.storage.Revert(1, 2)
+	suite.Equal(model.ErrDuplicatePhone, err)
+
+	suite.Require().NoError(suite.storage.DeleteByID(1))
+	_, err = suite.storage.Revert(1, 1)
+	suite.Equal(model.ErrNotFound, err)
+}
+
+func (suite *memoryTestSuite) TestMemoryAuditEvents() {
+	for _, actor := range []string{"alice", "bob", "alice"} {
+		suite.Require().NoError(suite.storage.StoreAuditEvent(model.AuditEvent{Actor: actor, Method: "/pb.AddressBookService/AddUser", Code: "OK", Affected: 1}))
+	}
+
+	events, err := suite.storage.LoadAuditEvents(model.AuditQuery{})
+	suite.NoError(err)
+	suite.Require().Len(events, 3)
+	suite.Equal([]uint{3, 2, 1}, []uint{events[0].ID, events[1].ID, events[2].ID})
+	suite.False(events[0].CreatedAt.IsZero())
+
+	events, err = suite.storage.LoadAuditEvents(model.AuditQuery{Actor: "alice", Limit: 1})
+	suite.NoError(err)
+	suite.Require().Len(events, 1)
+	suite.Equal(uint(3), events[0].ID)
+	events, err = suite.storage.LoadAuditEvents(model.AuditQuery{Actor: "alice", BeforeID: 3})
+	suite.NoError(err)
+	suite.Require().Len(events, 1)
+	suite.Equal(uint(1), events[0].ID)
+
+	events, err = suite.storage.LoadAuditEvents(model.AuditQuery{From: time.Now().Add(time.Second)})
+	suite.NoError(err)
+	suite.Empty(events)
+	events, err = suite.storage.LoadAuditEvents(model.AuditQuery{From: time.Now().Add(-time.Hour), To: time.Now().Add(time.Second)})
+	suite.NoError(err)
+	suite.Len(events, 3)
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+// orderColumns are the expressions users are sorted by. Strings compare
+// bytewise, as in MemoryStorage, so that page cursors work the same way.
+var orderColumns = map[string]string{
+	model.FieldID:        "id",
+	model.FieldName:      `COALESCE(name_key, '') COLLATE "C"`,
+	model.FieldPhone:     `COALESCE(phone, '') COLLATE "C"`,
+	model.FieldAddress:   `COALESCE(address_key, '') COLLATE "C"`,
+	model.FieldCreatedAt: "created_at",
+	model.FieldUpdatedAt: "updated_at",
+}
+
+// withID appends the ID as the final tie breaker unless the order has it.
+func withID(order []model.Order) []model.Order {
+	for _, o := range order {
+		if o.Field == model.FieldID {
+			return order
+		}
+	}
+	return append(append([]model.Order(nil), order...), model.Order{Field: model.FieldID})
+}
+
+// sortKey returns the value of the user that field sorts by.
+func sortKey(u model.User, field string) interface{} {
+	switch field {
+	case model.FieldName:
+		return u.NameKey
+	case model.FieldPhone:
+		return u.Phone
+	case model.FieldAddress:
+		return u.AddressKey
+	case model.FieldCreatedAt:
+		return u.CreatedAt
+	case model.FieldUpdatedAt:
+		return u.UpdatedAt
+	}
+	return u.ID
+}
+
+// orderPage sorts the query and, for a non-zero q.AfterID, keeps only the
+// users behind the cursor: the first key greater, or equal and the second
+// greater, and so on.
+func orderPage(tx *gorm.DB, q model.Query) *gorm.DB {
+	order := withID(q.OrderBy)
+	after := q.After
+	after.ID = q.AfterID
+
+	var conditions []string
+	var args, equal []interface{}
+	prefix := ""
+	for _, o := range order {
+		column, ok := orderColumns[o.Field]
+		if !ok {
+			continue
+		}
+		dir, cmp := " ASC", " > ?"
+		if o.Desc {
+			dir, cmp = " DESC", " < ?"
+		}
+		tx = tx.Order(column + dir)
+
+		key := sortKey(after, o.Field)
+		conditions = append(conditions, prefix+column+cmp)
+		args = append(append(args, equal...), key)
+		equal = append(equal, key)
+		prefix += column + " = ? AND "
+	}
+	if q.AfterID > 0 {
+		tx = tx.Where("("+strings.Join(conditions, " OR ")+")", args...)
+	}
+	return tx
+}
+
+// compareUsers orders users like orderPage does in SQL.
+func compareUsers(a, b model.User, order []model.Order) int {
+	for _, o := range withID(order) {
+		c := compareKeys(sortKey(a, o.Field), sortKey(b, o.Field))
+		if o.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareKeys(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case time.Time:
+		switch {
+		case a.Before(b.(time.Time)):
+			return -1
+		case a.After(b.(time.Time)):
+			return 1
+		}
+		return 0
+	case uint:
+		switch {
+		case a < b.(uint):
+			return -1
+		case a > b.(uint):
+			return 1
+		}
+	}
+	return 0
+}
@@ -1,11 +1,74 @@
 package repository
 
 import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+	"github.com/vstarostin/infoblox-training-project-1/internal/phonenumber"
 )
 
+const (
+	legacyPhoneConstraint = "users_phone_key"
+	groupKeyIndex         = "idx_groups_key"
+
+	// membersTable joins users to their groups.
+	membersTable = "user_groups"
+
+	uniqueViolation      = "23505"
+	tooManyConnections   = "53300"
+	connectionException  = "08"
+	operatorIntervention = "57P"
+)
+
+// addressColumns are written together whenever the address changes.
+var addressColumns = []string{
+	"address",
+	"address_key",
+	"address_street",
+	"address_city",
+	"address_region",
+	"address_postal_code",
+	"address_country_code",
+}
+
+// storeColumns are written when a user is created.
+var storeColumns = append([]string{"name", "name_key", "phone", "phone_display", "attributes", "Phones", "Emails"}, addressColumns...)
+
+// importSavePoint lets Import undo a single failed row of a batch.
+const importSavePoint = "import_row"
+
+// errSavePoint marks failures of the savepoint itself, which leave the
+// transaction unusable.
+var errSavePoint = errors.New("savepoint failed")
+
+// exprColumns are the users columns filter expressions match fields against.
+var exprColumns = map[string]string{
+	model.FieldName:        "name_key",
+	model.FieldPhone:       "phone",
+	model.FieldAddress:     "address_key",
+	model.FieldStreet:      "address_street",
+	model.FieldCity:        "address_city",
+	model.FieldRegion:      "address_region",
+	model.FieldPostalCode:  "address_postal_code",
+	model.FieldCountryCode: "address_country_code",
+}
+
+// mergeFields are written to the survivor of a merge.
+var mergeFields = []string{model.FieldName, model.FieldPostalAddress, model.FieldPhones, model.FieldEmails, model.FieldAttributes}
+
+// ExportBatchSize is the number of users Export holds in memory at a time.
+const ExportBatchSize = 500
+
 type Storage struct {
 	db *gorm.DB
 }
@@ -14,20 +77,999 @@ func New(db *gorm.DB) *Storage {
 	return &Storage{db: db}
 }
 
-func (s *Storage) Store(user model.User) *gorm.DB {
-	return s.db.Select("name", "phone", "address").Create(&user)
+// Migrate brings the database schema up to date with the model. Legacy
+// phones are normalized with phones the way new ones are.
+func Migrate(db *gorm.DB, phones *phonenumber.Normalizer) error {
+	// Phone numbers used to be unique across all rows, which would keep soft
+	// deleted users blocking their numbers forever.
+	if db.Migrator().HasConstraint(&model.User{}, legacyPhoneConstraint) {
+		if err := db.Migrator().DropConstraint(&model.User{}, legacyPhoneConstraint); err != nil {
+			return err
+		}
+	}
+	if err := db.AutoMigrate(&model.Group{}, &model.User{}, &model.PhoneNumber{}, &model.Email{}, &model.UserRevision{}, &model.AuditEvent{}); err != nil {
+		return err
+	}
+	// Search scores users with trigram similarity and edit distance,
+	// FindDuplicates joins users with similar names through the index.
+	for _, stmt := range []string{
+		"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+		"CREATE EXTENSION IF NOT EXISTS fuzzystrmatch",
+		"CREATE INDEX IF NOT EXISTS idx_users_name_key_trgm ON users USING gin (name_key gin_trgm_ops)",
+		"CREATE INDEX IF NOT EXISTS idx_users_address_key_trgm ON users USING gin (address_key gin_trgm_ops)",
+		"CREATE INDEX IF NOT EXISTS idx_phone_numbers_number_trgm ON phone_numbers USING gin (number gin_trgm_ops)",
+		// Audit events are append-only, the database rejects changing them.
+		`CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_events is append-only';
+		END $$ LANGUAGE plpgsql`,
+		"DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events",
+		`CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+		FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only()`,
+		"DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events",
+		`CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+		FOR EACH STATEMENT EXECUTE PROCEDURE audit_events_append_only()`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	// Users created before search keys existed were stored lowercased already.
+	err := db.Exec(`UPDATE users SET name_key = LOWER(TRIM(name)), address_key = LOWER(TRIM(address))
+		WHERE name_key IS NULL OR name_key = ''`).Error
+	if err != nil {
+		return err
+	}
+	return backfillPhones(db, phones)
 }
 
-func (s *Storage) Load(u model.User) []model.User {
+// backfillPhones copies the legacy phone of users created before
+// phone_numbers existed into it, in E.164 form like new numbers. A number
+// that cannot be parsed, or whose canonical form another live user has, is
+// kept as it was so that the migration loses no contact.
+func backfillPhones(db *gorm.DB, phones *phonenumber.Normalizer) error {
+	var legacy []model.User
+	err := db.Unscoped().Select("id", "phone", "deleted_at").
+		Where("phone <> '' AND NOT EXISTS (SELECT 1 FROM phone_numbers p WHERE p.user_id = users.id)").
+		Order("id").Find(&legacy).Error
+	if err != nil || len(legacy) == 0 {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, u := range legacy {
+			number, display := u.Phone, u.Phone
+			if n, err := phones.Normalize(u.Phone); err == nil {
+				taken, err := phoneTaken(tx, n.E164, u.ID)
+				if err != nil {
+					return err
+				}
+				if u.DeletedAt.Valid || !taken {
+					number, display = n.E164, n.Display
+				}
+			}
+			err := tx.Unscoped().Model(&model.User{}).Where("id = ?", u.ID).
+				UpdateColumns(map[string]interface{}{"phone": number, "phone_display": display}).Error
+			if err != nil {
+				return err
+			}
+			phone := model.PhoneNumber{UserID: u.ID, Number: number, Display: display, Type: model.PhoneMobile, Primary: true, DeletedAt: u.DeletedAt}
+			if err := tx.Create(&phone).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// phoneTaken reports whether a live user other than id has the number.
+func phoneTaken(tx *gorm.DB, number string, id uint) (bool, error) {
+	var users, phones int64
+	if err := tx.Model(&model.User{}).Where("phone = ? AND id <> ?", number, id).Count(&users).Error; err != nil {
+		return false, err
+	}
+	if err := tx.Model(&model.PhoneNumber{}).Where("number = ? AND user_id <> ?", number, id).Count(&phones).Error; err != nil {
+		return false, err
+	}
+	return users+phones > 0, nil
+}
+
+func (s *Storage) Store(user model.User) (model.User, error) {
+	user.Normalize()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select(storeColumns).Create(&user).Error; err != nil {
+			return err
+		}
+		return recordRevisions(tx, model.RevisionCreated, user)
+	})
+	if err != nil {
+		return model.User{}, translateError(err)
+	}
+	return user, nil
+}
+
+func (s *Storage) Load(q model.Query) ([]model.User, int64, error) {
+	filtered := s.filter(q)
+
+	var total int64
+	if err := filtered.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, translateError(err)
+	}
+
+	page := orderPage(preloadContacts(filtered.Session(&gorm.Session{}), q.Deleted), q)
+	if q.Limit > 0 {
+		page = page.Limit(q.Limit)
+	}
 	user := []model.User{}
-	s.db.Where("name LIKE ? AND phone LIKE ? AND address LIKE ?", u.Name, u.Phone, u.Address).Find(&user)
-	return user
+	if err := page.Find(&user).Error; err != nil {
+		return nil, 0, translateError(err)
+	}
+	return user, total, nil
+}
+
+// Export calls fn for every user matching the filters of q in ID order,
+// reading ExportBatchSize users per query. An error of fn stops the export
+// and is returned as is.
+func (s *Storage) Export(q model.Query, fn func(model.User) error) error {
+	var batch []model.User
+	var fnErr error
+	err := preloadContacts(s.filter(q), q.Deleted).FindInBatches(&batch, ExportBatchSize, func(*gorm.DB, int) error {
+		for _, user := range batch {
+			if fnErr = fn(user); fnErr != nil {
+				return fnErr
+			}
+		}
+		return nil
+	}).Error
+	if fnErr != nil {
+		return fnErr
+	}
+	return translateError(err)
+}
+
+// filter applies the filters of q, ignoring its order and cursor.
+func (s *Storage) filter(q model.Query) *gorm.DB {
+	u := q.Filter
+	filtered := s.db.Model(&model.User{}).
+		Where("name_key LIKE ? AND address_key LIKE ?", u.Name, u.Address).
+		Where("(phone LIKE ? OR EXISTS (SELECT 1 FROM phone_numbers p WHERE p.user_id = users.id AND p.number LIKE ?))", u.Phone, u.Phone)
+	filtered = filterAddress(filtered, u.PostalAddress)
+	if q.Group != "" {
+		filtered = filtered.Where(`EXISTS (SELECT 1 FROM `+membersTable+` ug JOIN groups g ON g.id = ug.group_id
+			WHERE ug.user_id = users.id AND g.key = ?)`, q.Group)
+	}
+	if q.Expr != nil {
+		sql, args := exprSQL(*q.Expr)
+		filtered = filtered.Where(sql, args...)
+	}
+	if q.Deleted {
+		filtered = filtered.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	return filtered
+}
+
+// searchScoreSQL is the relevance of a user to a search, see searchScore in
+// memory.go for the same computation in Go.
+const searchScoreSQL = `GREATEST(
+	similarity(name_key, @text),
+	similarity(address_key, @text),
+	CASE WHEN @text ~ '^[[:alnum:]]+$' AND length(@text) <= 255 THEN
+		(SELECT COALESCE(MAX(1 - levenshtein(w, @text)::float8 / GREATEST(length(w), length(@text))), 0)
+		FROM regexp_split_to_table(lower(name_key || ' ' || address_key), '[^[:alnum:]]+') AS w
+		WHERE w <> '' AND length(w) <= 255)
+	ELSE 0 END,
+	(SELECT COALESCE(MAX(similarity(p.number, @phone)), 0) FROM phone_numbers p
+		WHERE p.user_id = users.id AND p.deleted_at IS NULL))`
+
+// searchCandidatesSQL keeps the users a search can score through the
+// trigram indexes before searchScoreSQL ranks them: those sharing a trigram
+// with the text, which <% tests under a word similarity threshold of one
+// trigram, and those with a number as similar to the phone as required.
+const searchCandidatesSQL = `(@text <% name_key OR @text <% address_key
+	OR id IN (SELECT user_id FROM phone_numbers WHERE deleted_at IS NULL AND @phone <> '' AND number % @phone))`
+
+// Search ranks live users by trigram similarity and, for single words, by
+// edit distance, which needs the pg_trgm and fuzzystrmatch extensions.
+func (s *Storage) Search(q model.SearchQuery) ([]model.SearchHit, error) {
+	hits := []model.SearchHit{}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("SELECT set_config('pg_trgm.similarity_threshold', ?, true), set_config('pg_trgm.word_similarity_threshold', ?, true)",
+			strconv.FormatFloat(q.MinScore, 'f', -1, 64), strconv.FormatFloat(oneTrigram(q.Text), 'f', -1, 64)).Error
+		if err != nil {
+			return err
+		}
+		var ranked []struct {
+			ID    uint
+			Score float64
+		}
+		err = tx.Raw(`SELECT id, score FROM (SELECT id, `+searchScoreSQL+` AS score FROM users
+			WHERE deleted_at IS NULL AND `+searchCandidatesSQL+`) AS ranked
+			WHERE score > 0 AND score >= @min
+			ORDER BY score DESC, id
+			LIMIT NULLIF(@limit, 0)`,
+			map[string]interface{}{"text": q.Text, "phone": q.Phone, "min": q.MinScore, "limit": q.Limit}).Scan(&ranked).Error
+		if err != nil || len(ranked) == 0 {
+			return err
+		}
+
+		ids := make([]uint, 0, len(ranked))
+		for _, r := range ranked {
+			ids = append(ids, r.ID)
+		}
+		var users []model.User
+		if err := preloadContacts(tx, false).Where("id IN ?", ids).Find(&users).Error; err != nil {
+			return err
+		}
+		byID := make(map[uint]model.User, len(users))
+		for _, u := range users {
+			byID[u.ID] = u
+		}
+		for _, r := range ranked {
+			if u, ok := byID[r.ID]; ok {
+				hits = append(hits, model.SearchHit{User: u, Score: r.Score})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return hits, nil
+}
+
+// duplicatePairsSQL pairs live users sharing a phoneKey or with similar
+// names, see FindDuplicates in memory.go for the same comparison in Go. The %
+// operator compares names against pg_trgm.similarity_threshold.
+const duplicatePairsSQL = `WITH keys AS (
+	SELECT DISTINCT p.user_id, RIGHT(regexp_replace(p.number, '[^0-9]', '', 'g'), @digits) AS phone_key
+	FROM phone_numbers p JOIN users u ON u.id = p.user_id
+	WHERE p.deleted_at IS NULL AND u.deleted_at IS NULL
+		AND length(regexp_replace(p.number, '[^0-9]', '', 'g')) >= @minDigits
+)
+SELECT a.user_id AS a, b.user_id AS b, @phone AS reason, 1::float8 AS score
+FROM keys a JOIN keys b ON a.phone_key = b.phone_key AND a.user_id < b.user_id
+UNION
+SELECT a.id, b.id, @name, CASE WHEN a.address_key <> '' AND b.address_key <> ''
+	THEN (similarity(a.name_key, b.name_key) + similarity(a.address_key, b.address_key)) / 2
+	ELSE similarity(a.name_key, b.name_key) END::float8
+FROM users a JOIN users b ON a.name_key % b.name_key AND a.id < b.id
+WHERE a.deleted_at IS NULL AND b.deleted_at IS NULL
+	AND (a.address_key = '' OR b.address_key = '' OR similarity(a.address_key, b.address_key) >= @min)`
+
+// FindDuplicates pairs users in SQL and clusters the pairs in Go, which
+// needs the pg_trgm extension.
+func (s *Storage) FindDuplicates(q model.DuplicateQuery) ([]model.DuplicateGroup, error) {
+	groups := []model.DuplicateGroup{}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("SELECT set_config('pg_trgm.similarity_threshold', ?, true)", strconv.FormatFloat(q.MinScore, 'f', -1, 64)).Error
+		if err != nil {
+			return err
+		}
+		var pairs []duplicatePair
+		err = tx.Raw(duplicatePairsSQL, map[string]interface{}{
+			"digits":    phoneKeyDigits,
+			"minDigits": minPhoneKeyDigits,
+			"phone":     model.DuplicatePhone,
+			"name":      model.DuplicateName,
+			"min":       q.MinScore,
+		}).Scan(&pairs).Error
+		if err != nil || len(pairs) == 0 {
+			return err
+		}
+
+		clusters := clusterDuplicates(pairs, q.Limit)
+		var users []model.User
+		if err := preloadContacts(tx, false).Where("id IN ?", clusterIDs(clusters)).Find(&users).Error; err != nil {
+			return err
+		}
+		groups = duplicateGroups(clusters, users)
+		return nil
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return groups, nil
+}
+
+// Merge resolves the fields of the survivor from all users of m and soft
+// deletes the others in one transaction. The merged users are deleted
+// first, so that the survivor can take over their phone numbers.
+func (s *Storage) Merge(m model.Merge) (model.User, error) {
+	var merged model.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		ids := append([]uint{m.Survivor}, m.Merged...)
+		var users []model.User
+		err := preloadContacts(tx, false).Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", ids).Find(&users).Error
+		if err != nil {
+			return err
+		}
+		if len(users) != len(ids) {
+			return model.ErrNotFound
+		}
+		byID := make(map[uint]model.User, len(users))
+		for _, u := range users {
+			byID[u.ID] = u
+		}
+		others := make([]model.User, 0, len(m.Merged))
+		for _, id := range m.Merged {
+			others = append(others, byID[id])
+		}
+		result := model.MergeUsers(byID[m.Survivor], others, m.Resolution)
+
+		if _, err := deleteUsers(tx, 0, "id IN ?", m.Merged); err != nil {
+			return err
+		}
+		merged, err = updateUser(tx, result, mergeFields, model.RevisionMerged, "id = ?", m.Survivor)
+		return err
+	})
+	if err != nil {
+		return model.User{}, translateError(err)
+	}
+	return merged, nil
+}
+
+// Import stores the users in one transaction, undoing failed rows through a
+// savepoint so that the others still commit. Users whose numbers are taken
+// are handled according to onConflict; an overwrite replaces every field
+// of the one user owning the numbers.
+func (s *Storage) Import(users []model.User, onConflict string) ([]model.ImportResult, error) {
+	results := make([]model.ImportResult, len(users))
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for i, user := range users {
+			user.Normalize()
+			var err error
+			results[i], err = importUser(tx, user, onConflict)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return results, nil
+}
+
+// importUser stores one user of an import, an error aborts the whole batch.
+func importUser(tx *gorm.DB, user model.User, onConflict string) (model.ImportResult, error) {
+	created := clone(user)
+	err := savePoint(tx, func() error {
+		if err := tx.Select(storeColumns).Create(&created).Error; err != nil {
+			return err
+		}
+		return recordRevisions(tx, model.RevisionCreated, created)
+	})
+	if err == nil {
+		return model.ImportResult{Status: model.ImportCreated, User: created}, nil
+	}
+	if err = translateError(err); isFatal(err) {
+		return model.ImportResult{}, err
+	}
+	if !errors.Is(err, model.ErrDuplicatePhone) || onConflict == model.OnConflictFail {
+		return model.ImportResult{Status: model.ImportFailed, Err: err}, nil
+	}
+	if onConflict == model.OnConflictSkip {
+		return model.ImportResult{Status: model.ImportSkipped, Err: err}, nil
+	}
+
+	numbers := make([]string, 0, len(user.Phones))
+	for _, p := range user.Phones {
+		numbers = append(numbers, p.Number)
+	}
+	var owners []uint
+	if err := tx.Model(&model.PhoneNumber{}).Where("number IN ?", numbers).Distinct().Pluck("user_id", &owners).Error; err != nil {
+		return model.ImportResult{}, err
+	}
+	if len(owners) == 0 {
+		return model.ImportResult{Status: model.ImportFailed, Err: err}, nil
+	}
+	if len(owners) > 1 {
+		return model.ImportResult{Status: model.ImportFailed, Err: model.ErrSeveralOwners}, nil
+	}
+	var updated model.User
+	err = savePoint(tx, func() error {
+		var err error
+		updated, err = updateUser(tx, user, model.UpdatableFields, model.RevisionUpdated, "id = ?", owners[0])
+		return err
+	})
+	if err != nil {
+		if err = translateError(err); isFatal(err) {
+			return model.ImportResult{}, err
+		}
+		return model.ImportResult{Status: model.ImportFailed, Err: err}, nil
+	}
+	return model.ImportResult{Status: model.ImportUpdated, User: updated}, nil
+}
+
+func isFatal(err error) bool {
+	return errors.Is(err, model.ErrUnavailable) || errors.Is(err, errSavePoint)
+}
+
+// savePoint runs fn and rolls the transaction back to before it on error.
+func savePoint(tx *gorm.DB, fn func() error) error {
+	if err := tx.SavePoint(importSavePoint).Error; err != nil {
+		return fmt.Errorf("%w: %v", errSavePoint, err)
+	}
+	err := fn()
+	if err != nil {
+		if rbErr := tx.RollbackTo(importSavePoint).Error; rbErr != nil {
+			return fmt.Errorf("%w: %v", errSavePoint, rbErr)
+		}
+	}
+	return err
+}
+
+// Delete soft deletes the live users whose search key matches the LIKE
+// pattern and returns them. With a non-zero expected count nothing is
+// deleted unless exactly that many users match, the matching users are
+// returned with ErrCountMismatch otherwise.
+func (s *Storage) Delete(name string, expected int64) ([]model.User, error) {
+	var users []model.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		users, err = deleteUsers(tx, expected, "name_key LIKE ?", name)
+		return err
+	})
+	if errors.Is(err, model.ErrCountMismatch) {
+		return users, err
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return users, nil
+}
+
+func (s *Storage) Get(id uint) (model.User, error) {
+	var user model.User
+	if err := preloadContacts(s.db, false).First(&user, id).Error; err != nil {
+		return model.User{}, translateError(err)
+	}
+	return user, nil
+}
+
+func (s *Storage) DeleteByID(id uint) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		_, err := deleteUsers(tx, 0, "id = ?", id)
+		return err
+	})
+	return translateError(err)
+}
+
+// deleteUsers soft deletes the live users matching the condition together
+// with their phone numbers, so that the numbers can be taken again, and
+// records the deletion in their history. The users are locked first and
+// only they are deleted, so users added meanwhile are neither counted nor
+// deleted. A non-zero expected count must equal the number of users.
+func deleteUsers(tx *gorm.DB, expected int64, query string, args ...interface{}) ([]model.User, error) {
+	var users []model.User
+	err := preloadContacts(tx, false).Clauses(clause.Locking{Strength: "UPDATE"}).Where(query, args...).Order("id").Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, model.ErrNotFound
+	}
+	if expected != 0 && int64(len(users)) != expected {
+		return users, model.ErrCountMismatch
+	}
+	ids := make([]uint, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	if err := tx.Where("user_id IN ?", ids).Delete(&model.PhoneNumber{}).Error; err != nil {
+		return nil, err
+	}
+	now := tx.NowFunc()
+	if _, err := rowsAffected(tx.Model(&model.User{}).Where("id IN ?", ids).UpdateColumn("deleted_at", now)); err != nil {
+		return nil, err
+	}
+	for i := range users {
+		users[i].DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+	}
+	return users, recordRevisions(tx, model.RevisionDeleted, users...)
+}
+
+func (s *Storage) Restore(id uint) (model.User, error) {
+	var user model.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&model.User{}).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
+		if _, err := rowsAffected(result); err != nil {
+			return err
+		}
+		err := tx.Unscoped().Model(&model.PhoneNumber{}).Where("user_id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil).Error
+		if err != nil {
+			return err
+		}
+		if err := preloadContacts(tx, false).First(&user, id).Error; err != nil {
+			return err
+		}
+		return recordRevisions(tx, model.RevisionRestored, user)
+	})
+	if err != nil {
+		return model.User{}, translateError(err)
+	}
+	return user, nil
+}
+
+func (s *Storage) Purge(olderThan time.Time) (int64, error) {
+	var purged int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		purgeable := tx.Unscoped().Model(&model.User{}).Select("id").Where("deleted_at IS NOT NULL AND deleted_at < ?", olderThan)
+		if err := tx.Unscoped().Where("user_id IN (?)", purgeable).Delete(&model.PhoneNumber{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id IN (?)", purgeable).Delete(&model.Email{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Table(membersTable).Where("user_id IN (?)", purgeable).Delete(nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id IN (?)", purgeable).Delete(&model.UserRevision{}).Error; err != nil {
+			return err
+		}
+		result := tx.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", olderThan).Delete(&model.User{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return purged, nil
+}
+
+func (s *Storage) StoreGroup(group model.Group) (model.Group, error) {
+	group.Normalize()
+	if err := s.db.Create(&group).Error; err != nil {
+		return model.Group{}, translateError(err)
+	}
+	return group, nil
+}
+
+func (s *Storage) StoreAuditEvent(event model.AuditEvent) error {
+	return translateError(s.db.Create(&event).Error)
+}
+
+func (s *Storage) LoadAuditEvents(q model.AuditQuery) ([]model.AuditEvent, error) {
+	tx := s.db.Order("id DESC").Limit(q.Limit)
+	if !q.From.IsZero() {
+		tx = tx.Where("created_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where("created_at < ?", q.To)
+	}
+	if q.Actor != "" {
+		tx = tx.Where("actor = ?", q.Actor)
+	}
+	if q.BeforeID > 0 {
+		tx = tx.Where("id < ?", q.BeforeID)
+	}
+	events := []model.AuditEvent{}
+	if err := tx.Find(&events).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return events, nil
+}
+
+// LoadGroups returns all groups ordered by key.
+func (s *Storage) LoadGroups() ([]model.Group, error) {
+	groups := []model.Group{}
+	if err := s.db.Order("key, id").Find(&groups).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return groups, nil
+}
+
+func (s *Storage) GetGroup(id uint) (model.Group, error) {
+	var group model.Group
+	if err := s.db.First(&group, id).Error; err != nil {
+		return model.Group{}, groupError(err)
+	}
+	return group, nil
+}
+
+func (s *Storage) UpdateGroup(id uint, updatedGroup model.Group, fields []string) (model.Group, error) {
+	var group model.Group
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&group, id).Error; err != nil {
+			return err
+		}
+		cols := []string{}
+		for _, field := range fields {
+			switch field {
+			case model.FieldGroupName:
+				group.Name = updatedGroup.Name
+				cols = append(cols, "name", "key")
+			case model.FieldGroupDescription:
+				group.Description = updatedGroup.Description
+				cols = append(cols, "description")
+			}
+		}
+		group.Normalize()
+		if len(cols) == 0 {
+			return nil
+		}
+		return tx.Model(&group).Select(cols).Updates(&group).Error
+	})
+	if err != nil {
+		return model.Group{}, groupError(err)
+	}
+	return group, nil
+}
+
+// DeleteGroup removes the group and its memberships, the users stay.
+func (s *Storage) DeleteGroup(id uint) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table(membersTable).Where("group_id = ?", id).Delete(nil).Error; err != nil {
+			return err
+		}
+		_, err := rowsAffected(tx.Delete(&model.Group{}, id))
+		return err
+	})
+	return groupError(err)
+}
+
+// AddGroupMembers adds the live users to the group and returns how many of
+// them were not members yet. Nothing is added if any of them is missing.
+func (s *Storage) AddGroupMembers(id uint, userIDs []uint) (int64, error) {
+	var added int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&model.Group{}, id).Error; err != nil {
+			return groupError(err)
+		}
+		var found int64
+		if err := tx.Model(&model.User{}).Where("id IN ?", userIDs).Count(&found).Error; err != nil {
+			return err
+		}
+		if found != int64(len(userIDs)) {
+			return model.ErrNotFound
+		}
+		result := tx.Exec(`INSERT INTO `+membersTable+` (user_id, group_id) SELECT id, ? FROM users WHERE id IN ?
+			ON CONFLICT DO NOTHING`, id, userIDs)
+		added = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return added, nil
+}
+
+// RemoveGroupMembers removes the users from the group and returns how many
+// of them were members.
+func (s *Storage) RemoveGroupMembers(id uint, userIDs []uint) (int64, error) {
+	var removed int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&model.Group{}, id).Error; err != nil {
+			return groupError(err)
+		}
+		result := tx.Table(membersTable).Where("group_id = ? AND user_id IN ?", id, userIDs).Delete(nil)
+		removed = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return removed, nil
+}
+
+// groupError translates errors of queries by group ID, for which a missing
+// row is a missing group.
+func groupError(err error) error {
+	if err = translateError(err); errors.Is(err, model.ErrNotFound) {
+		return model.ErrGroupNotFound
+	}
+	return err
+}
+
+func (s *Storage) Update(phone string, updatedUser model.User, fields []string) (model.User, error) {
+	return s.update(updatedUser, fields, "phone = ?", phone)
+}
+
+func (s *Storage) UpdateByID(id uint, updatedUser model.User, fields []string) (model.User, error) {
+	return s.update(updatedUser, fields, "id = ?", id)
+}
+
+func (s *Storage) update(updatedUser model.User, fields []string, query string, args ...interface{}) (model.User, error) {
+	var user model.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		user, err = updateUser(tx, updatedUser, fields, model.RevisionUpdated, query, args...)
+		return err
+	})
+	if err != nil {
+		return model.User{}, translateError(err)
+	}
+	return user, nil
+}
+
+// updateUser writes the fields of the live user matching the condition and
+// records the change in its history with the given action. The user is
+// locked until the end of the transaction.
+func updateUser(tx *gorm.DB, updatedUser model.User, fields []string, action, query string, args ...interface{}) (model.User, error) {
+	var user model.User
+	err := preloadContacts(tx, false).Clauses(clause.Locking{Strength: "UPDATE"}).Where(query, args...).First(&user).Error
+	if err != nil {
+		return model.User{}, err
+	}
+	user.Merge(updatedUser, fields)
+	if cols := columns(fields); len(cols) > 0 {
+		if err := tx.Model(&user).Select(cols).Updates(&user).Error; err != nil {
+			return model.User{}, err
+		}
+	}
+	if err := replaceContacts(tx, &user, fields); err != nil {
+		return model.User{}, err
+	}
+	return user, recordRevisions(tx, action, user)
+}
+
+// History returns the revisions of a live or soft deleted user, oldest
+// first.
+func (s *Storage) History(id uint) ([]model.UserRevision, error) {
+	var exists int64
+	if err := s.db.Unscoped().Model(&model.User{}).Where("id = ?", id).Count(&exists).Error; err != nil {
+		return nil, translateError(err)
+	}
+	if exists == 0 {
+		return nil, model.ErrNotFound
+	}
+	revisions := []model.UserRevision{}
+	if err := s.db.Where("user_id = ?", id).Order("revision").Find(&revisions).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return revisions, nil
+}
+
+// Revert writes the content of a revision back to the live user, which
+// gets a new revision for it.
+func (s *Storage) Revert(id, revision uint) (model.User, error) {
+	var user model.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var rev model.UserRevision
+		err := tx.Where("user_id = ? AND revision = ?", id, revision).First(&rev).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.ErrNoRevision
+		}
+		if err != nil {
+			return err
+		}
+		user, err = updateUser(tx, rev.Snapshot.User(), model.SnapshotFields, model.RevisionReverted, "id = ?", id)
+		return err
+	})
+	if err != nil {
+		return model.User{}, translateError(err)
+	}
+	return user, nil
+}
+
+// recordRevisions adds a revision with the action to the history of every
+// user, numbered after the latest one. The users are locked or new, so
+// concurrent changes cannot take the same numbers.
+func recordRevisions(tx *gorm.DB, action string, users ...model.User) error {
+	ids := make([]uint, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	var latest []struct {
+		UserID   uint
+		Revision uint
+	}
+	err := tx.Model(&model.UserRevision{}).Select("user_id, MAX(revision) AS revision").
+		Where("user_id IN ?", ids).Group("user_id").Scan(&latest).Error
+	if err != nil {
+		return err
+	}
+	next := make(map[uint]uint, len(users))
+	for _, l := range latest {
+		next[l.UserID] = l.Revision
+	}
+	revisions := make([]model.UserRevision, 0, len(users))
+	for _, u := range users {
+		next[u.ID]++
+		revisions = append(revisions, model.UserRevision{
+			UserID:   u.ID,
+			Revision: next[u.ID],
+			Action:   action,
+			Snapshot: model.NewSnapshot(u),
+		})
+	}
+	return tx.Create(&revisions).Error
+}
+
+// filterAddress adds a condition for every non-empty address component.
+func filterAddress(tx *gorm.DB, a model.PostalAddress) *gorm.DB {
+	components := []struct{ column, pattern string }{
+		{"address_street", a.Street},
+		{"address_city", a.City},
+		{"address_region", a.Region},
+		{"address_postal_code", a.PostalCode},
+		{"address_country_code", a.CountryCode},
+	}
+	for _, c := range components {
+		if c.pattern != "" {
+			tx = tx.Where("LOWER("+c.column+") LIKE ?", strings.ToLower(c.pattern))
+		}
+	}
+	return tx
+}
+
+// exprSQL translates a filter expression into a condition with placeholders
+// for all patterns. Columns come from exprColumns only and fields unknown to
+// it match nothing.
+func exprSQL(e model.Expr) (string, []interface{}) {
+	switch e.Kind {
+	case model.ExprAnd, model.ExprOr:
+		op, empty := " AND ", "TRUE"
+		if e.Kind == model.ExprOr {
+			op, empty = " OR ", "FALSE"
+		}
+		if len(e.Operands) == 0 {
+			return empty, nil
+		}
+		parts := make([]string, 0, len(e.Operands))
+		var args []interface{}
+		for _, operand := range e.Operands {
+			sql, a := exprSQL(operand)
+			parts = append(parts, "("+sql+")")
+			args = append(args, a...)
+		}
+		return strings.Join(parts, op), args
+	case model.ExprNot:
+		if len(e.Operands) != 1 {
+			return "FALSE", nil
+		}
+		sql, args := exprSQL(e.Operands[0])
+		return "NOT (" + sql + ")", args
+	case model.ExprExists:
+		if e.Field == model.FieldEmail {
+			return "EXISTS (SELECT 1 FROM emails e WHERE e.user_id = users.id AND e.address <> '')", nil
+		}
+		if e.Field == model.FieldAttribute {
+			return "COALESCE(attributes ->> ?::text, '') <> ''", []interface{}{e.Key}
+		}
+		column, ok := exprColumns[e.Field]
+		if !ok {
+			return "FALSE", nil
+		}
+		return "COALESCE(" + column + ", '') <> ''", nil
+	}
+
+	switch e.Field {
+	case model.FieldName, model.FieldAddress:
+		return "COALESCE(" + exprColumns[e.Field] + ", '') LIKE ?", []interface{}{e.Pattern}
+	case model.FieldPhone:
+		return "(COALESCE(phone, '') LIKE ? OR EXISTS (SELECT 1 FROM phone_numbers p WHERE p.user_id = users.id AND p.number LIKE ?))",
+			[]interface{}{e.Pattern, e.Pattern}
+	case model.FieldEmail:
+		return "EXISTS (SELECT 1 FROM emails e WHERE e.user_id = users.id AND LOWER(e.address) LIKE ?)",
+			[]interface{}{strings.ToLower(e.Pattern)}
+	case model.FieldAttribute:
+		return "LOWER(COALESCE(attributes ->> ?::text, '')) LIKE ?", []interface{}{e.Key, strings.ToLower(e.Pattern)}
+	}
+	column, ok := exprColumns[e.Field]
+	if !ok {
+		return "FALSE", nil
+	}
+	return "LOWER(COALESCE(" + column + ", '')) LIKE ?", []interface{}{strings.ToLower(e.Pattern)}
+}
+
+// columns returns the users columns written when the given fields are updated.
+func columns(fields []string) []string {
+	var cols []string
+	address := false
+	for _, field := range fields {
+		switch field {
+		case model.FieldName:
+			cols = append(cols, "name", "name_key")
+		case model.FieldPhone, model.FieldPhones:
+			cols = append(cols, "phone", "phone_display")
+		case model.FieldAddress, model.FieldPostalAddress:
+			address = true
+		case model.FieldEmails:
+		default:
+			cols = append(cols, field)
+		}
+	}
+	if address {
+		cols = append(cols, addressColumns...)
+	}
+	return cols
+}
+
+// replaceContacts rewrites the phone numbers and emails of the user if the
+// update touched them.
+func replaceContacts(tx *gorm.DB, user *model.User, fields []string) error {
+	var phones, emails bool
+	for _, field := range fields {
+		switch field {
+		case model.FieldPhone, model.FieldPhones:
+			phones = true
+		case model.FieldEmails:
+			emails = true
+		}
+	}
+	if phones {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&model.PhoneNumber{}).Error; err != nil {
+			return err
+		}
+		for i := range user.Phones {
+			user.Phones[i].ID, user.Phones[i].UserID = 0, user.ID
+		}
+		if len(user.Phones) > 0 {
+			if err := tx.Create(&user.Phones).Error; err != nil {
+				return err
+			}
+		}
+	}
+	if emails {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&model.Email{}).Error; err != nil {
+			return err
+		}
+		for i := range user.Emails {
+			user.Emails[i].ID, user.Emails[i].UserID = 0, user.ID
+		}
+		if len(user.Emails) > 0 {
+			if err := tx.Create(&user.Emails).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// preloadContacts loads phone numbers, emails and groups along with the
+// users. Numbers of soft deleted users are soft deleted as well.
+func preloadContacts(tx *gorm.DB, deleted bool) *gorm.DB {
+	return tx.
+		Preload("Phones", func(db *gorm.DB) *gorm.DB {
+			if deleted {
+				db = db.Unscoped()
+			}
+			return db.Order("id")
+		}).
+		Preload("Emails", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id")
+		}).
+		Preload("Groups", func(db *gorm.DB) *gorm.DB {
+			return db.Order("key")
+		})
+}
+
+func rowsAffected(result *gorm.DB) (int64, error) {
+	if result.Error != nil {
+		return 0, translateError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return 0, model.ErrNotFound
+	}
+	return result.RowsAffected, nil
 }
 
-func (s *Storage) Delete(name string) *gorm.DB {
-	return s.db.Exec("DELETE FROM users WHERE name LIKE ?", name)
+// translateError maps driver errors onto the model sentinel errors.
+func translateError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.ErrNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		if pgErr.ConstraintName == groupKeyIndex {
+			return model.ErrDuplicateGroup
+		}
+		return model.ErrDuplicatePhone
+	}
+	if isUnavailable(err) {
+		return fmt.Errorf("%w: %v", model.ErrUnavailable, err)
+	}
+	return err
 }
 
-func (s *Storage) Update(phone string, updatedUser model.User) *gorm.DB {
-	return s.db.Exec("UPDATE users SET name=?, phone=?, address=? WHERE phone=?", updatedUser.Name, updatedUser.Phone, updatedUser.Address, phone)
+func isUnavailable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == tooManyConnections ||
+			strings.HasPrefix(pgErr.Code, connectionException) ||
+			strings.HasPrefix(pgErr.Code, operatorIntervention)
+	}
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr) || pgconn.Timeout(err)
 }
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+// Limits of the attributes of a single user.
+const (
+	MaxAttributes           = 50
+	MaxAttributeKeyLength   = 64
+	MaxAttributeValueLength = 1024
+)
+
+// attributePrefix starts the attribute fields of filter expressions, e.g.
+// attributes.customerId:42.
+const attributePrefix = "attributes."
+
+// validateAttributes checks the number of attributes and the size of their
+// keys and values.
+func validateAttributes(attributes model.Attributes) error {
+	if len(attributes) > MaxAttributes {
+		return Invalid(ErrTooManyAttributes, MaxAttributes)
+	}
+	for key, value := range attributes {
+		if !isAttributeKey(key) {
+			return Invalid(ErrInvalidAttributeKey, key, MaxAttributeKeyLength)
+		}
+		if utf8.RuneCountInString(value) > MaxAttributeValueLength {
+			return Invalid(ErrAttributeValueTooLong, key, MaxAttributeValueLength)
+		}
+	}
+	return nil
+}
+
+// isAttributeKey reports whether key is 1 to MaxAttributeKeyLength ASCII
+// letters, digits, underscores, hyphens or dots, so that it can be used in
+// filter expressions as it is.
+func isAttributeKey(key string) bool {
+	if key == "" || len(key) > MaxAttributeKeyLength {
+		return false
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '_' || r == '-' || r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// attributeExpr joins expr with a term for every attribute pattern of a
+// FindUser filter, in the order of the keys. A bare * tests that the
+// attribute is set, * inside other patterns matches any sequence of
+// characters.
+func attributeExpr(patterns model.Attributes, expr *model.Expr) (*model.Expr, error) {
+	if len(patterns) == 0 {
+		return expr, nil
+	}
+	keys := make([]string, 0, len(patterns))
+	for key := range patterns {
+		if !isAttributeKey(key) {
+			return nil, Invalid(ErrInvalidAttributeKey, key, MaxAttributeKeyLength)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	and := model.Expr{Kind: model.ExprAnd}
+	if expr != nil {
+		and.Operands = append(and.Operands, *expr)
+	}
+	for _, key := range keys {
+		term := model.Expr{Kind: model.ExprExists, Field: model.FieldAttribute, Key: key}
+		if value := patterns[key]; value != "*" {
+			term.Kind, term.Pattern = model.ExprMatch, likePattern(value)
+		}
+		and.Operands = append(and.Operands, term)
+	}
+	return &and, nil
+}
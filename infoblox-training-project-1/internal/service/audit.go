@@ -0,0 +1,68 @@
+package service
+
+import (
+	"time"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+// AuditOptions page through audit events created in [From, To) by Actor,
+// newest first. Zero fields do not filter.
+type AuditOptions struct {
+	From      time.Time
+	To        time.Time
+	Actor     string
+	PageSize  int32
+	PageToken string
+}
+
+type AuditPage struct {
+	Events        []model.AuditEvent
+	NextPageToken string
+}
+
+func (abs *AddressBookService) RecordAuditEvent(event model.AuditEvent) error {
+	if err := abs.storage.StoreAuditEvent(event); err != nil {
+		return Internal(err)
+	}
+	return nil
+}
+
+func (abs *AddressBookService) ListAuditEvents(opts AuditOptions) (AuditPage, error) {
+	if opts.PageSize < 0 {
+		return AuditPage{}, Invalid(ErrNegativePageSize)
+	}
+	if !opts.From.IsZero() && !opts.To.IsZero() && !opts.From.Before(opts.To) {
+		return AuditPage{}, Invalid(ErrInvalidTimeRange)
+	}
+	size := int(opts.PageSize)
+	if size == 0 {
+		size = DefaultPageSize
+	}
+	if size > MaxPageSize {
+		size = MaxPageSize
+	}
+	// Pages go back in time, the token keeps the ID of the last event.
+	token, err := decodePageToken(opts.PageToken)
+	if err != nil {
+		return AuditPage{}, err
+	}
+
+	// One extra event tells whether there is a next page.
+	events, err := abs.storage.LoadAuditEvents(model.AuditQuery{
+		From:     opts.From,
+		To:       opts.To,
+		Actor:    opts.Actor,
+		BeforeID: token.AfterID,
+		Limit:    size + 1,
+	})
+	if err != nil {
+		return AuditPage{}, Internal(err)
+	}
+	page := AuditPage{Events: events}
+	if len(events) > size {
+		page.Events = events[:size]
+		page.NextPageToken = encodePageToken(pageToken{AfterID: page.Events[size-1].ID})
+	}
+	return page, nil
+}
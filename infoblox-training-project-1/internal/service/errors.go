@@ -0,0 +1,58 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+type ErrorKind int
+
+const (
+	KindInternal ErrorKind = iota
+	KindNotFound
+	KindConflict
+	KindValidation
+	KindUnavailable
+	KindFailedPrecondition
+)
+
+// Error is returned by AddressBookService methods. Message is safe to show
+// to clients, while Err keeps the underlying cause for logging.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func NotFound(format string, a ...interface{}) error {
+	return &Error{Kind: KindNotFound, Message: fmt.Sprintf(format, a...)}
+}
+
+func Conflict(format string, a ...interface{}) error {
+	return &Error{Kind: KindConflict, Message: fmt.Sprintf(format, a...)}
+}
+
+func Invalid(format string, a ...interface{}) error {
+	return &Error{Kind: KindValidation, Message: fmt.Sprintf(format, a...)}
+}
+
+func FailedPrecondition(format string, a ...interface{}) error {
+	return &Error{Kind: KindFailedPrecondition, Message: fmt.Sprintf(format, a...)}
+}
+
+func Internal(err error) error {
+	if errors.Is(err, model.ErrUnavailable) {
+		return &Error{Kind: KindUnavailable, Message: ErrStorageUnavailable, Err: err}
+	}
+	return &Error{Kind: KindInternal, Message: ErrInternal, Err: err}
+}
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+// MaxFilterDepth limits nesting of parentheses and NOT in filter expressions.
+const MaxFilterDepth = 32
+
+// filterFields maps the field names of filter expressions to model fields.
+var filterFields = map[string]string{
+	"name":         model.FieldName,
+	"phone":        model.FieldPhone,
+	"address":      model.FieldAddress,
+	"street":       model.FieldStreet,
+	"city":         model.FieldCity,
+	"region":       model.FieldRegion,
+	"postalCode":   model.FieldPostalCode,
+	"postal_code":  model.FieldPostalCode,
+	"countryCode":  model.FieldCountryCode,
+	"country_code": model.FieldCountryCode,
+	"email":        model.FieldEmail,
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenColon
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of filter"
+	case tokenString:
+		return fmt.Sprintf("string %q at position %d", t.text, t.pos)
+	}
+	return fmt.Sprintf("%q at position %d", t.text, t.pos)
+}
+
+func (t token) is(keyword string) bool {
+	return t.kind == tokenWord && t.text == keyword
+}
+
+func isKeyword(t token) bool {
+	return t.is("AND") || t.is("OR") || t.is("NOT")
+}
+
+// tokenize splits a filter into tokens, positions count runes from 1.
+func tokenize(filter string) ([]token, error) {
+	runes := []rune(filter)
+	var tokens []token
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == ':':
+			tokens = append(tokens, token{kind: tokenColon, text: ":", pos: i + 1})
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i + 1})
+			i++
+		case r == '"':
+			start := i
+			var b strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				b.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("unterminated string starting at position %d", start+1)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: b.String(), pos: start + 1})
+			i++
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`:()"`, runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: string(runes[start:i]), pos: start + 1})
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes) + 1}), nil
+}
+
+// filterParser is a recursive descent parser of the grammar
+//
+//	or    = and { "OR" and }
+//	and   = unary { [ "AND" ] unary }
+//	unary = "NOT" unary | "(" or ")" | field ":" value
+//
+// where a bare * value tests that the field is set and * inside other
+// values matches any sequence of characters.
+type filterParser struct {
+	tokens []token
+	next   int
+	depth  int
+	phones PhoneNormalizer
+}
+
+// parseFilter parses a filter expression into a model expression, an empty
+// filter yields nil.
+func (abs *AddressBookService) parseFilter(filter string) (*model.Expr, error) {
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, Invalid(ErrInvalidFilter, err)
+	}
+	if tokens[0].kind == tokenEOF {
+		return nil, nil
+	}
+	p := &filterParser{tokens: tokens, phones: abs.phones}
+	e, err := p.or()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = fmt.Errorf("unexpected %s", p.peek())
+	}
+	if err != nil {
+		return nil, Invalid(ErrInvalidFilter, err)
+	}
+	return &e, nil
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *filterParser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *filterParser) or() (model.Expr, error) {
+	e, err := p.and()
+	if err != nil {
+		return model.Expr{}, err
+	}
+	operands := []model.Expr{e}
+	for p.peek().is("OR") {
+		p.advance()
+		e, err := p.and()
+		if err != nil {
+			return model.Expr{}, err
+		}
+		operands = append(operands, e)
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return model.Expr{Kind: model.ExprOr, Operands: operands}, nil
+}
+
+func (p *filterParser) and() (model.Expr, error) {
+	e, err := p.unary()
+	if err != nil {
+		return model.Expr{}, err
+	}
+	operands := []model.Expr{e}
+	for {
+		t := p.peek()
+		if t.is("AND") {
+			p.advance()
+		} else if t.kind == tokenEOF || t.kind == tokenRParen || t.is("OR") {
+			break
+		}
+		e, err := p.unary()
+		if err != nil {
+			return model.Expr{}, err
+		}
+		operands = append(operands, e)
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return model.Expr{Kind: model.ExprAnd, Operands: operands}, nil
+}
+
+func (p *filterParser) unary() (model.Expr, error) {
+	t := p.peek()
+	switch {
+	case t.is("NOT"):
+		p.advance()
+		if err := p.nest(); err != nil {
+			return model.Expr{}, err
+		}
+		e, err := p.unary()
+		p.depth--
+		if err != nil {
+			return model.Expr{}, err
+		}
+		return model.Expr{Kind: model.ExprNot, Operands: []model.Expr{e}}, nil
+	case t.kind == tokenLParen:
+		p.advance()
+		if err := p.nest(); err != nil {
+			return model.Expr{}, err
+		}
+		e, err := p.or()
+		p.depth--
+		if err != nil {
+			return model.Expr{}, err
+		}
+		if closing := p.advance(); closing.kind != tokenRParen {
+			return model.Expr{}, fmt.Errorf("missing ) for ( at position %d, got %s", t.pos, closing)
+		}
+		return e, nil
+	}
+	return p.term()
+}
+
+func (p *filterParser) nest() error {
+	p.depth++
+	if p.depth > MaxFilterDepth {
+		return fmt.Errorf("nested deeper than %d levels", MaxFilterDepth)
+	}
+	return nil
+}
+
+func (p *filterParser) term() (model.Expr, error) {
+	name := p.advance()
+	if name.kind != tokenWord || isKeyword(name) {
+		return model.Expr{}, fmt.Errorf("expected field:value, got %s", name)
+	}
+	field, ok := filterFields[name.text]
+	var key string
+	if !ok && strings.HasPrefix(name.text, attributePrefix) {
+		key = strings.TrimPrefix(name.text, attributePrefix)
+		if !isAttributeKey(key) {
+			return model.Expr{}, fmt.Errorf("invalid attribute key %q at position %d", key, name.pos)
+		}
+		field, ok = model.FieldAttribute, true
+	}
+	if !ok {
+		return model.Expr{}, fmt.Errorf("unknown field %q at position %d", name.text, name.pos)
+	}
+	if colon := p.advance(); colon.kind != tokenColon {
+		return model.Expr{}, fmt.Errorf("expected : after field %q at position %d, got %s", name.text, name.pos, colon)
+	}
+	value := p.advance()
+	if value.kind != tokenString && (value.kind != tokenWord || isKeyword(value)) {
+		return model.Expr{}, fmt.Errorf("expected value for field %q, got %s", name.text, value)
+	}
+	if value.kind == tokenWord && value.text == "*" {
+		return model.Expr{Kind: model.ExprExists, Field: field, Key: key}, nil
+	}
+	return model.Expr{Kind: model.ExprMatch, Field: field, Key: key, Pattern: p.pattern(field, value.text)}, nil
+}
+
+// pattern converts a filter value into a LIKE pattern in which only * is a
+// wildcard.
+func (p *filterParser) pattern(field, value string) string {
+	switch field {
+	case model.FieldName, model.FieldAddress:
+		value = model.SearchKey(value)
+	case model.FieldPhone:
+		value = p.phones.NormalizePattern(value)
+	}
+	return likePattern(value)
+}
+
+// likePattern escapes the LIKE wildcards of value and turns its * into %.
+func likePattern(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+	return wildcards(value)
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+func (abs *AddressBookService) AddGroup(group model.Group) (model.Group, error) {
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" {
+		return model.Group{}, Invalid(ErrEmptyGroupName)
+	}
+	stored, err := abs.storage.StoreGroup(group)
+	if errors.Is(err, model.ErrDuplicateGroup) {
+		return model.Group{}, Conflict(ErrGroupAlreadyExists, group.Name)
+	}
+	if err != nil {
+		return model.Group{}, Internal(err)
+	}
+	return stored, nil
+}
+
+func (abs *AddressBookService) ListGroups() ([]model.Group, error) {
+	groups, err := abs.storage.LoadGroups()
+	if err != nil {
+		return nil, Internal(err)
+	}
+	return groups, nil
+}
+
+func (abs *AddressBookService) GetGroup(id uint) (model.Group, error) {
+	if id == 0 {
+		return model.Group{}, Invalid(ErrEmptyGroupID)
+	}
+	group, err := abs.storage.GetGroup(id)
+	if err != nil {
+		return model.Group{}, groupError(err, id)
+	}
+	return group, nil
+}
+
+// UpdateGroup writes the fields of the group, its name and description
+// when there are none.
+func (abs *AddressBookService) UpdateGroup(id uint, updatedGroup model.Group, fields []string) (model.Group, error) {
+	if id == 0 {
+		return model.Group{}, Invalid(ErrEmptyGroupID)
+	}
+	if len(fields) == 0 {
+		fields = model.UpdatableGroupFields
+	}
+	for _, field := range fields {
+		if !contains(model.UpdatableGroupFields, field) {
+			return model.Group{}, Invalid(ErrUnknownField, field)
+		}
+	}
+	updatedGroup.Name = strings.TrimSpace(updatedGroup.Name)
+	if contains(fields, model.FieldGroupName) && updatedGroup.Name == "" {
+		return model.Group{}, Invalid(ErrEmptyGroupName)
+	}
+	group, err := abs.storage.UpdateGroup(id, updatedGroup, fields)
+	if errors.Is(err, model.ErrDuplicateGroup) {
+		return model.Group{}, Conflict(ErrGroupAlreadyExists, updatedGroup.Name)
+	}
+	if err != nil {
+		return model.Group{}, groupError(err, id)
+	}
+	return group, nil
+}
+
+// DeleteGroup deletes the group, its members stay.
+func (abs *AddressBookService) DeleteGroup(id uint) (string, error) {
+	if id == 0 {
+		return "", Invalid(ErrEmptyGroupID)
+	}
+	if err := abs.storage.DeleteGroup(id); err != nil {
+		return "", groupError(err, id)
+	}
+	return fmt.Sprintf(DeleteGroupResponse, id), nil
+}
+
+// AddGroupMembers adds live users to the group, all of them or none if any
+// is missing. Users already in the group are not counted.
+func (abs *AddressBookService) AddGroupMembers(id uint, userIDs []uint) (string, int64, error) {
+	userIDs, err := memberIDs(id, userIDs)
+	if err != nil {
+		return "", 0, err
+	}
+	added, err := abs.storage.AddGroupMembers(id, userIDs)
+	if err != nil {
+		return "", 0, groupError(err, id)
+	}
+	return fmt.Sprintf(AddedMembersResponse, added), added, nil
+}
+
+// RemoveGroupMembers removes users from the group, users that are not in
+// it are not counted.
+func (abs *AddressBookService) RemoveGroupMembers(id uint, userIDs []uint) (string, int64, error) {
+	userIDs, err := memberIDs(id, userIDs)
+	if err != nil {
+		return "", 0, err
+	}
+	removed, err := abs.storage.RemoveGroupMembers(id, userIDs)
+	if err != nil {
+		return "", 0, groupError(err, id)
+	}
+	return fmt.Sprintf(RemovedMembersResponse, removed), removed, nil
+}
+
+// memberIDs validates the ids of a membership change and drops repeated ones.
+func memberIDs(id uint, userIDs []uint) ([]uint, error) {
+	if id == 0 {
+		return nil, Invalid(ErrEmptyGroupID)
+	}
+	if len(userIDs) == 0 {
+		return nil, Invalid(ErrEmptyUserIDs)
+	}
+	if len(userIDs) > MaxPageSize {
+		return nil, Invalid(ErrTooManyUserIDs, MaxPageSize)
+	}
+	distinct := make([]uint, 0, len(userIDs))
+	seen := make(map[uint]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if userID == 0 {
+			return nil, Invalid(ErrEmptyID)
+		}
+		if !seen[userID] {
+			seen[userID] = true
+			distinct = append(distinct, userID)
+		}
+	}
+	return distinct, nil
+}
+
+func groupError(err error, id uint) error {
+	if errors.Is(err, model.ErrGroupNotFound) {
+		return NotFound(ErrNoSuchGroupWithID, id)
+	}
+	if errors.Is(err, model.ErrNotFound) {
+		return NotFound(ErrMembersNotFound)
+	}
+	return Internal(err)
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+// Revision is a revision of a user with the fields it changed compared to
+// the previous one. The first revision changes every field that has a value.
+type Revision struct {
+	model.UserRevision
+	Changed []string
+}
+
+func (abs *AddressBookService) GetUserHistory(id uint) ([]Revision, error) {
+	if id == 0 {
+		return nil, Invalid(ErrEmptyID)
+	}
+	revisions, err := abs.storage.History(id)
+	if errors.Is(err, model.ErrNotFound) {
+		return nil, NotFound(ErrNoSuchUserWithID, id)
+	}
+	if err != nil {
+		return nil, Internal(err)
+	}
+	history := make([]Revision, 0, len(revisions))
+	var prev model.Snapshot
+	for _, r := range revisions {
+		history = append(history, Revision{UserRevision: r, Changed: r.Snapshot.Changes(prev)})
+		prev = r.Snapshot
+	}
+	return history, nil
+}
+
+func (abs *AddressBookService) RevertUser(id, revision uint) (model.User, error) {
+	if id == 0 {
+		return model.User{}, Invalid(ErrEmptyID)
+	}
+	if revision == 0 {
+		return model.User{}, Invalid(ErrEmptyRevision)
+	}
+	user, err := abs.storage.Revert(id, revision)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		return model.User{}, NotFound(ErrNoSuchUserWithID, id)
+	case errors.Is(err, model.ErrNoRevision):
+		return model.User{}, NotFound(ErrNoSuchRevision, id, revision)
+	case errors.Is(err, model.ErrDuplicatePhone):
+		return model.User{}, Conflict(ErrRevertPhoneIsTaken, id, revision)
+	case err != nil:
+		return model.User{}, Internal(err)
+	}
+	return user, nil
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+// ImportBatchSize is the number of users stored per transaction.
+const ImportBatchSize = 100
+
+// ImportRow is a user read by an import. Err is set for rows the caller
+// already rejected, they are reported as failed.
+type ImportRow struct {
+	User model.User
+	Err  error
+}
+
+// ImportRowResult is the outcome of a row, counted from 0. ID is set for
+// created and updated users, Reason for skipped and failed ones.
+type ImportRowResult struct {
+	Row    int
+	Status string
+	ID     uint
+	Reason string
+}
+
+type ImportReport struct {
+	Rows    []ImportRowResult
+	Created int
+	Updated int
+	Skipped int
+	Failed  int
+}
+
+func (r *ImportReport) add(result ImportRowResult) {
+	r.Rows = append(r.Rows, result)
+	switch result.Status {
+	case model.ImportCreated:
+		r.Created++
+	case model.ImportUpdated:
+		r.Updated++
+	case model.ImportSkipped:
+		r.Skipped++
+	default:
+		r.Failed++
+	}
+}
+
+// ImportUsers adds the users returned by next until it returns io.EOF,
+// storing them in batches of ImportBatchSize. Every row is validated like
+// in AddUser; users whose phones are taken are skipped, overwrite the owner
+// of the phones or fail according to onConflict, which defaults to fail.
+// Batches stored before next fails with another error are kept.
+func (abs *AddressBookService) ImportUsers(onConflict string, next func() (ImportRow, error)) (ImportReport, error) {
+	if onConflict == "" {
+		onConflict = model.OnConflictFail
+	}
+	if !contains(model.OnConflictModes, onConflict) {
+		return ImportReport{}, Invalid(ErrUnknownOnConflict, onConflict)
+	}
+
+	var report ImportReport
+	var batch []model.User
+	var rows []int
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		results, err := abs.storage.Import(batch, onConflict)
+		for i, row := range rows {
+			if err != nil {
+				report.add(ImportRowResult{Row: row, Status: model.ImportFailed, Reason: Internal(err).Error()})
+				continue
+			}
+			report.add(importRowResult(row, batch[i], results[i]))
+		}
+		batch, rows = nil, nil
+	}
+
+	for row := 0; ; row++ {
+		r, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return ImportReport{}, err
+		}
+		user := r.User
+		if err = r.Err; err == nil {
+			if err = abs.normalizePhones(&user, model.UpdatableFields); err == nil {
+				err = validateContacts(user)
+			}
+			if err == nil {
+				err = validateAttributes(user.Attributes)
+			}
+		}
+		if err != nil {
+			report.add(ImportRowResult{Row: row, Status: model.ImportFailed, Reason: err.Error()})
+			continue
+		}
+		user.Normalize()
+		batch, rows = append(batch, user), append(rows, row)
+		if len(batch) == ImportBatchSize {
+			flush()
+		}
+	}
+	flush()
+
+	sort.Slice(report.Rows, func(i, j int) bool {
+		return report.Rows[i].Row < report.Rows[j].Row
+	})
+	return report, nil
+}
+
+func importRowResult(row int, user model.User, result model.ImportResult) ImportRowResult {
+	r := ImportRowResult{Row: row, Status: result.Status, ID: result.User.ID}
+	switch {
+	case result.Err == nil:
+	case errors.Is(result.Err, model.ErrDuplicatePhone):
+		r.Reason = fmt.Sprintf(ErrUserAlreadyExist, numbers(user))
+	case errors.Is(result.Err, model.ErrSeveralOwners):
+		r.Reason = fmt.Sprintf(ErrPhonesOfSeveralUsers, numbers(user))
+	default:
+		r.Reason = Internal(result.Err).Error()
+	}
+	return r
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+// DefaultDuplicateScore is the name and address similarity FindDuplicates
+// requires by default, stricter than MinSearchScore since nobody asked for
+// the users.
+const DefaultDuplicateScore = 0.5
+
+// MaxMergeUsers is the number of users a single merge may fold together.
+const MaxMergeUsers = 20
+
+// FindDuplicates clusters live users that are likely the same person. At
+// most limit groups are returned, DefaultPageSize when it is zero, and
+// minScore defaults to DefaultDuplicateScore.
+func (abs *AddressBookService) FindDuplicates(minScore float64, limit int32) ([]model.DuplicateGroup, error) {
+	if minScore < 0 || minScore > 1 {
+		return nil, Invalid(ErrInvalidMinScore)
+	}
+	if minScore == 0 {
+		minScore = DefaultDuplicateScore
+	}
+	if limit < 0 {
+		return nil, Invalid(ErrNegativePageSize)
+	}
+	size := int(limit)
+	if size == 0 {
+		size = DefaultPageSize
+	}
+	if size > MaxPageSize {
+		size = MaxPageSize
+	}
+
+	groups, err := abs.storage.FindDuplicates(model.DuplicateQuery{MinScore: minScore, Limit: size})
+	if err != nil {
+		return nil, Internal(err)
+	}
+	return groups, nil
+}
+
+// MergeUsers folds the users with the given ids into the survivor, the
+// first of them when it is zero, and deletes the others. Resolution maps
+// model.MergeFields to their strategies. It returns the survivor and the
+// ids of the deleted users.
+func (abs *AddressBookService) MergeUsers(ids []uint, survivor uint, resolution map[string]string) (model.User, []uint, error) {
+	m, err := mergeOf(ids, survivor, resolution)
+	if err != nil {
+		return model.User{}, nil, err
+	}
+	user, err := abs.storage.Merge(m)
+	if errors.Is(err, model.ErrNotFound) {
+		return model.User{}, nil, NotFound(ErrMergedUsersNotFound)
+	}
+	if errors.Is(err, model.ErrDuplicatePhone) {
+		return model.User{}, nil, Conflict(ErrMergedPhoneIsTaken)
+	}
+	if err != nil {
+		return model.User{}, nil, Internal(err)
+	}
+	return user, m.Merged, nil
+}
+
+// mergeOf validates a merge request, dropping repeated ids and fields
+// without a strategy.
+func mergeOf(ids []uint, survivor uint, resolution map[string]string) (model.Merge, error) {
+	var distinct []uint
+	seen := map[uint]bool{}
+	for _, id := range ids {
+		if id == 0 {
+			return model.Merge{}, Invalid(ErrEmptyID)
+		}
+		if !seen[id] {
+			seen[id] = true
+			distinct = append(distinct, id)
+		}
+	}
+	if len(distinct) < 2 {
+		return model.Merge{}, Invalid(ErrTooFewUsersToMerge)
+	}
+	if len(distinct) > MaxMergeUsers {
+		return model.Merge{}, Invalid(ErrTooManyUsersToMerge, MaxMergeUsers)
+	}
+	if survivor == 0 {
+		survivor = distinct[0]
+	}
+	if !seen[survivor] {
+		return model.Merge{}, Invalid(ErrSurvivorNotMerged, survivor)
+	}
+
+	m := model.Merge{Survivor: survivor, Resolution: map[string]string{}}
+	for _, id := range distinct {
+		if id != survivor {
+			m.Merged = append(m.Merged, id)
+		}
+	}
+	for field, strategy := range resolution {
+		strategies, ok := model.MergeStrategies[field]
+		if !ok {
+			return model.Merge{}, Invalid(ErrUnknownField, field)
+		}
+		if strategy == "" {
+			continue
+		}
+		if !contains(strategies, strategy) {
+			return model.Merge{}, Invalid(ErrUnknownMergeStrategy, strategy, field)
+		}
+		m.Resolution[field] = strategy
+	}
+	return m, nil
+}
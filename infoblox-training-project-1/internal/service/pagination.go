@@ -0,0 +1,223 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 1000
+)
+
+// ListOptions page through users. OrderBy is a comma separated list of
+// fields, each optionally followed by asc or desc, e.g. "name, created_at desc".
+// A non-empty Group limits the users to the members of the group with that
+// name, ignoring case.
+type ListOptions struct {
+	PageSize  int32
+	PageToken string
+	OrderBy   string
+	Group     string
+}
+
+// orderFields maps the sortable fields of OrderBy to model fields.
+var orderFields = map[string]string{
+	"id":         model.FieldID,
+	"name":       model.FieldName,
+	"userName":   model.FieldName,
+	"user_name":  model.FieldName,
+	"phone":      model.FieldPhone,
+	"address":    model.FieldAddress,
+	"createdAt":  model.FieldCreatedAt,
+	"created_at": model.FieldCreatedAt,
+	"updatedAt":  model.FieldUpdatedAt,
+	"updated_at": model.FieldUpdatedAt,
+}
+
+type Page struct {
+	Users         []model.User
+	NextPageToken string
+	TotalSize     int64
+}
+
+// pageToken is the decoded form of the opaque token handed out to clients.
+// It holds the ID of the last user on the previous page and, for sorted
+// pages, the order and that user's sort keys.
+type pageToken struct {
+	AfterID uint     `json:"a"`
+	Order   string   `json:"o,omitempty"`
+	Keys    []string `json:"k,omitempty"`
+}
+
+func encodePageToken(t pageToken) string {
+	b, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePageToken(token string) (pageToken, error) {
+	var t pageToken
+	if token == "" {
+		return t, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return t, Invalid(ErrInvalidPageToken)
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, Invalid(ErrInvalidPageToken)
+	}
+	return t, nil
+}
+
+// loadPage loads one page of users matching q through the keyset
+// cursor carried by opts.PageToken.
+func (abs *AddressBookService) loadPage(q model.Query, opts ListOptions) (Page, error) {
+	if opts.PageSize < 0 {
+		return Page{}, Invalid(ErrNegativePageSize)
+	}
+	size := int(opts.PageSize)
+	if size == 0 {
+		size = DefaultPageSize
+	}
+	if size > MaxPageSize {
+		size = MaxPageSize
+	}
+	order, err := parseOrderBy(opts.OrderBy)
+	if err != nil {
+		return Page{}, err
+	}
+	token, err := decodePageToken(opts.PageToken)
+	if err != nil {
+		return Page{}, err
+	}
+	if token.AfterID > 0 && token.Order != formatOrder(order) {
+		return Page{}, Invalid(ErrPageTokenOrder)
+	}
+	after, err := cursor(order, token.Keys)
+	if err != nil {
+		return Page{}, err
+	}
+
+	if opts.Group != "" {
+		q.Group = model.SearchKey(opts.Group)
+	}
+	// One extra row tells whether there is a next page.
+	q.OrderBy, q.After, q.AfterID, q.Limit = order, after, token.AfterID, size+1
+	users, total, err := abs.storage.Load(q)
+	if err != nil {
+		return Page{}, Internal(err)
+	}
+	page := Page{Users: users, TotalSize: total}
+	if len(users) > size {
+		page.Users = users[:size]
+		last := page.Users[size-1]
+		page.NextPageToken = encodePageToken(pageToken{AfterID: last.ID, Order: formatOrder(order), Keys: sortKeys(last, order)})
+	}
+	return page, nil
+}
+
+func parseOrderBy(orderBy string) ([]model.Order, error) {
+	if strings.TrimSpace(orderBy) == "" {
+		return nil, nil
+	}
+	var order []model.Order
+	seen := map[string]bool{}
+	for _, term := range strings.Split(orderBy, ",") {
+		parts := strings.Fields(term)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, Invalid(ErrInvalidOrderBy, fmt.Sprintf("malformed term %q", strings.TrimSpace(term)))
+		}
+		field, ok := orderFields[parts[0]]
+		if !ok {
+			return nil, Invalid(ErrInvalidOrderBy, fmt.Sprintf("cannot sort by %q", parts[0]))
+		}
+		if seen[field] {
+			return nil, Invalid(ErrInvalidOrderBy, fmt.Sprintf("%q is repeated", parts[0]))
+		}
+		seen[field] = true
+		o := model.Order{Field: field}
+		if len(parts) == 2 {
+			switch strings.ToLower(parts[1]) {
+			case "asc":
+			case "desc":
+				o.Desc = true
+			default:
+				return nil, Invalid(ErrInvalidOrderBy, fmt.Sprintf("unknown direction %q, use asc or desc", parts[1]))
+			}
+		}
+		order = append(order, o)
+	}
+	return order, nil
+}
+
+// formatOrder returns the canonical form of an order, used to tie page
+// tokens to the order they were issued for.
+func formatOrder(order []model.Order) string {
+	terms := make([]string, 0, len(order))
+	for _, o := range order {
+		dir := " asc"
+		if o.Desc {
+			dir = " desc"
+		}
+		terms = append(terms, o.Field+dir)
+	}
+	return strings.Join(terms, ", ")
+}
+
+func sortKeys(u model.User, order []model.Order) []string {
+	keys := make([]string, 0, len(order))
+	for _, o := range order {
+		switch o.Field {
+		case model.FieldName:
+			keys = append(keys, u.NameKey)
+		case model.FieldPhone:
+			keys = append(keys, u.Phone)
+		case model.FieldAddress:
+			keys = append(keys, u.AddressKey)
+		case model.FieldCreatedAt:
+			keys = append(keys, u.CreatedAt.Format(time.RFC3339Nano))
+		case model.FieldUpdatedAt:
+			keys = append(keys, u.UpdatedAt.Format(time.RFC3339Nano))
+		default:
+			keys = append(keys, strconv.FormatUint(uint64(u.ID), 10))
+		}
+	}
+	return keys
+}
+
+// cursor rebuilds the sort keys of the last user of the previous page.
+func cursor(order []model.Order, keys []string) (model.User, error) {
+	var u model.User
+	if len(keys) == 0 {
+		return u, nil
+	}
+	if len(keys) != len(order) {
+		return u, Invalid(ErrInvalidPageToken)
+	}
+	for i, o := range order {
+		var err error
+		switch o.Field {
+		case model.FieldName:
+			u.NameKey = keys[i]
+		case model.FieldPhone:
+			u.Phone = keys[i]
+		case model.FieldAddress:
+			u.AddressKey = keys[i]
+		case model.FieldCreatedAt:
+			u.CreatedAt, err = time.Parse(time.RFC3339Nano, keys[i])
+		case model.FieldUpdatedAt:
+			u.UpdatedAt, err = time.Parse(time.RFC3339Nano, keys[i])
+		}
+		if err != nil {
+			return u, Invalid(ErrInvalidPageToken)
+		}
+	}
+	return u, nil
+}
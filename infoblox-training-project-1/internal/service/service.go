@@ -1,103 +1,583 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"strings"
-
-	"gorm.io/gorm"
+	"time"
 
 	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+	"github.com/vstarostin/infoblox-training-project-1/internal/phonenumber"
 )
 
 const (
 	ErrUserAlreadyExist      = "user with phone %s already exists. Please write a correct one"
-	ErrAddressBookIsEmpty    = "addressBook is empty"
 	ErrNoSuchUserWithPhone   = "no such user with phone: %v"
 	ErrNoSuchUserWithName    = "no such user with name: %v"
 	DeleteUserMethodResponse = "%d user(s) was(were) deleted"
+	DeleteUserDryRunResponse = "%d user(s) would be deleted"
+	PurgeUsersResponse       = "%d deleted user(s) was(were) purged"
 	ErrPhoneIsTaken          = "phone %v is already taken. Please write a correct one"
 	ErrUserDoesNotExist      = "user does not exist"
+	ErrStorageUnavailable    = "address book is temporarily unavailable"
+	ErrInternal              = "internal error"
+	ErrInvalidPageToken      = "invalid page token"
+	ErrPageTokenOrder        = "page token was issued for a different order"
+	ErrInvalidOrderBy        = "invalid order by: %s"
+	ErrNegativePageSize      = "page size must not be negative"
+	ErrUnknownField          = "unknown field %q"
+	ErrNoSuchUserWithID      = "no such user with id: %d"
+	ErrEmptyID               = "user id must be provided"
+	ErrBulkDeleteNotAllowed  = "%d users match %q, set allowBulk or expectedCount to delete them"
+	ErrUnexpectedCount       = "%d users match %q, but %d were expected"
+	ErrNoSuchDeletedUser     = "no deleted user with id: %d"
+	ErrEmptyOlderThan        = "olderThan must be provided"
+	ErrRestorePhoneIsTaken   = "user %d cannot be restored, its phone is taken by another user"
+	ErrUnknownPhoneType      = "unknown phone type %q"
+	ErrUnknownEmailType      = "unknown email type %q"
+	ErrRepeatedPhone         = "phone %v is listed more than once"
+	ErrSeveralPrimaryPhones  = "only one phone can be primary"
+	ErrSeveralPrimaryEmails  = "only one email can be primary"
+	ErrInvalidPhone          = "invalid phone number %q"
+	ErrNoPhoneLeft           = "the update leaves the user without a phone number"
+	ErrInvalidFilter         = "invalid filter: %s"
+	ErrEmptySearchQuery      = "search query must not be empty"
+	ErrUnknownOnConflict     = "unknown onConflict mode %q"
+	ErrPhonesOfSeveralUsers  = "phones %s belong to several users, cannot overwrite"
+	ErrInvalidMinScore       = "minimum score must be between 0 and 1"
+	ErrTooFewUsersToMerge    = "at least two different users must be merged"
+	ErrTooManyUsersToMerge   = "at most %d users can be merged at once"
+	ErrSurvivorNotMerged     = "survivor %d is not one of the merged users"
+	ErrUnknownMergeStrategy  = "unknown merge strategy %q for field %q"
+	ErrMergedUsersNotFound   = "some of the users to merge do not exist"
+	ErrMergedPhoneIsTaken    = "merged phones are taken by another user"
+	ErrEmptyGroupID          = "group id must be provided"
+	ErrEmptyGroupName        = "group name must not be empty"
+	ErrNoSuchGroupWithID     = "no such group with id: %d"
+	ErrGroupAlreadyExists    = "group %q already exists"
+	ErrEmptyUserIDs          = "user ids must be provided"
+	ErrTooManyUserIDs        = "at most %d user ids can be given at once"
+	ErrMembersNotFound       = "some of the users do not exist"
+	DeleteGroupResponse      = "group %d was deleted"
+	AddedMembersResponse     = "%d user(s) was(were) added to the group"
+	RemovedMembersResponse   = "%d user(s) was(were) removed from the group"
+	ErrTooManyAttributes     = "at most %d attributes are allowed"
+	ErrInvalidAttributeKey   = "invalid attribute key %q, keys are 1 to %d letters, digits, '_', '-' or '.'"
+	ErrAttributeValueTooLong = "value of attribute %q must be at most %d characters long"
+	ErrEmptyRevision         = "revision must be provided"
+	ErrNoSuchRevision        = "user %d has no revision %d"
+	ErrRevertPhoneIsTaken    = "user %d cannot be reverted, the phones of revision %d are taken by another user"
+	ErrInvalidTimeRange      = "from must be before to"
 )
 
+type DeleteOptions struct {
+	DryRun        bool
+	AllowBulk     bool
+	ExpectedCount int64
+}
+
+type DeleteResult struct {
+	Response string
+	Users    []model.User
+	Deleted  int64
+}
+
 type AddressBookService struct {
 	storage AddressBookStorage
+	phones  PhoneNormalizer
 }
 
-func New(storage AddressBookStorage) *AddressBookService {
+func New(storage AddressBookStorage, phones PhoneNormalizer) *AddressBookService {
 	return &AddressBookService{
 		storage: storage,
+		phones:  phones,
 	}
 }
 
+// MinSearchScore is the relevance below which search hits are dropped, it
+// matches the default pg_trgm similarity threshold.
+const MinSearchScore = 0.3
+
+type PhoneNormalizer interface {
+	Normalize(raw string) (phonenumber.Number, error)
+	NormalizePattern(pattern string) string
+}
+
 type AddressBookStorage interface {
-	Load(user model.User) []model.User
-	Store(user model.User) *gorm.DB
-	Delete(name string) *gorm.DB
-	Update(phone string, user model.User) *gorm.DB
+	Load(query model.Query) ([]model.User, int64, error)
+	Store(user model.User) (model.User, error)
+	Delete(name string, expected int64) ([]model.User, error)
+	Update(phone string, user model.User, fields []string) (model.User, error)
+	Get(id uint) (model.User, error)
+	UpdateByID(id uint, user model.User, fields []string) (model.User, error)
+	DeleteByID(id uint) error
+	Restore(id uint) (model.User, error)
+	Purge(olderThan time.Time) (int64, error)
+	Search(query model.SearchQuery) ([]model.SearchHit, error)
+	Export(query model.Query, fn func(model.User) error) error
+	Import(users []model.User, onConflict string) ([]model.ImportResult, error)
+	FindDuplicates(query model.DuplicateQuery) ([]model.DuplicateGroup, error)
+	Merge(m model.Merge) (model.User, error)
+	StoreGroup(group model.Group) (model.Group, error)
+	LoadGroups() ([]model.Group, error)
+	GetGroup(id uint) (model.Group, error)
+	UpdateGroup(id uint, group model.Group, fields []string) (model.Group, error)
+	DeleteGroup(id uint) error
+	AddGroupMembers(id uint, userIDs []uint) (int64, error)
+	RemoveGroupMembers(id uint, userIDs []uint) (int64, error)
+	History(id uint) ([]model.UserRevision, error)
+	Revert(id, revision uint) (model.User, error)
+	StoreAuditEvent(event model.AuditEvent) error
+	LoadAuditEvents(query model.AuditQuery) ([]model.AuditEvent, error)
 }
 
-func (abs *AddressBookService) AddUser(name, phone, address string) error {
-	u := model.User{Name: name, Phone: phone, Address: address}
-	result := abs.storage.Store(u)
-	if result.Error != nil {
-		return fmt.Errorf(ErrUserAlreadyExist, phone)
+func (abs *AddressBookService) AddUser(user model.User) (model.User, error) {
+	if err := abs.normalizePhones(&user, model.UpdatableFields); err != nil {
+		return model.User{}, err
 	}
-	return nil
+	if err := validateContacts(user); err != nil {
+		return model.User{}, err
+	}
+	if err := validateAttributes(user.Attributes); err != nil {
+		return model.User{}, err
+	}
+	user.Normalize()
+	stored, err := abs.storage.Store(user)
+	if errors.Is(err, model.ErrDuplicatePhone) {
+		return model.User{}, Conflict(ErrUserAlreadyExist, numbers(user))
+	}
+	if err != nil {
+		return model.User{}, Internal(err)
+	}
+	return stored, nil
 }
 
-func (abs *AddressBookService) ListUsers() ([]model.User, error) {
+func (abs *AddressBookService) ListUsers(opts ListOptions) (Page, error) {
 	name, phone, address := "%", "%", "%"
 	user := model.User{Name: name, Phone: phone, Address: address}
-	users := abs.storage.Load(user)
-	if len(users) == 0 {
-		return []model.User{}, fmt.Errorf(ErrAddressBookIsEmpty)
+	return abs.loadPage(model.Query{Filter: user}, opts)
+}
+
+// FindUser looks users up by patterns where * matches any sequence of
+// characters. Empty name, phone and address patterns match everything, name
+// and address ignore case. The expression, parsed by parseFilter, and the
+// attribute patterns of the filter narrow the result further.
+func (abs *AddressBookService) FindUser(filter model.User, expression string, opts ListOptions) (Page, error) {
+	expr, err := abs.parseFilter(expression)
+	if err != nil {
+		return Page{}, err
+	}
+	expr, err = attributeExpr(filter.Attributes, expr)
+	if err != nil {
+		return Page{}, err
+	}
+	a := filter.PostalAddress
+	user := model.User{
+		Name:    pattern(model.SearchKey(filter.Name)),
+		Phone:   pattern(abs.phones.NormalizePattern(filter.Phone)),
+		Address: pattern(model.SearchKey(filter.Address)),
+		PostalAddress: model.PostalAddress{
+			Street:      wildcards(a.Street),
+			City:        wildcards(a.City),
+			Region:      wildcards(a.Region),
+			PostalCode:  wildcards(a.PostalCode),
+			CountryCode: wildcards(a.CountryCode),
+		},
+	}
+	page, err := abs.loadPage(model.Query{Filter: user, Expr: expr}, opts)
+	if err != nil {
+		return Page{}, err
 	}
-	return users, nil
+	if page.TotalSize == 0 {
+		return Page{}, NotFound(ErrUserDoesNotExist)
+	}
+	return page, nil
 }
 
-func (abs *AddressBookService) FindUser(name, phone, address string) ([]model.User, error) {
-	if name == "" {
-		name = "%"
+// SearchUsers ranks users by how closely their name, address or phone
+// numbers resemble the query, tolerating typos. At most limit hits are
+// returned, DefaultPageSize when it is zero.
+func (abs *AddressBookService) SearchUsers(query string, limit int32) ([]model.SearchHit, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, Invalid(ErrEmptySearchQuery)
+	}
+	if limit < 0 {
+		return nil, Invalid(ErrNegativePageSize)
 	}
-	if phone == "" {
-		phone = "%"
+	size := int(limit)
+	if size == 0 {
+		size = DefaultPageSize
 	}
-	if address == "" {
-		address = "%"
+	if size > MaxPageSize {
+		size = MaxPageSize
 	}
-	name = strings.ReplaceAll(name, "*", "%")
-	phone = strings.ReplaceAll(phone, "*", "%")
-	address = strings.ReplaceAll(address, "*", "%")
 
-	user := model.User{Name: name, Phone: phone, Address: address}
-	users := abs.storage.Load(user)
-	if len(users) == 0 {
-		return []model.User{}, fmt.Errorf(ErrUserDoesNotExist)
+	phone := abs.phones.NormalizePattern(query)
+	if !isPhonePattern(phone) {
+		phone = ""
+	}
+	hits, err := abs.storage.Search(model.SearchQuery{
+		Text:     model.SearchKey(query),
+		Phone:    phone,
+		MinScore: MinSearchScore,
+		Limit:    size,
+	})
+	if err != nil {
+		return nil, Internal(err)
+	}
+	if len(hits) == 0 {
+		return nil, NotFound(ErrUserDoesNotExist)
+	}
+	return hits, nil
+}
+
+// ExportUsers passes every live user matching the filter expression to
+// send without loading them all at once. An error of send stops the export
+// and is returned unchanged.
+func (abs *AddressBookService) ExportUsers(expression string, send func(model.User) error) error {
+	expr, err := abs.parseFilter(expression)
+	if err != nil {
+		return err
+	}
+	var sendErr error
+	q := model.Query{Filter: model.User{Name: "%", Phone: "%", Address: "%"}, Expr: expr}
+	err = abs.storage.Export(q, func(user model.User) error {
+		sendErr = send(user)
+		return sendErr
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		return Internal(err)
 	}
-	return users, nil
+	return nil
+}
+
+func (abs *AddressBookService) DeleteUser(name string, opts DeleteOptions) (DeleteResult, error) {
+	name = pattern(model.SearchKey(name))
+
+	if opts.DryRun {
+		filter := model.User{Name: name, Phone: "%", Address: "%"}
+		users, total, err := abs.storage.Load(model.Query{Filter: filter, Limit: MaxPageSize})
+		if err != nil {
+			return DeleteResult{}, Internal(err)
+		}
+		if total == 0 {
+			return DeleteResult{}, NotFound(ErrNoSuchUserWithName, name)
+		}
+		return DeleteResult{Response: fmt.Sprintf(DeleteUserDryRunResponse, total), Users: users}, nil
+	}
+
+	// The storage checks the count in the transaction that deletes, so
+	// users added meanwhile cannot be deleted unconfirmed.
+	expected := opts.ExpectedCount
+	if expected == 0 && !opts.AllowBulk {
+		expected = 1
+	}
+	users, err := abs.storage.Delete(name, expected)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		return DeleteResult{}, NotFound(ErrNoSuchUserWithName, name)
+	case errors.Is(err, model.ErrCountMismatch) && opts.ExpectedCount != 0:
+		return DeleteResult{}, FailedPrecondition(ErrUnexpectedCount, len(users), name, opts.ExpectedCount)
+	case errors.Is(err, model.ErrCountMismatch):
+		return DeleteResult{}, FailedPrecondition(ErrBulkDeleteNotAllowed, len(users), name)
+	case err != nil:
+		return DeleteResult{}, Internal(err)
+	}
+	deleted := int64(len(users))
+	return DeleteResult{Response: fmt.Sprintf(DeleteUserMethodResponse, deleted), Users: users, Deleted: deleted}, nil
+}
+
+func (abs *AddressBookService) UpdateUser(phone string, updatedUser model.User, fields []string) (model.User, error) {
+	updatedUser, fields, err := abs.prepareUpdate(updatedUser, fields)
+	if err != nil {
+		return model.User{}, err
+	}
+	// Numbers that do not parse are looked up as they are, they may have
+	// been stored before normalization.
+	if number, err := abs.phones.Normalize(phone); err == nil {
+		phone = number.E164
+	}
+	user, err := abs.storage.Update(phone, updatedUser, fields)
+	return user, updateError(err, NotFound(ErrUserDoesNotExist), updatedUser)
+}
+
+func (abs *AddressBookService) GetUser(id uint) (model.User, error) {
+	if id == 0 {
+		return model.User{}, Invalid(ErrEmptyID)
+	}
+	user, err := abs.storage.Get(id)
+	if errors.Is(err, model.ErrNotFound) {
+		return model.User{}, NotFound(ErrNoSuchUserWithID, id)
+	}
+	if err != nil {
+		return model.User{}, Internal(err)
+	}
+	return user, nil
+}
+
+func (abs *AddressBookService) UpdateUserByID(id uint, updatedUser model.User, fields []string) (model.User, error) {
+	if id == 0 {
+		return model.User{}, Invalid(ErrEmptyID)
+	}
+	updatedUser, fields, err := abs.prepareUpdate(updatedUser, fields)
+	if err != nil {
+		return model.User{}, err
+	}
+	user, err := abs.storage.UpdateByID(id, updatedUser, fields)
+	return user, updateError(err, NotFound(ErrNoSuchUserWithID, id), updatedUser)
 }
 
-func (abs *AddressBookService) DeleteUser(name string) (string, error) {
-	if name == "" {
-		name = "%"
+func (abs *AddressBookService) DeleteUserByID(id uint) (string, error) {
+	if id == 0 {
+		return "", Invalid(ErrEmptyID)
 	}
-	name = strings.ReplaceAll(name, "*", "%")
-	result := abs.storage.Delete(name)
-	if result.RowsAffected == 0 {
-		return "", fmt.Errorf(ErrNoSuchUserWithName, name)
+	err := abs.storage.DeleteByID(id)
+	if errors.Is(err, model.ErrNotFound) {
+		return "", NotFound(ErrNoSuchUserWithID, id)
 	}
-	return fmt.Sprintf(DeleteUserMethodResponse, result.RowsAffected), nil
+	if err != nil {
+		return "", Internal(err)
+	}
+	return fmt.Sprintf(DeleteUserMethodResponse, 1), nil
+}
+
+func (abs *AddressBookService) ListDeletedUsers(opts ListOptions) (Page, error) {
+	user := model.User{Name: "%", Phone: "%", Address: "%"}
+	return abs.loadPage(model.Query{Filter: user, Deleted: true}, opts)
 }
 
-func (abs *AddressBookService) UpdateUser(phone string, updatedUser model.User) error {
-	user := model.User{Name: "%", Address: "%", Phone: phone}
-	existingUser := abs.storage.Load(user)
-	if len(existingUser) == 0 {
-		return fmt.Errorf(ErrUserDoesNotExist)
+func (abs *AddressBookService) RestoreUser(id uint) (model.User, error) {
+	if id == 0 {
+		return model.User{}, Invalid(ErrEmptyID)
+	}
+	user, err := abs.storage.Restore(id)
+	if errors.Is(err, model.ErrNotFound) {
+		return model.User{}, NotFound(ErrNoSuchDeletedUser, id)
 	}
-	result := abs.storage.Update(phone, updatedUser)
-	if result.Error != nil {
-		return fmt.Errorf(ErrPhoneIsTaken, updatedUser.Phone)
+	if errors.Is(err, model.ErrDuplicatePhone) {
+		return model.User{}, Conflict(ErrRestorePhoneIsTaken, id)
 	}
+	if err != nil {
+		return model.User{}, Internal(err)
+	}
+	return user, nil
+}
+
+func (abs *AddressBookService) PurgeDeletedUsers(olderThan time.Time) (string, int64, error) {
+	if olderThan.IsZero() {
+		return "", 0, Invalid(ErrEmptyOlderThan)
+	}
+	purged, err := abs.storage.Purge(olderThan)
+	if err != nil {
+		return "", 0, Internal(err)
+	}
+	return fmt.Sprintf(PurgeUsersResponse, purged), purged, nil
+}
+
+// updateFields validates the fields of an update request. Without a mask
+// the name is written along with the postal address or the legacy address
+// and the full list of phones or the legacy phone, preferring the structured
+// ones when they are given, plus emails and attributes when they are given.
+func updateFields(fields []string, updatedUser model.User) ([]string, error) {
+	if len(fields) == 0 {
+		fields = []string{model.FieldName}
+		if updatedUser.PostalAddress.IsZero() {
+			fields = append(fields, model.FieldAddress)
+		} else {
+			fields = append(fields, model.FieldPostalAddress)
+		}
+		if len(updatedUser.Phones) > 0 {
+			fields = append(fields, model.FieldPhones)
+		} else {
+			fields = append(fields, model.FieldPhone)
+		}
+		if len(updatedUser.Emails) > 0 {
+			fields = append(fields, model.FieldEmails)
+		}
+		if len(updatedUser.Attributes) > 0 {
+			fields = append(fields, model.FieldAttributes)
+		}
+	}
+	for _, field := range fields {
+		if !isUpdatable(field) {
+			return nil, Invalid(ErrUnknownField, field)
+		}
+	}
+	return fields, nil
+}
+
+// prepareUpdate resolves the fields of an update and normalizes and validates
+// the phone numbers, emails and attributes that are going to be written.
+func (abs *AddressBookService) prepareUpdate(updatedUser model.User, fields []string) (model.User, []string, error) {
+	fields, err := updateFields(fields, updatedUser)
+	if err != nil {
+		return model.User{}, nil, err
+	}
+	if leavesNoPhone(updatedUser, fields) {
+		return model.User{}, nil, Invalid(ErrNoPhoneLeft)
+	}
+	if err := abs.normalizePhones(&updatedUser, fields); err != nil {
+		return model.User{}, nil, err
+	}
+	if err := validateContacts(updatedUser); err != nil {
+		return model.User{}, nil, err
+	}
+	if err := validateAttributes(updatedUser.Attributes); err != nil {
+		return model.User{}, nil, err
+	}
+	return updatedUser, fields, nil
+}
+
+// leavesNoPhone reports whether writing the fields of u clears the phone
+// numbers: the phones without a number and the legacy phone empty, as far
+// as the update writes them. Storages index the primary number, so every
+// live user needs one.
+func leavesNoPhone(u model.User, fields []string) bool {
+	writesPhone, writesPhones := contains(fields, model.FieldPhone), contains(fields, model.FieldPhones)
+	if !writesPhone && !writesPhones {
+		return false
+	}
+	if writesPhone && u.Phone != "" {
+		return false
+	}
+	if writesPhones {
+		for _, p := range u.Phones {
+			if p.Number != "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// normalizePhones canonicalizes the numbers of u that belong to the given fields.
+func (abs *AddressBookService) normalizePhones(u *model.User, fields []string) error {
+	if contains(fields, model.FieldPhone) && u.Phone != "" {
+		number, err := abs.phones.Normalize(u.Phone)
+		if err != nil {
+			return Invalid(ErrInvalidPhone, u.Phone)
+		}
+		u.Phone, u.PhoneDisplay = number.E164, number.Display
+	}
+	if !contains(fields, model.FieldPhones) || len(u.Phones) == 0 {
+		return nil
+	}
+	phones := make([]model.PhoneNumber, len(u.Phones))
+	for i, p := range u.Phones {
+		if p.Number != "" {
+			number, err := abs.phones.Normalize(p.Number)
+			if err != nil {
+				return Invalid(ErrInvalidPhone, p.Number)
+			}
+			p.Number, p.Display = number.E164, number.Display
+		}
+		phones[i] = p
+	}
+	u.Phones = phones
 	return nil
 }
+
+// validateContacts checks the phone numbers and emails of a user before they
+// are normalized.
+func validateContacts(u model.User) error {
+	seen := make(map[string]bool, len(u.Phones))
+	var primary int
+	for _, p := range u.Phones {
+		if p.Type != "" && !contains(model.PhoneTypes, p.Type) {
+			return Invalid(ErrUnknownPhoneType, p.Type)
+		}
+		if seen[p.Number] {
+			return Invalid(ErrRepeatedPhone, p.Number)
+		}
+		seen[p.Number] = true
+		if p.Primary {
+			primary++
+		}
+	}
+	if primary > 1 {
+		return Invalid(ErrSeveralPrimaryPhones)
+	}
+
+	primary = 0
+	for _, e := range u.Emails {
+		if e.Type != "" && !contains(model.EmailTypes, e.Type) {
+			return Invalid(ErrUnknownEmailType, e.Type)
+		}
+		if e.Primary {
+			primary++
+		}
+	}
+	if primary > 1 {
+		return Invalid(ErrSeveralPrimaryEmails)
+	}
+	return nil
+}
+
+// numbers lists the phone numbers of a user for error messages.
+func numbers(u model.User) string {
+	if len(u.Phones) == 0 {
+		return u.Phone
+	}
+	list := make([]string, 0, len(u.Phones))
+	for _, p := range u.Phones {
+		list = append(list, p.Number)
+	}
+	return strings.Join(list, ", ")
+}
+
+func updateError(err, notFound error, updatedUser model.User) error {
+	if errors.Is(err, model.ErrNotFound) {
+		return notFound
+	}
+	if errors.Is(err, model.ErrDuplicatePhone) {
+		return Conflict(ErrPhoneIsTaken, numbers(updatedUser))
+	}
+	if err != nil {
+		return Internal(err)
+	}
+	return nil
+}
+
+// pattern turns an optional filter into a LIKE pattern matching everything when empty.
+func pattern(s string) string {
+	if s == "" {
+		return "%"
+	}
+	return wildcards(s)
+}
+
+func wildcards(s string) string {
+	return strings.ReplaceAll(s, "*", "%")
+}
+
+// isPhonePattern reports whether a normalized pattern looks like a phone
+// number, i.e. has digits and at most a leading plus besides them.
+func isPhonePattern(s string) bool {
+	s = strings.TrimPrefix(s, "+")
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isUpdatable(field string) bool {
+	return contains(model.UpdatableFields, field)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
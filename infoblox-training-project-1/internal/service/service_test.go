@@ -3,21 +3,29 @@ package service_test
 import (
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
+	"time"
 
+	testifymock "github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/gorm"
 
 	"github.com/vstarostin/infoblox-training-project-1/internal/mock"
 	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+	"github.com/vstarostin/infoblox-training-project-1/internal/phonenumber"
 	"github.com/vstarostin/infoblox-training-project-1/internal/service"
 )
 
 var (
-	name, phone, address = "name", "phone", "address"
-	user                 = model.User{Name: name, Phone: phone, Address: address}
+	name, phone, address = "name", "+78129878899", "address"
+	phoneDisplay         = "+7 812 987-88-99"
+	user                 = model.User{Name: name, Phone: phone, PhoneDisplay: phoneDisplay, Address: address}
 	users                = []model.User{user}
 	emptyUsers           = []model.User{}
+	storageErr           = errors.New("some error")
+	defaultFields        = []string{model.FieldName, model.FieldAddress, model.FieldPhone}
 )
 
 type serviceTestSuite struct {
@@ -28,7 +36,9 @@ type serviceTestSuite struct {
 
 func (suite *serviceTestSuite) SetupTest() {
 	storage := &mock.AddressBookStorage{}
-	s := service.New(storage)
+	phones, err := phonenumber.NewNormalizer("RU")
+	suite.Require().NoError(err)
+	s := service.New(storage, phones)
 	suite.storage = storage
 	suite.service = s
 }
@@ -39,140 +49,1397 @@ func TestService(t *testing.T) {
 
 func (suite *serviceTestSuite) TestServiceAddUser() {
 	tests := map[string]struct {
-		storageResponse *gorm.DB
-		expectedResult  error
+		storageErr     error
+		expectedResult error
 	}{
 		"without_error": {
-			storageResponse: &gorm.DB{},
-			expectedResult:  nil,
+			storageErr:     nil,
+			expectedResult: nil,
+		},
+		"duplicate_phone": {
+			storageErr:     model.ErrDuplicatePhone,
+			expectedResult: service.Conflict(service.ErrUserAlreadyExist, phone),
 		},
 		"error": {
-			storageResponse: &gorm.DB{Error: errors.New("some error")},
-			expectedResult:  fmt.Errorf(service.ErrUserAlreadyExist, phone),
+			storageErr:     storageErr,
+			expectedResult: service.Internal(storageErr),
 		},
 	}
 
+	normalized := user
+	normalized.NameKey, normalized.AddressKey = name, address
+	normalized.Phones = []model.PhoneNumber{{Number: phone, Display: phoneDisplay, Type: model.PhoneMobile, Primary: true}}
 	for name, test := range tests {
 		suite.Run(name, func() {
-			suite.storage.On("Store", user).Once().Return(test.storageResponse)
-			gotResult := suite.service.AddUser(user.Name, user.Phone, user.Address)
+			suite.storage.On("Store", normalized).Once().Return(normalized, test.storageErr)
+			_, gotResult := suite.service.AddUser(user)
 			suite.Equal(test.expectedResult, gotResult)
 		})
 	}
 }
 
+func (suite *serviceTestSuite) TestServiceAddUserContacts() {
+	newUser := model.User{
+		Name: name,
+		Phones: []model.PhoneNumber{
+			{Number: "8-812-111-11-11", Type: model.PhoneWork},
+			{Number: "8 812 222 22 22", Primary: true},
+		},
+		Emails: []model.Email{{Address: "name@example.com"}},
+	}
+	stored := model.User{
+		Name:         name,
+		NameKey:      name,
+		Phone:        "+78122222222",
+		PhoneDisplay: "+7 812 222-22-22",
+		Phones: []model.PhoneNumber{
+			{Number: "+78121111111", Display: "+7 812 111-11-11", Type: model.PhoneWork},
+			{Number: "+78122222222", Display: "+7 812 222-22-22", Type: model.PhoneMobile, Primary: true},
+		},
+		Emails: []model.Email{{Address: "name@example.com", Type: model.EmailHome}},
+	}
+	suite.storage.On("Store", stored).Once().Return(stored, nil)
+	gotResult, err := suite.service.AddUser(newUser)
+	suite.NoError(err)
+	suite.Equal(stored, gotResult)
+
+	suite.storage.On("Store", stored).Once().Return(model.User{}, model.ErrDuplicatePhone)
+	_, err = suite.service.AddUser(newUser)
+	suite.Equal(service.Conflict(service.ErrUserAlreadyExist, "+78121111111, +78122222222"), err)
+}
+
+func (suite *serviceTestSuite) TestServiceAddUserInvalidContacts() {
+	tooManyAttributes := model.Attributes{}
+	for i := 0; i <= service.MaxAttributes; i++ {
+		tooManyAttributes[fmt.Sprintf("key%d", i)] = "value"
+	}
+	tests := map[string]struct {
+		user        model.User
+		expectedErr error
+	}{
+		"unknown_phone_type": {
+			user:        model.User{Phones: []model.PhoneNumber{{Number: phone, Type: "fax"}}},
+			expectedErr: service.Invalid(service.ErrUnknownPhoneType, "fax"),
+		},
+		"invalid_phone": {
+			user:        model.User{Phone: "123"},
+			expectedErr: service.Invalid(service.ErrInvalidPhone, "123"),
+		},
+		"repeated_phone": {
+			user:        model.User{Phones: []model.PhoneNumber{{Number: phone}, {Number: "8-812-987-88-99"}}},
+			expectedErr: service.Invalid(service.ErrRepeatedPhone, phone),
+		},
+		"several_primary_phones": {
+			user:        model.User{Phones: []model.PhoneNumber{{Number: phone, Primary: true}, {Number: "+78121111111", Primary: true}}},
+			expectedErr: service.Invalid(service.ErrSeveralPrimaryPhones),
+		},
+		"unknown_email_type": {
+			user:        model.User{Emails: []model.Email{{Address: "a@b", Type: "school"}}},
+			expectedErr: service.Invalid(service.ErrUnknownEmailType, "school"),
+		},
+		"several_primary_emails": {
+			user:        model.User{Emails: []model.Email{{Address: "a@b", Primary: true}, {Address: "c@d", Primary: true}}},
+			expectedErr: service.Invalid(service.ErrSeveralPrimaryEmails),
+		},
+		"too_many_attributes": {
+			user:        model.User{Attributes: tooManyAttributes},
+			expectedErr: service.Invalid(service.ErrTooManyAttributes, service.MaxAttributes),
+		},
+		"invalid_attribute_key": {
+			user:        model.User{Attributes: model.Attributes{"customer id": "42"}},
+			expectedErr: service.Invalid(service.ErrInvalidAttributeKey, "customer id", service.MaxAttributeKeyLength),
+		},
+		"long_attribute_key": {
+			user:        model.User{Attributes: model.Attributes{strings.Repeat("k", service.MaxAttributeKeyLength+1): "42"}},
+			expectedErr: service.Invalid(service.ErrInvalidAttributeKey, strings.Repeat("k", service.MaxAttributeKeyLength+1), service.MaxAttributeKeyLength),
+		},
+		"long_attribute_value": {
+			user:        model.User{Attributes: model.Attributes{"notes": strings.Repeat("ü", service.MaxAttributeValueLength+1)}},
+			expectedErr: service.Invalid(service.ErrAttributeValueTooLong, "notes", service.MaxAttributeValueLength),
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			_, err := suite.service.AddUser(test.user)
+			suite.Equal(test.expectedErr, err)
+		})
+	}
+	suite.storage.AssertNotCalled(suite.T(), "Store")
+}
+
 func (suite *serviceTestSuite) TestServiceListUsers() {
 	name, address, phone := "%", "%", "%"
-	user := model.User{Name: name, Phone: phone, Address: address}
+	query := model.Query{Filter: model.User{Name: name, Phone: phone, Address: address}, Limit: service.DefaultPageSize + 1}
 	tests := map[string]struct {
-		storageResponse, expectedResult []model.User
+		storageResponse []model.User
+		storageErr      error
+		expectedResult  service.Page
+		expectedErr     error
 	}{
 		"without_error": {
 			storageResponse: users,
-			expectedResult:  users,
+			expectedResult:  service.Page{Users: users, TotalSize: 1},
 		},
-		"error": {
+		"empty": {
 			storageResponse: emptyUsers,
-			expectedResult:  emptyUsers,
+			expectedResult:  service.Page{Users: emptyUsers, TotalSize: 0},
+		},
+		"storage_error": {
+			storageErr:     storageErr,
+			expectedResult: service.Page{},
+			expectedErr:    service.Internal(storageErr),
 		},
 	}
 
 	for caseName, test := range tests {
 		suite.Run(caseName, func() {
-			suite.storage.On("Load", user).Once().Return(test.storageResponse)
-			gotResult, _ := suite.service.ListUsers()
+			suite.storage.On("Load", query).Once().Return(test.storageResponse, int64(len(test.storageResponse)), test.storageErr)
+			gotResult, err := suite.service.ListUsers(service.ListOptions{})
 			suite.Equal(test.expectedResult, gotResult)
+			suite.Equal(test.expectedErr, err)
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceListUsersPagination() {
+	filter := model.User{Name: "%", Phone: "%", Address: "%"}
+	page := []model.User{
+		{Model: gorm.Model{ID: 1}, Name: "first"},
+		{Model: gorm.Model{ID: 2}, Name: "second"},
+		{Model: gorm.Model{ID: 3}, Name: "third"},
+	}
+
+	suite.storage.On("Load", model.Query{Filter: filter, Limit: 3}).Once().Return(page, int64(5), nil)
+	first, err := suite.service.ListUsers(service.ListOptions{PageSize: 2})
+	suite.NoError(err)
+	suite.Equal(page[:2], first.Users)
+	suite.Equal(int64(5), first.TotalSize)
+	suite.NotEmpty(first.NextPageToken)
+
+	suite.storage.On("Load", model.Query{Filter: filter, AfterID: 2, Limit: 3}).Once().Return(page[2:], int64(5), nil)
+	second, err := suite.service.ListUsers(service.ListOptions{PageSize: 2, PageToken: first.NextPageToken})
+	suite.NoError(err)
+	suite.Equal(page[2:], second.Users)
+	suite.Empty(second.NextPageToken)
+}
+
+func (suite *serviceTestSuite) TestServiceListUsersOrdered() {
+	filter := model.User{Name: "%", Phone: "%", Address: "%"}
+	created := time.Date(2022, 1, 2, 3, 4, 5, 6000, time.UTC)
+	page := []model.User{
+		{Model: gorm.Model{ID: 7, CreatedAt: created}, Name: "Zed", NameKey: "zed"},
+		{Model: gorm.Model{ID: 3, CreatedAt: created}, Name: "Ann", NameKey: "ann"},
+	}
+	order := []model.Order{{Field: model.FieldName, Desc: true}, {Field: model.FieldCreatedAt}}
+	opts := service.ListOptions{PageSize: 1, OrderBy: "userName DESC, created_at"}
+
+	suite.storage.On("Load", model.Query{Filter: filter, OrderBy: order, Limit: 2}).Once().Return(page, int64(2), nil)
+	first, err := suite.service.ListUsers(opts)
+	suite.NoError(err)
+	suite.Equal(page[:1], first.Users)
+	suite.NotEmpty(first.NextPageToken)
+
+	after := model.User{NameKey: "zed", Model: gorm.Model{CreatedAt: created}}
+	suite.storage.On("Load", model.Query{Filter: filter, OrderBy: order, AfterID: 7, After: after, Limit: 2}).Once().Return(page[1:], int64(2), nil)
+	opts.PageToken = first.NextPageToken
+	second, err := suite.service.ListUsers(opts)
+	suite.NoError(err)
+	suite.Equal(page[1:], second.Users)
+
+	opts.OrderBy = "name"
+	_, err = suite.service.ListUsers(opts)
+	suite.Equal(service.Invalid(service.ErrPageTokenOrder), err)
+}
+
+func (suite *serviceTestSuite) TestServiceListUsersInvalidOptions() {
+	tests := map[string]struct {
+		opts        service.ListOptions
+		expectedErr error
+	}{
+		"negative_page_size": {
+			opts:        service.ListOptions{PageSize: -1},
+			expectedErr: service.Invalid(service.ErrNegativePageSize),
+		},
+		"malformed_token": {
+			opts:        service.ListOptions{PageToken: "not a token"},
+			expectedErr: service.Invalid(service.ErrInvalidPageToken),
+		},
+		"unknown_order_field": {
+			opts:        service.ListOptions{OrderBy: "name, age desc"},
+			expectedErr: service.Invalid(service.ErrInvalidOrderBy, `cannot sort by "age"`),
+		},
+		"unknown_order_direction": {
+			opts:        service.ListOptions{OrderBy: "name up"},
+			expectedErr: service.Invalid(service.ErrInvalidOrderBy, `unknown direction "up", use asc or desc`),
+		},
+		"repeated_order_field": {
+			opts:        service.ListOptions{OrderBy: "name, userName desc"},
+			expectedErr: service.Invalid(service.ErrInvalidOrderBy, `"userName" is repeated`),
+		},
+		"empty_order_term": {
+			opts:        service.ListOptions{OrderBy: "name,,phone"},
+			expectedErr: service.Invalid(service.ErrInvalidOrderBy, `malformed term ""`),
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			_, err := suite.service.ListUsers(test.opts)
+			suite.Equal(test.expectedErr, err)
 		})
 	}
 }
 
 func (suite *serviceTestSuite) TestServiceFindUser() {
+	filter := model.User{Name: name, Phone: phone, Address: address}
+	query := model.Query{Filter: filter, Limit: service.DefaultPageSize + 1}
 	tests := map[string]struct {
-		users, storageResponse, expectedResult []model.User
-		expectedErr                            error
+		storageResponse []model.User
+		expectedResult  service.Page
+		storageErr      error
+		expectedErr     error
 	}{
 		"without_error": {
-			users:           users,
 			storageResponse: users,
-			expectedResult:  users,
+			expectedResult:  service.Page{Users: users, TotalSize: 1},
 			expectedErr:     nil,
 		},
 		"error": {
-			users:           emptyUsers,
 			storageResponse: emptyUsers,
-			expectedResult:  emptyUsers,
-			expectedErr:     fmt.Errorf(service.ErrUserDoesNotExist),
+			expectedResult:  service.Page{},
+			expectedErr:     service.NotFound(service.ErrUserDoesNotExist),
+		},
+		"storage_error": {
+			storageResponse: nil,
+			storageErr:      storageErr,
+			expectedResult:  service.Page{},
+			expectedErr:     service.Internal(storageErr),
 		},
 	}
 	for caseName, test := range tests {
 		suite.Run(caseName, func() {
-			suite.storage.On("Load", user).Once().Return(test.users)
-			gotResult, err := suite.service.FindUser(name, phone, address)
+			suite.storage.On("Load", query).Once().Return(test.storageResponse, int64(len(test.storageResponse)), test.storageErr)
+			gotResult, err := suite.service.FindUser(model.User{Name: name, Phone: "8 (812) 987-88-99", Address: address}, "", service.ListOptions{})
 			suite.Equal(test.expectedResult, gotResult)
 			suite.Equal(test.expectedErr, err)
 		})
 	}
 }
 
+func (suite *serviceTestSuite) TestServiceAddUserKeepsCasing() {
+	newUser := model.User{Name: "John McDonald", Phone: phone, Address: "New  York"}
+	stored := newUser
+	stored.NameKey, stored.AddressKey, stored.PhoneDisplay = "john mcdonald", "new york", phoneDisplay
+	stored.Phones = []model.PhoneNumber{{Number: phone, Display: phoneDisplay, Type: model.PhoneMobile, Primary: true}}
+	suite.storage.On("Store", stored).Once().Return(stored, nil)
+
+	gotResult, err := suite.service.AddUser(newUser)
+	suite.NoError(err)
+	suite.Equal("John McDonald", gotResult.Name)
+	suite.Equal("New  York", gotResult.Address)
+}
+
+func (suite *serviceTestSuite) TestServiceFindUserIgnoresCase() {
+	filter := model.User{Name: "john mc%", Phone: "%", Address: "%strasse%"}
+	query := model.Query{Filter: filter, Limit: service.DefaultPageSize + 1}
+	suite.storage.On("Load", query).Once().Return(users, int64(1), nil)
+
+	_, err := suite.service.FindUser(model.User{Name: " John Mc*", Address: "*Straße*"}, "", service.ListOptions{})
+	suite.NoError(err)
+}
+
+func (suite *serviceTestSuite) TestServiceFindUserByAddress() {
+	filter := model.User{
+		Name:          "%",
+		Phone:         "%",
+		Address:       "%",
+		PostalAddress: model.PostalAddress{City: "new%", CountryCode: "US"},
+	}
+	query := model.Query{Filter: filter, Limit: service.DefaultPageSize + 1}
+	suite.storage.On("Load", query).Once().Return(users, int64(1), nil)
+
+	gotResult, err := suite.service.FindUser(model.User{PostalAddress: model.PostalAddress{City: "new*", CountryCode: "US"}}, "", service.ListOptions{})
+	suite.NoError(err)
+	suite.Equal(users, gotResult.Users)
+}
+
+func (suite *serviceTestSuite) TestServiceFindUserFilter() {
+	all := model.User{Name: "%", Phone: "%", Address: "%"}
+	tests := map[string]struct {
+		filter       string
+		expectedExpr model.Expr
+	}{
+		"term": {
+			filter:       "name:Jo*",
+			expectedExpr: model.Expr{Kind: model.ExprMatch, Field: model.FieldName, Pattern: "jo%"},
+		},
+		"precedence": {
+			filter: `name:jo* AND (address:"New York" OR phone:+1*) city:moscow`,
+			expectedExpr: model.Expr{Kind: model.ExprAnd, Operands: []model.Expr{
+				{Kind: model.ExprMatch, Field: model.FieldName, Pattern: "jo%"},
+				{Kind: model.ExprOr, Operands: []model.Expr{
+					{Kind: model.ExprMatch, Field: model.FieldAddress, Pattern: "new york"},
+					{Kind: model.ExprMatch, Field: model.FieldPhone, Pattern: "+1%"},
+				}},
+				{Kind: model.ExprMatch, Field: model.FieldCity, Pattern: "moscow"},
+			}},
+		},
+		"or_binds_looser_than_and": {
+			filter: "email:* OR NOT street:* AND countryCode:us",
+			expectedExpr: model.Expr{Kind: model.ExprOr, Operands: []model.Expr{
+				{Kind: model.ExprExists, Field: model.FieldEmail},
+				{Kind: model.ExprAnd, Operands: []model.Expr{
+					{Kind: model.ExprNot, Operands: []model.Expr{{Kind: model.ExprExists, Field: model.FieldStreet}}},
+					{Kind: model.ExprMatch, Field: model.FieldCountryCode, Pattern: "us"},
+				}},
+			}},
+		},
+		"phone_normalized": {
+			filter:       `phone:"8 (812) 987-88-99"`,
+			expectedExpr: model.Expr{Kind: model.ExprMatch, Field: model.FieldPhone, Pattern: phone},
+		},
+		"like_characters_escaped": {
+			filter:       `email:"100%_off*"`,
+			expectedExpr: model.Expr{Kind: model.ExprMatch, Field: model.FieldEmail, Pattern: `100\%\_off%`},
+		},
+		"attributes": {
+			filter: "attributes.customerId:C-4* attributes.account.manager:*",
+			expectedExpr: model.Expr{Kind: model.ExprAnd, Operands: []model.Expr{
+				{Kind: model.ExprMatch, Field: model.FieldAttribute, Key: "customerId", Pattern: "C-4%"},
+				{Kind: model.ExprExists, Field: model.FieldAttribute, Key: "account.manager"},
+			}},
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			expr := test.expectedExpr
+			query := model.Query{Filter: all, Expr: &expr, Limit: service.DefaultPageSize + 1}
+			suite.storage.On("Load", query).Once().Return(users, int64(1), nil)
+
+			_, err := suite.service.FindUser(model.User{}, test.filter, service.ListOptions{})
+			suite.NoError(err)
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceFindUserInvalidFilter() {
+	tests := map[string]struct {
+		filter      string
+		expectedErr string
+	}{
+		"unknown_field":      {filter: "age:3", expectedErr: `unknown field "age" at position 1`},
+		"missing_colon":      {filter: "name jo", expectedErr: `expected : after field "name" at position 1, got "jo" at position 6`},
+		"missing_value":      {filter: "name:", expectedErr: `expected value for field "name", got end of filter`},
+		"dangling_operator":  {filter: "name:jo AND", expectedErr: "expected field:value, got end of filter"},
+		"unbalanced":         {filter: "(name:jo", expectedErr: "missing ) for ( at position 1, got end of filter"},
+		"extra_parenthesis":  {filter: "name:jo)", expectedErr: `unexpected ")" at position 8`},
+		"unterminated":       {filter: `city:"new york`, expectedErr: "unterminated string starting at position 6"},
+		"too_deep":           {filter: strings.Repeat("NOT ", service.MaxFilterDepth+1) + "name:jo", expectedErr: fmt.Sprintf("nested deeper than %d levels", service.MaxFilterDepth)},
+		"keyword_as_operand": {filter: "NOT OR", expectedErr: `expected field:value, got "OR" at position 5`},
+		"empty_attribute":    {filter: "name:jo attributes.:42", expectedErr: `invalid attribute key "" at position 9`},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			_, err := suite.service.FindUser(model.User{}, test.filter, service.ListOptions{})
+			suite.Equal(service.Invalid(service.ErrInvalidFilter, errors.New(test.expectedErr)), err)
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceFindUserAttributes() {
+	expr := model.Expr{Kind: model.ExprAnd, Operands: []model.Expr{
+		{Kind: model.ExprMatch, Field: model.FieldName, Pattern: "jo%"},
+		{Kind: model.ExprExists, Field: model.FieldAttribute, Key: "manager"},
+		{Kind: model.ExprMatch, Field: model.FieldAttribute, Key: "tier", Pattern: `gold\_%`},
+	}}
+	query := model.Query{Filter: model.User{Name: "%", Phone: "%", Address: "%"}, Expr: &expr, Limit: service.DefaultPageSize + 1}
+	suite.storage.On("Load", query).Once().Return(users, int64(1), nil)
+
+	filter := model.User{Attributes: model.Attributes{"tier": "gold_*", "manager": "*"}}
+	_, err := suite.service.FindUser(filter, "name:jo*", service.ListOptions{})
+	suite.NoError(err)
+
+	_, err = suite.service.FindUser(model.User{Attributes: model.Attributes{"a b": "*"}}, "", service.ListOptions{})
+	suite.Equal(service.Invalid(service.ErrInvalidAttributeKey, "a b", service.MaxAttributeKeyLength), err)
+}
+
+func (suite *serviceTestSuite) TestServiceSearchUsers() {
+	hits := []model.SearchHit{{User: user, Score: 0.5}}
+	tests := map[string]struct {
+		query          string
+		limit          int32
+		storageQuery   model.SearchQuery
+		storageResult  []model.SearchHit
+		storageErr     error
+		expectedResult []model.SearchHit
+		expectedErr    error
+	}{
+		"text": {
+			query:          " Jhon ",
+			storageQuery:   model.SearchQuery{Text: "jhon", MinScore: service.MinSearchScore, Limit: service.DefaultPageSize},
+			storageResult:  hits,
+			expectedResult: hits,
+		},
+		"phone": {
+			query:          "8 (812) 987-88-99",
+			limit:          service.MaxPageSize + 1,
+			storageQuery:   model.SearchQuery{Text: "8 (812) 987-88-99", Phone: phone, MinScore: service.MinSearchScore, Limit: service.MaxPageSize},
+			storageResult:  hits,
+			expectedResult: hits,
+		},
+		"not_found": {
+			query:         "zzz",
+			storageQuery:  model.SearchQuery{Text: "zzz", MinScore: service.MinSearchScore, Limit: service.DefaultPageSize},
+			storageResult: []model.SearchHit{},
+			expectedErr:   service.NotFound(service.ErrUserDoesNotExist),
+		},
+		"storage_error": {
+			query:        "zzz",
+			storageQuery: model.SearchQuery{Text: "zzz", MinScore: service.MinSearchScore, Limit: service.DefaultPageSize},
+			storageErr:   storageErr,
+			expectedErr:  service.Internal(storageErr),
+		},
+		"empty_query": {
+			query:       "  ",
+			expectedErr: service.Invalid(service.ErrEmptySearchQuery),
+		},
+		"negative_limit": {
+			query:       "john",
+			limit:       -1,
+			expectedErr: service.Invalid(service.ErrNegativePageSize),
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			if test.storageResult != nil || test.storageErr != nil {
+				suite.storage.On("Search", test.storageQuery).Once().Return(test.storageResult, test.storageErr)
+			}
+			gotResult, err := suite.service.SearchUsers(test.query, test.limit)
+			suite.Equal(test.expectedResult, gotResult)
+			suite.Equal(test.expectedErr, err)
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceExportUsers() {
+	expr := model.Expr{Kind: model.ExprMatch, Field: model.FieldName, Pattern: "jo%"}
+	query := model.Query{Filter: model.User{Name: "%", Phone: "%", Address: "%"}, Expr: &expr}
+	sendErr := errors.New("client went away")
+	tests := map[string]struct {
+		sendErr       error
+		storageErr    error
+		expectedUsers []model.User
+		expectedErr   error
+	}{
+		"without_error": {
+			expectedUsers: []model.User{user, user},
+		},
+		"send_error": {
+			sendErr:       sendErr,
+			storageErr:    sendErr,
+			expectedUsers: []model.User{user},
+			expectedErr:   sendErr,
+		},
+		"storage_error": {
+			storageErr:    storageErr,
+			expectedUsers: []model.User{user, user},
+			expectedErr:   service.Internal(storageErr),
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			suite.storage.On("Export", query, testifymock.Anything).Once().Run(func(args testifymock.Arguments) {
+				send := args.Get(1).(func(model.User) error)
+				for i := 0; i < 2; i++ {
+					if send(user) != nil {
+						return
+					}
+				}
+			}).Return(test.storageErr)
+
+			var got []model.User
+			err := suite.service.ExportUsers("name:jo*", func(u model.User) error {
+				got = append(got, u)
+				return test.sendErr
+			})
+			suite.Equal(test.expectedErr, err)
+			suite.Equal(test.expectedUsers, got)
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceExportUsersInvalidFilter() {
+	err := suite.service.ExportUsers("name:", func(model.User) error { return nil })
+	suite.Equal(service.Invalid(service.ErrInvalidFilter, errors.New(`expected value for field "name", got end of filter`)), err)
+}
+
+func (suite *serviceTestSuite) TestServiceImportUsers() {
+	normalized := user
+	normalized.Normalize()
+	rejected := errors.New("user.userName: must not be empty")
+	rows := []service.ImportRow{
+		{User: model.User{Name: name, Phone: "8 (812) 987-88-99", Address: address}},
+		{User: model.User{Name: name, Phone: "not a phone"}},
+		{Err: rejected},
+	}
+	tests := map[string]struct {
+		onConflict     string
+		storageMode    string
+		storageResults []model.ImportResult
+		storageErr     error
+		expectedFirst  service.ImportRowResult
+		expectedFailed int
+	}{
+		"created": {
+			storageMode:    model.OnConflictFail,
+			storageResults: []model.ImportResult{{Status: model.ImportCreated, User: model.User{Model: gorm.Model{ID: 7}}}},
+			expectedFirst:  service.ImportRowResult{Row: 0, Status: model.ImportCreated, ID: 7},
+			expectedFailed: 2,
+		},
+		"skipped": {
+			onConflict:     model.OnConflictSkip,
+			storageMode:    model.OnConflictSkip,
+			storageResults: []model.ImportResult{{Status: model.ImportSkipped, Err: model.ErrDuplicatePhone}},
+			expectedFirst:  service.ImportRowResult{Row: 0, Status: model.ImportSkipped, Reason: fmt.Sprintf(service.ErrUserAlreadyExist, phone)},
+			expectedFailed: 2,
+		},
+		"several_owners": {
+			onConflict:     model.OnConflictOverwrite,
+			storageMode:    model.OnConflictOverwrite,
+			storageResults: []model.ImportResult{{Status: model.ImportFailed, Err: model.ErrSeveralOwners}},
+			expectedFirst:  service.ImportRowResult{Row: 0, Status: model.ImportFailed, Reason: fmt.Sprintf(service.ErrPhonesOfSeveralUsers, phone)},
+			expectedFailed: 3,
+		},
+		"storage_error": {
+			storageMode:    model.OnConflictFail,
+			storageErr:     storageErr,
+			expectedFirst:  service.ImportRowResult{Row: 0, Status: model.ImportFailed, Reason: service.ErrInternal},
+			expectedFailed: 3,
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			suite.storage.On("Import", []model.User{normalized}, test.storageMode).Once().Return(test.storageResults, test.storageErr)
+			i := 0
+			report, err := suite.service.ImportUsers(test.onConflict, func() (service.ImportRow, error) {
+				if i == len(rows) {
+					return service.ImportRow{}, io.EOF
+				}
+				i++
+				return rows[i-1], nil
+			})
+			suite.NoError(err)
+			suite.Equal([]service.ImportRowResult{
+				test.expectedFirst,
+				{Row: 1, Status: model.ImportFailed, Reason: fmt.Sprintf(service.ErrInvalidPhone, "not a phone")},
+				{Row: 2, Status: model.ImportFailed, Reason: rejected.Error()},
+			}, report.Rows)
+			suite.Equal(test.expectedFailed, report.Failed)
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceImportUsersErrors() {
+	_, err := suite.service.ImportUsers("merge", nil)
+	suite.Equal(service.Invalid(service.ErrUnknownOnConflict, "merge"), err)
+
+	_, err = suite.service.ImportUsers("", func() (service.ImportRow, error) {
+		return service.ImportRow{}, storageErr
+	})
+	suite.Equal(storageErr, err)
+}
+
 func (suite *serviceTestSuite) TestServiceDeleteUser() {
+	twoUsers := []model.User{user, {Name: name, Phone: "other phone", Address: address}}
 	tests := map[string]struct {
-		storageResponse *gorm.DB
-		expectedResult  string
-		expectedErr     error
+		opts           service.DeleteOptions
+		expected       int64
+		deleteResponse []model.User
+		deleteErr      error
+		expectedResult service.DeleteResult
+		expectedErr    error
 	}{
 		"without_error": {
-			storageResponse: &gorm.DB{RowsAffected: 1},
-			expectedResult:  fmt.Sprintf(service.DeleteUserMethodResponse, 1),
-			expectedErr:     nil,
+			expected:       1,
+			deleteResponse: users,
+			expectedResult: service.DeleteResult{Response: fmt.Sprintf(service.DeleteUserMethodResponse, 1), Users: users, Deleted: 1},
 		},
-		"error": {
-			storageResponse: &gorm.DB{RowsAffected: 0},
-			expectedResult:  "",
-			expectedErr:     fmt.Errorf(service.ErrNoSuchUserWithName, name),
+		"not_found": {
+			expected:    1,
+			deleteErr:   model.ErrNotFound,
+			expectedErr: service.NotFound(service.ErrNoSuchUserWithName, name),
+		},
+		"storage_error": {
+			expected:    1,
+			deleteErr:   storageErr,
+			expectedErr: service.Internal(storageErr),
+		},
+		"bulk_not_allowed": {
+			expected:       1,
+			deleteResponse: twoUsers,
+			deleteErr:      model.ErrCountMismatch,
+			expectedErr:    service.FailedPrecondition(service.ErrBulkDeleteNotAllowed, 2, name),
+		},
+		"bulk_allowed": {
+			opts:           service.DeleteOptions{AllowBulk: true},
+			deleteResponse: twoUsers,
+			expectedResult: service.DeleteResult{Response: fmt.Sprintf(service.DeleteUserMethodResponse, 2), Users: twoUsers, Deleted: 2},
+		},
+		"expected_count": {
+			opts:           service.DeleteOptions{ExpectedCount: 2},
+			expected:       2,
+			deleteResponse: twoUsers,
+			expectedResult: service.DeleteResult{Response: fmt.Sprintf(service.DeleteUserMethodResponse, 2), Users: twoUsers, Deleted: 2},
+		},
+		"unexpected_count": {
+			opts:           service.DeleteOptions{AllowBulk: true, ExpectedCount: 3},
+			expected:       3,
+			deleteResponse: twoUsers,
+			deleteErr:      model.ErrCountMismatch,
+			expectedErr:    service.FailedPrecondition(service.ErrUnexpectedCount, 2, name, 3),
 		},
 	}
 	for caseName, test := range tests {
 		suite.Run(caseName, func() {
-			suite.storage.On("Delete", name).Once().Return(test.storageResponse)
-			gotResult, err := suite.service.DeleteUser(name)
+			suite.SetupTest()
+			suite.storage.On("Delete", name, test.expected).Once().Return(test.deleteResponse, test.deleteErr)
+			gotResult, err := suite.service.DeleteUser(name, test.opts)
 			suite.Equal(test.expectedResult, gotResult)
 			suite.Equal(test.expectedErr, err)
+			suite.storage.AssertExpectations(suite.T())
 		})
 	}
 }
 
+func (suite *serviceTestSuite) TestServiceDeleteUserDryRun() {
+	query := model.Query{Filter: model.User{Name: name, Phone: "%", Address: "%"}, Limit: service.MaxPageSize}
+	twoUsers := []model.User{user, {Name: name, Phone: "other phone", Address: address}}
+	suite.storage.On("Load", query).Once().Return(twoUsers, int64(2), nil)
+	gotResult, err := suite.service.DeleteUser(name, service.DeleteOptions{DryRun: true})
+	suite.NoError(err)
+	suite.Equal(service.DeleteResult{Response: fmt.Sprintf(service.DeleteUserDryRunResponse, 2), Users: twoUsers}, gotResult)
+
+	suite.storage.On("Load", query).Once().Return(emptyUsers, int64(0), nil)
+	_, err = suite.service.DeleteUser(name, service.DeleteOptions{DryRun: true})
+	suite.Equal(service.NotFound(service.ErrNoSuchUserWithName, name), err)
+	suite.storage.AssertExpectations(suite.T())
+}
+
 func (suite *serviceTestSuite) TestServiceUpdateUser() {
-	u := model.User{Name: "%", Phone: phone, Address: "%"}
 	tests := map[string]struct {
-		users           []model.User
-		storageResponse *gorm.DB
-		expectedErr     error
+		storageErr  error
+		expectedErr error
 	}{
 		"without_error": {
-			users:           users,
-			storageResponse: &gorm.DB{},
-			expectedErr:     nil,
+			storageErr:  nil,
+			expectedErr: nil,
+		},
+		"not_found": {
+			storageErr:  model.ErrNotFound,
+			expectedErr: service.NotFound(service.ErrUserDoesNotExist),
+		},
+		"duplicate_phone": {
+			storageErr:  model.ErrDuplicatePhone,
+			expectedErr: service.Conflict(service.ErrPhoneIsTaken, phone),
 		},
-		"error_1": {
-			users:           users,
-			storageResponse: &gorm.DB{Error: fmt.Errorf("some error")},
-			expectedErr:     fmt.Errorf(service.ErrPhoneIsTaken, phone),
+		"storage_error": {
+			storageErr:  storageErr,
+			expectedErr: service.Internal(storageErr),
 		},
 	}
 	for caseName, test := range tests {
 		suite.Run(caseName, func() {
-			suite.storage.On("Load", u).Once().Return(test.users)
-			suite.storage.On("Update", phone, user).Once().Return(test.storageResponse)
-			err := suite.service.UpdateUser(u.Phone, user)
+			suite.storage.On("Update", phone, user, defaultFields).Once().Return(user, test.storageErr)
+			_, err := suite.service.UpdateUser(phone, user, nil)
 			suite.Equal(test.expectedErr, err)
 		})
 	}
 }
 
-func (suite *serviceTestSuite) TestServiceUpdateUserError() {
-	u := model.User{Name: "%", Phone: phone, Address: "%"}
-	expectedErr := fmt.Errorf(service.ErrUserDoesNotExist)
-	suite.storage.On("Load", u).Once().Return(emptyUsers)
-	err := suite.service.UpdateUser(u.Phone, user)
+func (suite *serviceTestSuite) TestServiceUpdateUserFields() {
+	fields := []string{model.FieldAddress}
+	updated := model.User{Name: name, Phone: phone, Address: "new address"}
+	suite.storage.On("Update", phone, model.User{Address: "new address"}, fields).Once().Return(updated, nil)
+
+	gotResult, err := suite.service.UpdateUser(phone, model.User{Address: "new address"}, fields)
+	suite.NoError(err)
+	suite.Equal(updated, gotResult)
+
+	_, err = suite.service.UpdateUser(phone, user, []string{model.FieldAddress, "email"})
+	suite.Equal(service.Invalid(service.ErrUnknownField, "email"), err)
+}
+
+func (suite *serviceTestSuite) TestServiceUpdateUserAttributes() {
+	updatedUser := model.User{Name: name, Phone: phone, Attributes: model.Attributes{"tier": "gold"}}
+	fields := []string{model.FieldName, model.FieldAddress, model.FieldPhone, model.FieldAttributes}
+	normalized := updatedUser
+	normalized.PhoneDisplay = phoneDisplay
+	suite.storage.On("Update", phone, normalized, fields).Once().Return(user, nil)
+	_, err := suite.service.UpdateUser(phone, updatedUser, nil)
+	suite.NoError(err)
+
+	_, err = suite.service.UpdateUser(phone, model.User{Attributes: model.Attributes{"": "gold"}}, []string{model.FieldAttributes})
+	suite.Equal(service.Invalid(service.ErrInvalidAttributeKey, "", service.MaxAttributeKeyLength), err)
+}
+
+func (suite *serviceTestSuite) TestServiceUpdateUserContacts() {
+	updated := model.User{
+		Name:   name,
+		Phones: []model.PhoneNumber{{Number: "+78121111111", Display: "+7 812 111-11-11"}, {Number: "+78122222222", Display: "+7 812 222-22-22"}},
+		Emails: []model.Email{{Address: "name@example.com"}},
+	}
+	fields := []string{model.FieldName, model.FieldAddress, model.FieldPhones, model.FieldEmails}
+	suite.storage.On("UpdateByID", uint(7), updated, fields).Once().Return(updated, nil)
+	_, err := suite.service.UpdateUserByID(7, updated, nil)
+	suite.NoError(err)
+
+	suite.storage.On("UpdateByID", uint(7), updated, []string{model.FieldPhones}).Once().Return(model.User{}, model.ErrDuplicatePhone)
+	_, err = suite.service.UpdateUserByID(7, updated, []string{model.FieldPhones})
+	suite.Equal(service.Conflict(service.ErrPhoneIsTaken, "+78121111111, +78122222222"), err)
+
+	_, err = suite.service.UpdateUserByID(7, model.User{Phones: []model.PhoneNumber{{Number: phone, Type: "fax"}}}, nil)
+	suite.Equal(service.Invalid(service.ErrUnknownPhoneType, "fax"), err)
+}
+
+func (suite *serviceTestSuite) TestServiceUpdateUserLeavesNoPhone() {
+	tests := map[string]struct {
+		user   model.User
+		fields []string
+	}{
+		"empty_phones":   {user: model.User{Phones: []model.PhoneNumber{}}, fields: []string{model.FieldPhones}},
+		"blank_phones":   {user: model.User{Phones: []model.PhoneNumber{{Number: ""}}}, fields: []string{model.FieldPhones}},
+		"empty_phone":    {user: model.User{Phones: []model.PhoneNumber{{Number: phone}}}, fields: []string{model.FieldPhone}},
+		"both_empty":     {user: model.User{}, fields: []string{model.FieldPhone, model.FieldPhones}},
+		"without_a_mask": {user: model.User{Name: name}},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			_, err := suite.service.UpdateUserByID(7, test.user, test.fields)
+			suite.Equal(service.Invalid(service.ErrNoPhoneLeft), err)
+			_, err = suite.service.UpdateUser(phone, test.user, test.fields)
+			suite.Equal(service.Invalid(service.ErrNoPhoneLeft), err)
+		})
+	}
+	suite.storage.AssertNotCalled(suite.T(), "UpdateByID", testifymock.Anything, testifymock.Anything, testifymock.Anything)
+	suite.storage.AssertNotCalled(suite.T(), "Update", testifymock.Anything, testifymock.Anything, testifymock.Anything)
+
+	kept := model.User{Phone: phone, PhoneDisplay: phoneDisplay}
+	fields := []string{model.FieldPhone, model.FieldPhones}
+	suite.storage.On("UpdateByID", uint(7), kept, fields).Once().Return(user, nil)
+	_, err := suite.service.UpdateUserByID(7, model.User{Phone: phone}, fields)
+	suite.NoError(err)
+}
+
+func (suite *serviceTestSuite) TestServiceUpdateUserPostalAddress() {
+	updated := model.User{Name: name, Phone: phone, PhoneDisplay: phoneDisplay, PostalAddress: model.PostalAddress{City: "Boston", CountryCode: "US"}}
+	fields := []string{model.FieldName, model.FieldPostalAddress, model.FieldPhone}
+	suite.storage.On("UpdateByID", uint(7), updated, fields).Once().Return(updated, nil)
+
+	_, err := suite.service.UpdateUserByID(7, updated, nil)
+	suite.NoError(err)
+}
+
+func (suite *serviceTestSuite) TestServiceUpdateUserNormalizesPhones() {
+	normalized := model.User{Phone: phone, PhoneDisplay: phoneDisplay}
+	suite.storage.On("Update", phone, normalized, []string{model.FieldPhone}).Once().Return(user, nil)
+	_, err := suite.service.UpdateUser("8-812-987-88-99", model.User{Phone: "+7 812 987 88 99"}, []string{model.FieldPhone})
+	suite.NoError(err)
+
+	_, err = suite.service.UpdateUser(phone, model.User{Phone: "not a phone"}, []string{model.FieldPhone})
+	suite.Equal(service.Invalid(service.ErrInvalidPhone, "not a phone"), err)
+
+	suite.storage.On("Update", "legacy", model.User{Name: name}, []string{model.FieldName}).Once().Return(user, nil)
+	_, err = suite.service.UpdateUser("legacy", model.User{Name: name}, []string{model.FieldName})
+	suite.NoError(err)
+}
+
+func (suite *serviceTestSuite) TestServiceGetUser() {
+	storedUser := model.User{Model: gorm.Model{ID: 7}, Name: name, Phone: phone, Address: address}
+	tests := map[string]struct {
+		storageErr     error
+		expectedResult model.User
+		expectedErr    error
+	}{
+		"without_error": {
+			expectedResult: storedUser,
+		},
+		"not_found": {
+			storageErr:  model.ErrNotFound,
+			expectedErr: service.NotFound(service.ErrNoSuchUserWithID, uint(7)),
+		},
+		"storage_error": {
+			storageErr:  storageErr,
+			expectedErr: service.Internal(storageErr),
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			suite.storage.On("Get", uint(7)).Once().Return(test.expectedResult, test.storageErr)
+			gotResult, err := suite.service.GetUser(7)
+			suite.Equal(test.expectedResult, gotResult)
+			suite.Equal(test.expectedErr, err)
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceUpdateUserByID() {
+	tests := map[string]struct {
+		storageErr  error
+		expectedErr error
+	}{
+		"without_error": {
+			expectedErr: nil,
+		},
+		"not_found": {
+			storageErr:  model.ErrNotFound,
+			expectedErr: service.NotFound(service.ErrNoSuchUserWithID, uint(7)),
+		},
+		"duplicate_phone": {
+			storageErr:  model.ErrDuplicatePhone,
+			expectedErr: service.Conflict(service.ErrPhoneIsTaken, phone),
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			suite.storage.On("UpdateByID", uint(7), user, defaultFields).Once().Return(user, test.storageErr)
+			_, err := suite.service.UpdateUserByID(7, user, nil)
+			suite.Equal(test.expectedErr, err)
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceDeleteUserByID() {
+	tests := map[string]struct {
+		storageErr     error
+		expectedResult string
+		expectedErr    error
+	}{
+		"without_error": {
+			expectedResult: fmt.Sprintf(service.DeleteUserMethodResponse, 1),
+		},
+		"not_found": {
+			storageErr:  model.ErrNotFound,
+			expectedErr: service.NotFound(service.ErrNoSuchUserWithID, uint(7)),
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			suite.storage.On("DeleteByID", uint(7)).Once().Return(test.storageErr)
+			gotResult, err := suite.service.DeleteUserByID(7)
+			suite.Equal(test.expectedResult, gotResult)
+			suite.Equal(test.expectedErr, err)
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceEmptyID() {
+	expectedErr := service.Invalid(service.ErrEmptyID)
+	_, err := suite.service.GetUser(0)
+	suite.Equal(expectedErr, err)
+	_, err = suite.service.UpdateUserByID(0, user, nil)
 	suite.Equal(expectedErr, err)
+	_, err = suite.service.DeleteUserByID(0)
+	suite.Equal(expectedErr, err)
+}
+
+func (suite *serviceTestSuite) TestServiceListDeletedUsers() {
+	query := model.Query{
+		Filter:  model.User{Name: "%", Phone: "%", Address: "%"},
+		Deleted: true,
+		Limit:   service.DefaultPageSize + 1,
+	}
+	suite.storage.On("Load", query).Once().Return(users, int64(1), nil)
+	gotResult, err := suite.service.ListDeletedUsers(service.ListOptions{})
+	suite.NoError(err)
+	suite.Equal(service.Page{Users: users, TotalSize: 1}, gotResult)
+}
+
+func (suite *serviceTestSuite) TestServiceRestoreUser() {
+	tests := map[string]struct {
+		storageErr  error
+		expectedErr error
+	}{
+		"without_error": {
+			expectedErr: nil,
+		},
+		"not_found": {
+			storageErr:  model.ErrNotFound,
+			expectedErr: service.NotFound(service.ErrNoSuchDeletedUser, uint(7)),
+		},
+		"phone_taken": {
+			storageErr:  model.ErrDuplicatePhone,
+			expectedErr: service.Conflict(service.ErrRestorePhoneIsTaken, uint(7)),
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			suite.storage.On("Restore", uint(7)).Once().Return(user, test.storageErr)
+			_, err := suite.service.RestoreUser(7)
+			suite.Equal(test.expectedErr, err)
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServicePurgeDeletedUsers() {
+	olderThan := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	suite.storage.On("Purge", olderThan).Once().Return(int64(3), nil)
+	response, purged, err := suite.service.PurgeDeletedUsers(olderThan)
+	suite.NoError(err)
+	suite.Equal(int64(3), purged)
+	suite.Equal(fmt.Sprintf(service.PurgeUsersResponse, 3), response)
+
+	_, _, err = suite.service.PurgeDeletedUsers(time.Time{})
+	suite.Equal(service.Invalid(service.ErrEmptyOlderThan), err)
+}
+
+func (suite *serviceTestSuite) TestServiceFindDuplicates() {
+	groups := []model.DuplicateGroup{{Users: []model.User{user, user}, Reasons: []string{model.DuplicateName}, Score: 0.8}}
+	tests := map[string]struct {
+		minScore       float64
+		limit          int32
+		storageQuery   model.DuplicateQuery
+		storageErr     error
+		expectedResult []model.DuplicateGroup
+		expectedErr    error
+	}{
+		"defaults": {
+			storageQuery:   model.DuplicateQuery{MinScore: service.DefaultDuplicateScore, Limit: service.DefaultPageSize},
+			expectedResult: groups,
+		},
+		"custom": {
+			minScore:       0.7,
+			limit:          service.MaxPageSize + 1,
+			storageQuery:   model.DuplicateQuery{MinScore: 0.7, Limit: service.MaxPageSize},
+			expectedResult: groups,
+		},
+		"storage_error": {
+			storageQuery: model.DuplicateQuery{MinScore: service.DefaultDuplicateScore, Limit: service.DefaultPageSize},
+			storageErr:   storageErr,
+			expectedErr:  service.Internal(storageErr),
+		},
+		"invalid_score": {
+			minScore:    1.5,
+			expectedErr: service.Invalid(service.ErrInvalidMinScore),
+		},
+		"negative_limit": {
+			limit:       -1,
+			expectedErr: service.Invalid(service.ErrNegativePageSize),
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			if test.expectedResult != nil || test.storageErr != nil {
+				suite.storage.On("FindDuplicates", test.storageQuery).Once().Return(test.expectedResult, test.storageErr)
+			}
+			gotResult, err := suite.service.FindDuplicates(test.minScore, test.limit)
+			suite.Equal(test.expectedResult, gotResult)
+			suite.Equal(test.expectedErr, err)
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceMergeUsers() {
+	many := make([]uint, service.MaxMergeUsers+1)
+	for i := range many {
+		many[i] = uint(i + 1)
+	}
+	tests := map[string]struct {
+		ids            []uint
+		survivor       uint
+		resolution     map[string]string
+		storageMerge   model.Merge
+		storageErr     error
+		expectedMerged []uint
+		expectedErr    error
+	}{
+		"first_survives": {
+			ids:            []uint{3, 1, 3, 2},
+			storageMerge:   model.Merge{Survivor: 3, Merged: []uint{1, 2}, Resolution: map[string]string{}},
+			expectedMerged: []uint{1, 2},
+		},
+		"resolution": {
+			ids:            []uint{1, 2},
+			survivor:       2,
+			resolution:     map[string]string{model.FieldName: model.MergeNewest, model.FieldPhones: ""},
+			storageMerge:   model.Merge{Survivor: 2, Merged: []uint{1}, Resolution: map[string]string{model.FieldName: model.MergeNewest}},
+			expectedMerged: []uint{1},
+		},
+		"not_found": {
+			ids:          []uint{1, 2},
+			storageMerge: model.Merge{Survivor: 1, Merged: []uint{2}, Resolution: map[string]string{}},
+			storageErr:   model.ErrNotFound,
+			expectedErr:  service.NotFound(service.ErrMergedUsersNotFound),
+		},
+		"phone_taken": {
+			ids:          []uint{1, 2},
+			storageMerge: model.Merge{Survivor: 1, Merged: []uint{2}, Resolution: map[string]string{}},
+			storageErr:   model.ErrDuplicatePhone,
+			expectedErr:  service.Conflict(service.ErrMergedPhoneIsTaken),
+		},
+		"storage_error": {
+			ids:          []uint{1, 2},
+			storageMerge: model.Merge{Survivor: 1, Merged: []uint{2}, Resolution: map[string]string{}},
+			storageErr:   storageErr,
+			expectedErr:  service.Internal(storageErr),
+		},
+		"single_user": {
+			ids:         []uint{1, 1},
+			expectedErr: service.Invalid(service.ErrTooFewUsersToMerge),
+		},
+		"zero_id": {
+			ids:         []uint{1, 0},
+			expectedErr: service.Invalid(service.ErrEmptyID),
+		},
+		"too_many": {
+			ids:         many,
+			expectedErr: service.Invalid(service.ErrTooManyUsersToMerge, service.MaxMergeUsers),
+		},
+		"foreign_survivor": {
+			ids:         []uint{1, 2},
+			survivor:    3,
+			expectedErr: service.Invalid(service.ErrSurvivorNotMerged, uint(3)),
+		},
+		"unknown_field": {
+			ids:         []uint{1, 2},
+			resolution:  map[string]string{"birthday": model.MergeNewest},
+			expectedErr: service.Invalid(service.ErrUnknownField, "birthday"),
+		},
+		"unknown_strategy": {
+			ids:         []uint{1, 2},
+			resolution:  map[string]string{model.FieldName: model.MergeUnion},
+			expectedErr: service.Invalid(service.ErrUnknownMergeStrategy, model.MergeUnion, model.FieldName),
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			if test.storageMerge.Survivor != 0 {
+				suite.storage.On("Merge", test.storageMerge).Once().Return(user, test.storageErr)
+			}
+			gotUser, gotMerged, err := suite.service.MergeUsers(test.ids, test.survivor, test.resolution)
+			suite.Equal(test.expectedErr, err)
+			suite.Equal(test.expectedMerged, gotMerged)
+			if err == nil {
+				suite.Equal(user, gotUser)
+			}
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceListUsersInGroup() {
+	query := model.Query{Filter: model.User{Name: "%", Phone: "%", Address: "%"}, Group: "close friends", Limit: service.DefaultPageSize + 1}
+	suite.storage.On("Load", query).Once().Return(users, int64(1), nil)
+	page, err := suite.service.ListUsers(service.ListOptions{Group: "  Close   Friends "})
+	suite.NoError(err)
+	suite.Equal(users, page.Users)
+}
+
+func (suite *serviceTestSuite) TestServiceAddGroup() {
+	group := model.Group{Name: "friends"}
+	tests := map[string]struct {
+		group       model.Group
+		storageErr  error
+		expectedErr error
+	}{
+		"without_error": {
+			group: model.Group{Name: " friends "},
+		},
+		"duplicate": {
+			group:       group,
+			storageErr:  model.ErrDuplicateGroup,
+			expectedErr: service.Conflict(service.ErrGroupAlreadyExists, "friends"),
+		},
+		"storage_error": {
+			group:       group,
+			storageErr:  storageErr,
+			expectedErr: service.Internal(storageErr),
+		},
+		"empty_name": {
+			group:       model.Group{Name: " "},
+			expectedErr: service.Invalid(service.ErrEmptyGroupName),
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			if test.group.Name != " " {
+				suite.storage.On("StoreGroup", group).Once().Return(group, test.storageErr)
+			}
+			got, err := suite.service.AddGroup(test.group)
+			suite.Equal(test.expectedErr, err)
+			if err == nil {
+				suite.Equal(group, got)
+			}
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceGetGroup() {
+	group := model.Group{ID: 1, Name: "friends"}
+	suite.storage.On("GetGroup", uint(1)).Once().Return(group, nil)
+	got, err := suite.service.GetGroup(1)
+	suite.NoError(err)
+	suite.Equal(group, got)
+
+	suite.storage.On("GetGroup", uint(2)).Once().Return(model.Group{}, model.ErrGroupNotFound)
+	_, err = suite.service.GetGroup(2)
+	suite.Equal(service.NotFound(service.ErrNoSuchGroupWithID, uint(2)), err)
+
+	_, err = suite.service.GetGroup(0)
+	suite.Equal(service.Invalid(service.ErrEmptyGroupID), err)
+}
+
+func (suite *serviceTestSuite) TestServiceUpdateGroup() {
+	group := model.Group{ID: 1, Name: "friends"}
+	tests := map[string]struct {
+		fields        []string
+		updated       model.Group
+		storageFields []string
+		storageErr    error
+		expectedErr   error
+	}{
+		"all_fields": {
+			updated:       model.Group{Name: "friends", Description: "close ones"},
+			storageFields: model.UpdatableGroupFields,
+		},
+		"description_only": {
+			fields:        []string{model.FieldGroupDescription},
+			storageFields: []string{model.FieldGroupDescription},
+		},
+		"duplicate": {
+			updated:       model.Group{Name: "friends"},
+			storageFields: model.UpdatableGroupFields,
+			storageErr:    model.ErrDuplicateGroup,
+			expectedErr:   service.Conflict(service.ErrGroupAlreadyExists, "friends"),
+		},
+		"not_found": {
+			updated:       model.Group{Name: "friends"},
+			storageFields: model.UpdatableGroupFields,
+			storageErr:    model.ErrGroupNotFound,
+			expectedErr:   service.NotFound(service.ErrNoSuchGroupWithID, uint(1)),
+		},
+		"empty_name": {
+			expectedErr: service.Invalid(service.ErrEmptyGroupName),
+		},
+		"unknown_field": {
+			fields:      []string{"members"},
+			expectedErr: service.Invalid(service.ErrUnknownField, "members"),
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			if test.storageFields != nil {
+				suite.storage.On("UpdateGroup", uint(1), test.updated, test.storageFields).Once().Return(group, test.storageErr)
+			}
+			got, err := suite.service.UpdateGroup(1, test.updated, test.fields)
+			suite.Equal(test.expectedErr, err)
+			if err == nil {
+				suite.Equal(group, got)
+			}
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceDeleteGroup() {
+	suite.storage.On("DeleteGroup", uint(1)).Once().Return(nil)
+	response, err := suite.service.DeleteGroup(1)
+	suite.NoError(err)
+	suite.Equal(fmt.Sprintf(service.DeleteGroupResponse, 1), response)
+
+	suite.storage.On("DeleteGroup", uint(2)).Once().Return(model.ErrGroupNotFound)
+	_, err = suite.service.DeleteGroup(2)
+	suite.Equal(service.NotFound(service.ErrNoSuchGroupWithID, uint(2)), err)
+}
+
+func (suite *serviceTestSuite) TestServiceGroupMembers() {
+	many := make([]uint, service.MaxPageSize+1)
+	for i := range many {
+		many[i] = uint(i + 1)
+	}
+	tests := map[string]struct {
+		id            uint
+		userIDs       []uint
+		storageIDs    []uint
+		storageErr    error
+		expectedCount int64
+		expectedErr   error
+	}{
+		"without_error": {
+			id:            1,
+			userIDs:       []uint{2, 3, 2},
+			storageIDs:    []uint{2, 3},
+			expectedCount: 2,
+		},
+		"group_not_found": {
+			id:          1,
+			userIDs:     []uint{2},
+			storageIDs:  []uint{2},
+			storageErr:  model.ErrGroupNotFound,
+			expectedErr: service.NotFound(service.ErrNoSuchGroupWithID, uint(1)),
+		},
+		"users_not_found": {
+			id:          1,
+			userIDs:     []uint{2},
+			storageIDs:  []uint{2},
+			storageErr:  model.ErrNotFound,
+			expectedErr: service.NotFound(service.ErrMembersNotFound),
+		},
+		"storage_error": {
+			id:          1,
+			userIDs:     []uint{2},
+			storageIDs:  []uint{2},
+			storageErr:  storageErr,
+			expectedErr: service.Internal(storageErr),
+		},
+		"empty_group_id": {
+			userIDs:     []uint{2},
+			expectedErr: service.Invalid(service.ErrEmptyGroupID),
+		},
+		"no_users": {
+			id:          1,
+			expectedErr: service.Invalid(service.ErrEmptyUserIDs),
+		},
+		"zero_user_id": {
+			id:          1,
+			userIDs:     []uint{2, 0},
+			expectedErr: service.Invalid(service.ErrEmptyID),
+		},
+		"too_many": {
+			id:          1,
+			userIDs:     many,
+			expectedErr: service.Invalid(service.ErrTooManyUserIDs, service.MaxPageSize),
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			if test.storageIDs != nil {
+				suite.storage.On("AddGroupMembers", test.id, test.storageIDs).Once().Return(test.expectedCount, test.storageErr)
+				suite.storage.On("RemoveGroupMembers", test.id, test.storageIDs).Once().Return(test.expectedCount, test.storageErr)
+			}
+			response, added, err := suite.service.AddGroupMembers(test.id, test.userIDs)
+			suite.Equal(test.expectedErr, err)
+			suite.Equal(test.expectedCount, added)
+			if err == nil {
+				suite.Equal(fmt.Sprintf(service.AddedMembersResponse, test.expectedCount), response)
+			}
+
+			response, removed, err := suite.service.RemoveGroupMembers(test.id, test.userIDs)
+			suite.Equal(test.expectedErr, err)
+			suite.Equal(test.expectedCount, removed)
+			if err == nil {
+				suite.Equal(fmt.Sprintf(service.RemovedMembersResponse, test.expectedCount), response)
+			}
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceGetUserHistory() {
+	created := model.Snapshot{Name: name, Address: address, Phones: []model.SnapshotPhone{{Number: phone, Primary: true}}}
+	updated := created
+	updated.PostalAddress = model.PostalAddress{City: "London"}
+	updated.Attributes = model.Attributes{"tier": "gold"}
+	revisions := []model.UserRevision{
+		{UserID: 7, Revision: 1, Action: model.RevisionCreated, Snapshot: created},
+		{UserID: 7, Revision: 2, Action: model.RevisionUpdated, Snapshot: updated},
+		{UserID: 7, Revision: 3, Action: model.RevisionDeleted, Snapshot: updated},
+	}
+	suite.storage.On("History", uint(7)).Once().Return(revisions, nil)
+	history, err := suite.service.GetUserHistory(7)
+	suite.NoError(err)
+	suite.Require().Len(history, 3)
+	suite.Equal([]string{model.FieldName, model.FieldAddress, model.FieldPhones}, history[0].Changed)
+	suite.Equal([]string{model.FieldPostalAddress, model.FieldAttributes}, history[1].Changed)
+	suite.Empty(history[2].Changed)
+	suite.Equal(revisions[1], history[1].UserRevision)
+
+	suite.storage.On("History", uint(8)).Once().Return(nil, model.ErrNotFound)
+	_, err = suite.service.GetUserHistory(8)
+	suite.Equal(service.NotFound(service.ErrNoSuchUserWithID, uint(8)), err)
+
+	_, err = suite.service.GetUserHistory(0)
+	suite.Equal(service.Invalid(service.ErrEmptyID), err)
+}
+
+func (suite *serviceTestSuite) TestServiceRevertUser() {
+	tests := map[string]struct {
+		id, revision uint
+		storageErr   error
+		expectedErr  error
+	}{
+		"without_error": {
+			id: 7, revision: 2,
+		},
+		"empty_id": {
+			revision:    2,
+			expectedErr: service.Invalid(service.ErrEmptyID),
+		},
+		"empty_revision": {
+			id:          7,
+			expectedErr: service.Invalid(service.ErrEmptyRevision),
+		},
+		"user_not_found": {
+			id: 7, revision: 2,
+			storageErr:  model.ErrNotFound,
+			expectedErr: service.NotFound(service.ErrNoSuchUserWithID, uint(7)),
+		},
+		"revision_not_found": {
+			id: 7, revision: 2,
+			storageErr:  model.ErrNoRevision,
+			expectedErr: service.NotFound(service.ErrNoSuchRevision, uint(7), uint(2)),
+		},
+		"phone_taken": {
+			id: 7, revision: 2,
+			storageErr:  model.ErrDuplicatePhone,
+			expectedErr: service.Conflict(service.ErrRevertPhoneIsTaken, uint(7), uint(2)),
+		},
+		"storage_error": {
+			id: 7, revision: 2,
+			storageErr:  storageErr,
+			expectedErr: service.Internal(storageErr),
+		},
+	}
+	for caseName, test := range tests {
+		suite.Run(caseName, func() {
+			suite.storage.On("Revert", test.id, test.revision).Once().Return(user, test.storageErr)
+			_, err := suite.service.RevertUser(test.id, test.revision)
+			suite.Equal(test.expectedErr, err)
+		})
+	}
+}
+
+func (suite *serviceTestSuite) TestServiceRecordAuditEvent() {
+	event := model.AuditEvent{Actor: "alice", Method: "/pb.AddressBookService/AddUser", Code: "OK", Affected: 1}
+	suite.storage.On("StoreAuditEvent", event).Once().Return(nil)
+	suite.NoError(suite.service.RecordAuditEvent(event))
+
+	suite.storage.On("StoreAuditEvent", event).Once().Return(storageErr)
+	suite.Equal(service.Internal(storageErr), suite.service.RecordAuditEvent(event))
+}
+
+func (suite *serviceTestSuite) TestServiceListAuditEvents() {
+	from := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	events := []model.AuditEvent{{ID: 9, Actor: "alice"}, {ID: 7, Actor: "alice"}, {ID: 4, Actor: "alice"}}
+
+	query := model.AuditQuery{From: from, To: to, Actor: "alice", Limit: 3}
+	suite.storage.On("LoadAuditEvents", query).Once().Return(events, nil)
+	page, err := suite.service.ListAuditEvents(service.AuditOptions{From: from, To: to, Actor: "alice", PageSize: 2})
+	suite.NoError(err)
+	suite.Equal(events[:2], page.Events)
+	suite.NotEmpty(page.NextPageToken)
+
+	query.BeforeID = 7
+	suite.storage.On("LoadAuditEvents", query).Once().Return(events[2:], nil)
+	page, err = suite.service.ListAuditEvents(service.AuditOptions{From: from, To: to, Actor: "alice", PageSize: 2, PageToken: page.NextPageToken})
+	suite.NoError(err)
+	suite.Equal(events[2:], page.Events)
+	suite.Empty(page.NextPageToken)
+
+	suite.storage.On("LoadAuditEvents", model.AuditQuery{Limit: service.DefaultPageSize + 1}).Once().Return(nil, storageErr)
+	_, err = suite.service.ListAuditEvents(service.AuditOptions{})
+	suite.Equal(service.Internal(storageErr), err)
+
+	_, err = suite.service.ListAuditEvents(service.AuditOptions{From: to, To: from})
+	suite.Equal(service.Invalid(service.ErrInvalidTimeRange), err)
+	_, err = suite.service.ListAuditEvents(service.AuditOptions{PageSize: -1})
+	suite.Equal(service.Invalid(service.ErrNegativePageSize), err)
+	_, err = suite.service.ListAuditEvents(service.AuditOptions{PageToken: "!"})
+	suite.Equal(service.Invalid(service.ErrInvalidPageToken), err)
 }
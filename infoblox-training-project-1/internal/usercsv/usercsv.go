@@ -0,0 +1,356 @@
+// Package usercsv writes users as CSV and reads them from spreadsheets whose
+// columns are mapped onto user fields.
+package usercsv
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+const (
+	ContentType = "text/csv"
+
+	FieldMobilePhone = "mobile_phone"
+	FieldHomePhone   = "home_phone"
+	FieldWorkPhone   = "work_phone"
+	FieldHomeEmail   = "home_email"
+	FieldWorkEmail   = "work_email"
+
+	// ListSeparator separates the phones or emails of a cell.
+	ListSeparator = ";"
+)
+
+// Fields are the fields columns can be mapped to. Phone and email hold the
+// primary phone number and email, the typed columns all of their type.
+var Fields = []string{
+	model.FieldName,
+	model.FieldPhone,
+	FieldMobilePhone,
+	FieldHomePhone,
+	FieldWorkPhone,
+	model.FieldEmail,
+	FieldHomeEmail,
+	FieldWorkEmail,
+	model.FieldAddress,
+	model.FieldStreet,
+	model.FieldCity,
+	model.FieldRegion,
+	model.FieldPostalCode,
+	model.FieldCountryCode,
+}
+
+// Columns is the header of exported files, every field and the read-only
+// ones. Files read with an empty mapping use the same names.
+var Columns = append(append([]string{model.FieldID}, Fields...), model.FieldCreatedAt, model.FieldUpdatedAt)
+
+var (
+	phoneFields = map[string]string{
+		FieldMobilePhone: model.PhoneMobile,
+		FieldHomePhone:   model.PhoneHome,
+		FieldWorkPhone:   model.PhoneWork,
+	}
+	emailFields = map[string]string{
+		FieldHomeEmail: model.EmailHome,
+		FieldWorkEmail: model.EmailWork,
+	}
+)
+
+var ErrMapping = errors.New("invalid column mapping")
+
+// Marshal writes the header and a row per user.
+func Marshal(users []model.User) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	if err := w.Write(Columns); err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		if err := w.Write(record(u)); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+func record(u model.User) []string {
+	values := map[string][]string{
+		model.FieldID:          {strconv.FormatUint(uint64(u.ID), 10)},
+		model.FieldName:        {u.Name},
+		model.FieldAddress:     {u.Address},
+		model.FieldStreet:      {u.PostalAddress.Street},
+		model.FieldCity:        {u.PostalAddress.City},
+		model.FieldRegion:      {u.PostalAddress.Region},
+		model.FieldPostalCode:  {u.PostalAddress.PostalCode},
+		model.FieldCountryCode: {u.PostalAddress.CountryCode},
+		model.FieldCreatedAt:   {formatTime(u.CreatedAt)},
+		model.FieldUpdatedAt:   {formatTime(u.UpdatedAt)},
+	}
+	if len(u.Phones) == 0 {
+		values[model.FieldPhone] = []string{displayOr(u.PhoneDisplay, u.Phone)}
+	}
+	for _, p := range u.Phones {
+		number := displayOr(p.Display, p.Number)
+		if p.Primary {
+			values[model.FieldPhone] = []string{number}
+		}
+		for field, t := range phoneFields {
+			if p.Type == t {
+				values[field] = append(values[field], number)
+			}
+		}
+	}
+	for _, e := range u.Emails {
+		if e.Primary {
+			values[model.FieldEmail] = []string{e.Address}
+		}
+		for field, t := range emailFields {
+			if e.Type == t {
+				values[field] = append(values[field], e.Address)
+			}
+		}
+	}
+
+	r := make([]string, len(Columns))
+	for i, column := range Columns {
+		r[i] = strings.Join(values[column], ListSeparator+" ")
+	}
+	return r
+}
+
+func displayOr(display, number string) string {
+	if display != "" {
+		return display
+	}
+	return number
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Row is a record read from a file. Line counts from 1 for the header. Err
+// is set instead of the user for records that could not be parsed.
+type Row struct {
+	Line   int
+	Record []string
+	User   model.User
+	Err    error
+}
+
+// Reader reads users from a CSV file with a header row.
+type Reader struct {
+	r      *csv.Reader
+	header []string
+	// fields has the field of every column, empty for ignored ones.
+	fields []string
+}
+
+// NewReader reads the header and maps its columns, matched ignoring case and
+// surrounding spaces, to the fields of the mapping. Every column of the
+// mapping must be in the header, columns missing from it or mapped to an
+// empty field are ignored.
+func NewReader(r io.Reader, mapping map[string]string) (*Reader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("missing header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0 {
+		// Spreadsheets often save UTF-8 with a byte order mark.
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	// Without a mapping columns named like fields are read, the others and
+	// missing fields are fine.
+	strict := len(mapping) > 0
+	if !strict {
+		mapping = make(map[string]string, len(Fields))
+		for _, field := range Fields {
+			mapping[field] = field
+		}
+	}
+	columns := make(map[string]string, len(mapping))
+	for column, field := range mapping {
+		if field != "" && !isField(field) {
+			return nil, fmt.Errorf("%w: unknown field %q for column %q", ErrMapping, field, column)
+		}
+		columns[columnKey(column)] = field
+	}
+
+	reader := &Reader{r: cr, header: header, fields: make([]string, len(header))}
+	found := make(map[string]bool, len(header))
+	mapped := false
+	for i, column := range header {
+		found[columnKey(column)] = true
+		reader.fields[i] = columns[columnKey(column)]
+		mapped = mapped || reader.fields[i] != ""
+	}
+	if strict {
+		for column := range mapping {
+			if !found[columnKey(column)] {
+				return nil, fmt.Errorf("%w: no column %q in the header", ErrMapping, column)
+			}
+		}
+	}
+	if !mapped {
+		return nil, fmt.Errorf("%w: no column is mapped to a field", ErrMapping)
+	}
+	return reader, nil
+}
+
+func columnKey(column string) string {
+	return strings.ToLower(strings.TrimSpace(column))
+}
+
+func isField(field string) bool {
+	for _, f := range Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Header returns the header row of the file.
+func (r *Reader) Header() []string {
+	return r.header
+}
+
+// Read returns the next row, or io.EOF at the end of the file. Reading goes
+// on after rows that could not be parsed. Values of
+// columns mapped to the same field are joined with a space, phone and email
+// cells may list several values separated by ListSeparator. A phone or
+// email column value that also appears in a typed column marks that one
+// primary.
+func (r *Reader) Read() (Row, error) {
+	record, err := r.r.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return Row{Line: parseErr.StartLine, Err: err}, nil
+	}
+	if err != nil {
+		return Row{}, err
+	}
+	line, _ := r.r.FieldPos(0)
+	row := Row{Line: line, Record: record}
+
+	values := map[string][]string{}
+	for i, value := range record {
+		if i >= len(r.fields) || r.fields[i] == "" {
+			continue
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			values[r.fields[i]] = append(values[r.fields[i]], value)
+		}
+	}
+
+	u := &row.User
+	u.Name = strings.Join(values[model.FieldName], " ")
+	u.Address = strings.Join(values[model.FieldAddress], " ")
+	u.PostalAddress = model.PostalAddress{
+		Street:      strings.Join(values[model.FieldStreet], " "),
+		City:        strings.Join(values[model.FieldCity], " "),
+		Region:      strings.Join(values[model.FieldRegion], " "),
+		PostalCode:  strings.Join(values[model.FieldPostalCode], " "),
+		CountryCode: strings.Join(values[model.FieldCountryCode], " "),
+	}
+
+	// Fields are visited in a fixed order so that numbers keep it.
+	for _, field := range Fields {
+		if t, ok := phoneFields[field]; ok {
+			for _, number := range split(values[field]) {
+				u.Phones = append(u.Phones, model.PhoneNumber{Number: number, Type: t})
+			}
+		}
+		if t, ok := emailFields[field]; ok {
+			for _, address := range split(values[field]) {
+				u.Emails = append(u.Emails, model.Email{Address: address, Type: t})
+			}
+		}
+	}
+	for i, number := range split(values[model.FieldPhone]) {
+		if j := findPhone(u.Phones, number); j >= 0 {
+			u.Phones[j].Primary = u.Phones[j].Primary || i == 0
+			continue
+		}
+		u.Phones = append(u.Phones, model.PhoneNumber{Number: number, Primary: i == 0})
+	}
+	for i, address := range split(values[model.FieldEmail]) {
+		if j := findEmail(u.Emails, address); j >= 0 {
+			u.Emails[j].Primary = u.Emails[j].Primary || i == 0
+			continue
+		}
+		u.Emails = append(u.Emails, model.Email{Address: address, Primary: i == 0})
+	}
+	return row, nil
+}
+
+func split(cells []string) []string {
+	var values []string
+	for _, cell := range cells {
+		for _, value := range strings.Split(cell, ListSeparator) {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+func findPhone(phones []model.PhoneNumber, number string) int {
+	for i, p := range phones {
+		if p.Number == number {
+			return i
+		}
+	}
+	return -1
+}
+
+func findEmail(emails []model.Email, address string) int {
+	for i, e := range emails {
+		if strings.EqualFold(e.Address, address) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Rejected is a row that was not imported.
+type Rejected struct {
+	Row    Row
+	Reason string
+}
+
+// MarshalRejected writes the error report of an import: the header of the
+// imported file behind line and error columns, then the rejected rows.
+func MarshalRejected(header []string, rejected []Rejected) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	if err := w.Write(append([]string{"line", "error"}, header...)); err != nil {
+		return nil, err
+	}
+	for _, r := range rejected {
+		if err := w.Write(append([]string{strconv.Itoa(r.Row.Line), r.Reason}, r.Row.Record...)); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
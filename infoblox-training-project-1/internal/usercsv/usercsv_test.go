@@ -0,0 +1,196 @@
+package usercsv_test
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+	"github.com/vstarostin/infoblox-training-project-1/internal/usercsv"
+)
+
+type userCSVTestSuite struct {
+	suite.Suite
+}
+
+func TestUserCSV(t *testing.T) {
+	suite.Run(t, new(userCSVTestSuite))
+}
+
+func (suite *userCSVTestSuite) readAll(r *usercsv.Reader) []usercsv.Row {
+	var rows []usercsv.Row
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return rows
+		}
+		suite.Require().NoError(err)
+		rows = append(rows, row)
+	}
+}
+
+func (suite *userCSVTestSuite) TestMarshal() {
+	created := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	users := []model.User{
+		{
+			Model: gorm.Model{ID: 1, CreatedAt: created, UpdatedAt: created},
+			Name:  "Doe, Jane",
+			Phones: []model.PhoneNumber{
+				{Number: "+12127363100", Display: "+1 212-736-3100", Type: model.PhoneWork, Primary: true},
+				{Number: "+12127363101", Display: "+1 212-736-3101", Type: model.PhoneMobile},
+				{Number: "+12127363102", Display: "+1 212-736-3102", Type: model.PhoneWork},
+			},
+			Emails:        []model.Email{{Address: "jane@example.com", Type: model.EmailWork}},
+			Address:       "350 5th Avenue, New York, US",
+			PostalAddress: model.PostalAddress{Street: "350 5th Avenue", City: "New York", CountryCode: "US"},
+		},
+		{Model: gorm.Model{ID: 2}, Name: "john", Phone: "+78129878899", Address: "moscow"},
+	}
+
+	data, err := usercsv.Marshal(users)
+	suite.NoError(err)
+	suite.Equal("id,name,phone,mobile_phone,home_phone,work_phone,email,home_email,work_email,address,street,city,region,postal_code,country_code,created_at,updated_at\n"+
+		`1,"Doe, Jane",+1 212-736-3100,+1 212-736-3101,,+1 212-736-3100; +1 212-736-3102,,,jane@example.com,"350 5th Avenue, New York, US",350 5th Avenue,New York,,,US,2021-11-01T12:00:00Z,2021-11-01T12:00:00Z`+"\n"+
+		"2,john,+78129878899,,,,,,,moscow,,,,,,,\n", string(data))
+}
+
+func (suite *userCSVTestSuite) TestRoundTrip() {
+	user := model.User{
+		Name: "Jane",
+		Phones: []model.PhoneNumber{
+			{Number: "+12127363100", Type: model.PhoneWork, Primary: true},
+			{Number: "+12127363101", Type: model.PhoneMobile},
+		},
+		Emails:        []model.Email{{Address: "jane@example.com", Type: model.EmailHome, Primary: true}},
+		Address:       "New York",
+		PostalAddress: model.PostalAddress{City: "New York"},
+	}
+	data, err := usercsv.Marshal([]model.User{user})
+	suite.NoError(err)
+
+	r, err := usercsv.NewReader(strings.NewReader(string(data)), nil)
+	suite.Require().NoError(err)
+	rows := suite.readAll(r)
+	suite.Require().Len(rows, 1)
+	suite.Equal(2, rows[0].Line)
+	suite.Equal(model.User{
+		Name: "Jane",
+		Phones: []model.PhoneNumber{
+			{Number: "+12127363101", Type: model.PhoneMobile},
+			{Number: "+12127363100", Type: model.PhoneWork, Primary: true},
+		},
+		Emails:        []model.Email{{Address: "jane@example.com", Type: model.EmailHome, Primary: true}},
+		Address:       "New York",
+		PostalAddress: model.PostalAddress{City: "New York"},
+	}, rows[0].User)
+}
+
+func (suite *userCSVTestSuite) TestReadMapping() {
+	input := "\ufeffFirst Name,Last Name,Mobile,Office,E-mail,Town,Notes\n" +
+		"Jane,Doe,+1 212-736-3100; +1 212-736-3101,+1 212-736-3102,jane@example.com,New York,vip\n" +
+		"\"Smith, Jr.\",,8-812-987-88-99\n"
+	mapping := map[string]string{
+		"first name": model.FieldName,
+		"Last Name":  model.FieldName,
+		"mobile":     usercsv.FieldMobilePhone,
+		"office":     usercsv.FieldWorkPhone,
+		"e-mail":     model.FieldEmail,
+		"town":       model.FieldCity,
+		"notes":      "",
+	}
+	r, err := usercsv.NewReader(strings.NewReader(input), mapping)
+	suite.Require().NoError(err)
+	suite.Equal([]string{"First Name", "Last Name", "Mobile", "Office", "E-mail", "Town", "Notes"}, r.Header())
+
+	rows := suite.readAll(r)
+	suite.Equal([]usercsv.Row{
+		{
+			Line:   2,
+			Record: []string{"Jane", "Doe", "+1 212-736-3100; +1 212-736-3101", "+1 212-736-3102", "jane@example.com", "New York", "vip"},
+			User: model.User{
+				Name: "Jane Doe",
+				Phones: []model.PhoneNumber{
+					{Number: "+1 212-736-3100", Type: model.PhoneMobile},
+					{Number: "+1 212-736-3101", Type: model.PhoneMobile},
+					{Number: "+1 212-736-3102", Type: model.PhoneWork},
+				},
+				Emails:        []model.Email{{Address: "jane@example.com", Primary: true}},
+				PostalAddress: model.PostalAddress{City: "New York"},
+			},
+		},
+		{
+			Line:   3,
+			Record: []string{"Smith, Jr.", "", "8-812-987-88-99"},
+			User: model.User{
+				Name:   "Smith, Jr.",
+				Phones: []model.PhoneNumber{{Number: "8-812-987-88-99", Type: model.PhoneMobile}},
+			},
+		},
+	}, rows)
+}
+
+func (suite *userCSVTestSuite) TestReadParseError() {
+	input := "name,phone\n" +
+		"jane,+12127363100\n" +
+		"jo\"hn,+78129878899\n" +
+		"jack,+78121112233\n"
+	r, err := usercsv.NewReader(strings.NewReader(input), nil)
+	suite.Require().NoError(err)
+	rows := suite.readAll(r)
+	suite.Require().Len(rows, 3)
+	suite.Equal(3, rows[1].Line)
+	suite.True(errors.Is(rows[1].Err, csv.ErrBareQuote))
+	suite.Equal("jack", rows[2].User.Name)
+}
+
+func (suite *userCSVTestSuite) TestMarshalRejected() {
+	rejected := []usercsv.Rejected{
+		{Row: usercsv.Row{Line: 3, Record: []string{"", "+12127363100"}}, Reason: "user.userName: must not be empty"},
+		{Row: usercsv.Row{Line: 5}, Reason: "parse error"},
+	}
+	data, err := usercsv.MarshalRejected([]string{"Name", "Phone"}, rejected)
+	suite.NoError(err)
+	suite.Equal("line,error,Name,Phone\n3,user.userName: must not be empty,,+12127363100\n5,parse error\n", string(data))
+}
+
+func (suite *userCSVTestSuite) TestNewReaderErrors() {
+	tests := map[string]struct {
+		input    string
+		mapping  map[string]string
+		expected string
+	}{
+		"empty": {
+			input:    "",
+			expected: "missing header row",
+		},
+		"unknown_field": {
+			input:    "Name\n",
+			mapping:  map[string]string{"Name": "nickname"},
+			expected: `invalid column mapping: unknown field "nickname" for column "Name"`,
+		},
+		"missing_column": {
+			input:    "Name\n",
+			mapping:  map[string]string{"Name": model.FieldName, "Phone": model.FieldPhone},
+			expected: `invalid column mapping: no column "Phone" in the header`,
+		},
+		"nothing_mapped": {
+			input:    "Nickname,Notes\n",
+			expected: "invalid column mapping: no column is mapped to a field",
+		},
+	}
+	for name, test := range tests {
+		suite.Run(name, func() {
+			_, err := usercsv.NewReader(strings.NewReader(test.input), test.mapping)
+			suite.EqualError(err, test.expected)
+			if name != "empty" {
+				suite.True(errors.Is(err, usercsv.ErrMapping))
+			}
+		})
+	}
+}
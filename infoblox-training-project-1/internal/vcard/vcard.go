@@ -0,0 +1,502 @@
+// Package vcard writes users as vCards and reads them back, both vCard 3.0
+// (RFC 2426) and 4.0 (RFC 6350).
+package vcard
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+)
+
+const (
+	Version3 = "3.0"
+	Version4 = "4.0"
+
+	ContentType = "text/vcard"
+
+	// maxLineLength limits unfolded lines, folded ones are 75 octets.
+	maxLineLength = 1 << 20
+	// foldLength is the number of octets a line is folded at.
+	foldLength = 75
+)
+
+var Versions = []string{Version3, Version4}
+
+var ErrUnsupportedVersion = errors.New("unsupported vCard version")
+
+// ignored are properties that describe the vCard rather than the user, they
+// are dropped without being reported.
+var ignored = map[string]bool{
+	"BEGIN":   true,
+	"END":     true,
+	"VERSION": true,
+	"PRODID":  true,
+	"UID":     true,
+	"REV":     true,
+}
+
+var (
+	phoneTypes = map[string]string{
+		"cell": model.PhoneMobile,
+		"home": model.PhoneHome,
+		"work": model.PhoneWork,
+	}
+	emailTypes = map[string]string{
+		"home": model.EmailHome,
+		"work": model.EmailWork,
+	}
+)
+
+// Card is a decoded vCard. Unmapped lists the properties which have no
+// place in model.User once each in the order they appear, e.g. "BDAY", or
+// "ADR" for every address but the first.
+type Card struct {
+	User     model.User
+	Unmapped []string
+}
+
+func (c *Card) unmap(name string) {
+	for _, n := range c.Unmapped {
+		if n == name {
+			return
+		}
+	}
+	c.Unmapped = append(c.Unmapped, name)
+}
+
+// Marshal writes u as a single vCard of the version.
+func Marshal(u model.User, version string) ([]byte, error) {
+	if version != Version3 && version != Version4 {
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedVersion, version)
+	}
+	v4 := version == Version4
+
+	var b bytes.Buffer
+	writeLine(&b, "BEGIN:VCARD")
+	writeLine(&b, "VERSION:"+version)
+	writeLine(&b, "FN:"+escape(u.Name))
+	if !v4 {
+		// N is required by 3.0, the name is not split into its parts.
+		writeLine(&b, "N:"+escape(u.Name)+";;;;")
+	}
+
+	phones := u.Phones
+	if len(phones) == 0 && u.Phone != "" {
+		phones = []model.PhoneNumber{{Number: u.Phone, Primary: true}}
+	}
+	for _, p := range phones {
+		types := typeNames(phoneTypes, p.Type)
+		if v4 {
+			writeLine(&b, "TEL;VALUE=uri"+params(types, p.Primary, true)+":tel:"+p.Number)
+		} else {
+			writeLine(&b, "TEL"+params(types, p.Primary, false)+":"+escape(p.Number))
+		}
+	}
+	for _, e := range u.Emails {
+		types := typeNames(emailTypes, e.Type)
+		if !v4 {
+			types = append([]string{"internet"}, types...)
+		}
+		writeLine(&b, "EMAIL"+params(types, e.Primary, v4)+":"+escape(e.Address))
+	}
+
+	a := u.PostalAddress
+	if a.IsZero() && u.Address != "" {
+		// Addresses created before they were structured are free text.
+		a = model.PostalAddress{Street: u.Address}
+	}
+	if !a.IsZero() {
+		components := []string{"", "", a.Street, a.City, a.Region, a.PostalCode, a.CountryCode}
+		for i := range components {
+			components[i] = escape(components[i])
+		}
+		writeLine(&b, "ADR:"+strings.Join(components, ";"))
+	}
+	writeLine(&b, "END:VCARD")
+	return b.Bytes(), nil
+}
+
+// typeNames returns the vCard TYPE values of a model type.
+func typeNames(types map[string]string, modelType string) []string {
+	for name, t := range types {
+		if t == modelType {
+			return []string{name}
+		}
+	}
+	return nil
+}
+
+// params formats the TYPE and preference parameters, 4.0 marks the
+// preferred value with PREF=1 and 3.0 with TYPE=pref.
+func params(types []string, pref, v4 bool) string {
+	var s string
+	if pref && !v4 {
+		types = append(types, "pref")
+	}
+	if len(types) > 0 {
+		s += ";TYPE=" + strings.Join(types, ",")
+	}
+	if pref && v4 {
+		s += ";PREF=1"
+	}
+	return s
+}
+
+// writeLine writes a content line folded at foldLength octets without
+// splitting UTF-8 sequences.
+func writeLine(b *bytes.Buffer, line string) {
+	limit := foldLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// The leading space of continuation lines counts too.
+		limit = foldLength - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			if s[i] == 'n' || s[i] == 'N' {
+				b.WriteByte('\n')
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// components splits a structured value at the unescaped semicolons and
+// unescapes the parts.
+func components(value string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case ';':
+			parts = append(parts, unescape(value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, unescape(value[start:]))
+}
+
+// property is an unfolded content line, names are upper case, parameter
+// names upper case and their values lower case.
+type property struct {
+	name   string
+	params map[string][]string
+	value  string
+}
+
+func (p property) has(param, value string) bool {
+	for _, v := range p.params[param] {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// pref ranks a preferred value from 1, the most preferred, to 100, or
+// returns 0 if it is not preferred.
+func (p property) pref() int {
+	if p.has("TYPE", "pref") {
+		return 1
+	}
+	if values := p.params["PREF"]; len(values) > 0 {
+		if n, err := strconv.Atoi(values[0]); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// parseLine parses "[group.]name *(;param[=value *(,value)]):value".
+func parseLine(line string) (property, error) {
+	p := property{params: map[string][]string{}}
+	i, quoted := 0, false
+	for ; i < len(line); i++ {
+		if line[i] == '"' {
+			quoted = !quoted
+		} else if line[i] == ':' && !quoted {
+			break
+		}
+	}
+	if i == len(line) {
+		return property{}, errors.New("missing : after the property name")
+	}
+	head, value := line[:i], line[i+1:]
+	p.value = value
+
+	fields := splitUnquoted(head, ';')
+	name := fields[0]
+	if dot := strings.LastIndexByte(name, '.'); dot >= 0 {
+		name = name[dot+1:]
+	}
+	if name == "" {
+		return property{}, errors.New("empty property name")
+	}
+	p.name = strings.ToUpper(name)
+
+	for _, param := range fields[1:] {
+		key, values := "TYPE", param
+		// 3.0 exporters still write bare types, e.g. TEL;CELL.
+		if eq := strings.IndexByte(param, '='); eq >= 0 {
+			key, values = strings.ToUpper(param[:eq]), param[eq+1:]
+		}
+		for _, v := range splitUnquoted(values, ',') {
+			v = strings.ToLower(strings.Trim(v, `"`))
+			if key == "TYPE" {
+				// Quoted lists are common too, e.g. TYPE="work,voice".
+				p.params[key] = append(p.params[key], strings.Split(v, ",")...)
+				continue
+			}
+			p.params[key] = append(p.params[key], v)
+		}
+	}
+	return p, nil
+}
+
+func splitUnquoted(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// Decoder reads the vCards of a stream one at a time.
+type Decoder struct {
+	scanner *bufio.Scanner
+	// line is the number of the last physical line read.
+	line int
+	// pending is the physical line read ahead while unfolding.
+	pending    string
+	hasPending bool
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	return &Decoder{scanner: scanner}
+}
+
+// readLine returns the next unfolded non-empty line and the number of its
+// first physical line, or io.EOF at the end of the stream.
+func (d *Decoder) readLine() (string, int, error) {
+	var b strings.Builder
+	start := 0
+	for {
+		if !d.hasPending {
+			if !d.scanner.Scan() {
+				if err := d.scanner.Err(); err != nil {
+					return "", 0, err
+				}
+				break
+			}
+			d.line++
+			d.pending, d.hasPending = strings.TrimSuffix(d.scanner.Text(), "\r"), true
+		}
+		line := d.pending
+		if start > 0 && line != "" && (line[0] == ' ' || line[0] == '\t') {
+			b.WriteString(line[1:])
+			d.hasPending = false
+			continue
+		}
+		if start > 0 {
+			break
+		}
+		d.hasPending = false
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		b.WriteString(line)
+		start = d.line
+	}
+	if start == 0 {
+		return "", 0, io.EOF
+	}
+	return b.String(), start, nil
+}
+
+// Decode returns the next vCard, or io.EOF when there are no more. Errors
+// give the line they were found at, the rest of the stream cannot be read
+// after them.
+func (d *Decoder) Decode() (Card, error) {
+	line, n, err := d.readLine()
+	if err != nil {
+		return Card{}, err
+	}
+	if p, err := parseLine(line); err != nil || p.name != "BEGIN" || !strings.EqualFold(p.value, "VCARD") {
+		return Card{}, fmt.Errorf("line %d: expected BEGIN:VCARD", n)
+	}
+
+	var props []property
+	for {
+		line, n, err = d.readLine()
+		if err == io.EOF {
+			return Card{}, fmt.Errorf("line %d: missing END:VCARD", d.line)
+		}
+		if err != nil {
+			return Card{}, err
+		}
+		p, err := parseLine(line)
+		if err != nil {
+			return Card{}, fmt.Errorf("line %d: %v", n, err)
+		}
+		switch {
+		case p.name == "BEGIN":
+			return Card{}, fmt.Errorf("line %d: nested vCards are not supported", n)
+		case p.name == "VERSION" && p.value != Version3 && p.value != Version4:
+			return Card{}, fmt.Errorf("line %d: %w %q", n, ErrUnsupportedVersion, p.value)
+		}
+		if p.name == "END" {
+			break
+		}
+		props = append(props, p)
+	}
+	return toCard(props), nil
+}
+
+// toCard maps the properties of a vCard onto a user. FN names the user,
+// N only when FN is missing; the first ADR is the postal address.
+func toCard(props []property) Card {
+	var card Card
+	u := &card.User
+	var name, fullName *property
+	var hasAddress bool
+	var phoneRanks, emailRanks []int
+	for i := range props {
+		p := props[i]
+		switch {
+		case ignored[p.name]:
+		case p.name == "FN" && fullName == nil:
+			fullName = &props[i]
+		case p.name == "N" && name == nil:
+			name = &props[i]
+		case p.name == "TEL":
+			number := unescape(p.value)
+			if len(number) > 4 && strings.EqualFold(number[:4], "tel:") {
+				number = number[4:]
+				// Drop URI parameters such as ;ext=.
+				if semi := strings.IndexByte(number, ';'); semi >= 0 {
+					number = number[:semi]
+				}
+			}
+			u.Phones = append(u.Phones, model.PhoneNumber{Number: strings.TrimSpace(number), Type: mapType(p, phoneTypes)})
+			phoneRanks = append(phoneRanks, p.pref())
+		case p.name == "EMAIL":
+			u.Emails = append(u.Emails, model.Email{Address: strings.TrimSpace(unescape(p.value)), Type: mapType(p, emailTypes)})
+			emailRanks = append(emailRanks, p.pref())
+		case p.name == "ADR" && !hasAddress:
+			hasAddress = true
+			c := append(components(p.value), make([]string, 7)...)
+			u.PostalAddress = model.PostalAddress{
+				Street:      joinNonEmpty(", ", c[2], c[1]),
+				City:        strings.TrimSpace(c[3]),
+				Region:      strings.TrimSpace(c[4]),
+				PostalCode:  strings.TrimSpace(c[5]),
+				CountryCode: strings.ToUpper(strings.TrimSpace(c[6])),
+			}
+			if u.PostalAddress.CountryCode != "" && !isCountryCode(u.PostalAddress.CountryCode) {
+				// Country names have no code to map to.
+				u.PostalAddress.CountryCode = ""
+				card.unmap("ADR country")
+			}
+			if strings.TrimSpace(c[0]) != "" {
+				card.unmap("ADR post office box")
+			}
+		default:
+			card.unmap(p.name)
+		}
+	}
+
+	switch {
+	case fullName != nil:
+		u.Name = strings.TrimSpace(unescape(fullName.value))
+	case name != nil:
+		// N is family;given;additional;prefixes;suffixes.
+		c := append(components(name.value), make([]string, 5)...)
+		u.Name = joinNonEmpty(" ", c[3], c[1], c[2], c[0], c[4])
+	}
+	markPrimary(phoneRanks, func(i int) { u.Phones[i].Primary = true })
+	markPrimary(emailRanks, func(i int) { u.Emails[i].Primary = true })
+	return card
+}
+
+// mapType returns the first TYPE of p that maps to a model type.
+func mapType(p property, types map[string]string) string {
+	for _, t := range p.params["TYPE"] {
+		if modelType, ok := types[t]; ok {
+			return modelType
+		}
+	}
+	return ""
+}
+
+// markPrimary marks the most preferred value, the first of equally
+// preferred ones. Values without a preference are never primary.
+func markPrimary(ranks []int, mark func(i int)) {
+	best := -1
+	for i, rank := range ranks {
+		if rank > 0 && (best < 0 || rank < ranks[best]) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		mark(best)
+	}
+}
+
+func joinNonEmpty(sep string, parts ...string) string {
+	nonEmpty := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return strings.Join(nonEmpty, sep)
+}
+
+func isCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
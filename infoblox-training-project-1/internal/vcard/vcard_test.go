@@ -0,0 +1,213 @@
+package vcard_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/vstarostin/infoblox-training-project-1/internal/model"
+	"github.com/vstarostin/infoblox-training-project-1/internal/vcard"
+)
+
+type vcardTestSuite struct {
+	suite.Suite
+	user model.User
+}
+
+func (suite *vcardTestSuite) SetupTest() {
+	suite.user = model.User{
+		Name: "Doe, Jane",
+		Phones: []model.PhoneNumber{
+			{Number: "+12127363100", Type: model.PhoneMobile, Primary: true},
+			{Number: "+12127363101", Type: model.PhoneWork},
+		},
+		Emails: []model.Email{{Address: "jane@example.com", Type: model.EmailHome, Primary: true}},
+		PostalAddress: model.PostalAddress{
+			Street:      "350 5th Avenue; Floor 86",
+			City:        "New York",
+			Region:      "NY",
+			PostalCode:  "10118",
+			CountryCode: "US",
+		},
+	}
+}
+
+func TestVCard(t *testing.T) {
+	suite.Run(t, new(vcardTestSuite))
+}
+
+func (suite *vcardTestSuite) decodeAll(s string) ([]vcard.Card, error) {
+	d := vcard.NewDecoder(strings.NewReader(s))
+	var cards []vcard.Card
+	for {
+		card, err := d.Decode()
+		if err == io.EOF {
+			return cards, nil
+		}
+		if err != nil {
+			return cards, err
+		}
+		cards = append(cards, card)
+	}
+}
+
+func (suite *vcardTestSuite) TestMarshal() {
+	tests := map[string]struct {
+		version  string
+		expected string
+	}{
+		"4.0": {
+			version: vcard.Version4,
+			expected: "BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Doe\\, Jane\r\n" +
+				"TEL;VALUE=uri;TYPE=cell;PREF=1:tel:+12127363100\r\n" +
+				"TEL;VALUE=uri;TYPE=work:tel:+12127363101\r\n" +
+				"EMAIL;TYPE=home;PREF=1:jane@example.com\r\n" +
+				"ADR:;;350 5th Avenue\\; Floor 86;New York;NY;10118;US\r\nEND:VCARD\r\n",
+		},
+		"3.0": {
+			version: vcard.Version3,
+			expected: "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Doe\\, Jane\r\nN:Doe\\, Jane;;;;\r\n" +
+				"TEL;TYPE=cell,pref:+12127363100\r\n" +
+				"TEL;TYPE=work:+12127363101\r\n" +
+				"EMAIL;TYPE=internet,home,pref:jane@example.com\r\n" +
+				"ADR:;;350 5th Avenue\\; Floor 86;New York;NY;10118;US\r\nEND:VCARD\r\n",
+		},
+	}
+	for name, test := range tests {
+		suite.Run(name, func() {
+			data, err := vcard.Marshal(suite.user, test.version)
+			suite.NoError(err)
+			suite.Equal(test.expected, string(data))
+		})
+	}
+}
+
+func (suite *vcardTestSuite) TestMarshalLegacy() {
+	data, err := vcard.Marshal(model.User{Name: "john", Phone: "+78129878899", Address: "moscow"}, vcard.Version4)
+	suite.NoError(err)
+	suite.Equal("BEGIN:VCARD\r\nVERSION:4.0\r\nFN:john\r\nTEL;VALUE=uri;PREF=1:tel:+78129878899\r\nADR:;;moscow;;;;\r\nEND:VCARD\r\n", string(data))
+}
+
+func (suite *vcardTestSuite) TestMarshalFolds() {
+	data, err := vcard.Marshal(model.User{Name: strings.Repeat("ж", 50)}, vcard.Version4)
+	suite.NoError(err)
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\r\n"), "\r\n") {
+		suite.LessOrEqual(len(line), 75)
+	}
+	cards, err := suite.decodeAll(string(data))
+	suite.NoError(err)
+	suite.Equal(strings.Repeat("ж", 50), cards[0].User.Name)
+}
+
+func (suite *vcardTestSuite) TestMarshalUnsupportedVersion() {
+	_, err := vcard.Marshal(suite.user, "2.1")
+	suite.True(errors.Is(err, vcard.ErrUnsupportedVersion))
+}
+
+func (suite *vcardTestSuite) TestRoundTrip() {
+	for _, version := range vcard.Versions {
+		suite.Run(version, func() {
+			data, err := vcard.Marshal(suite.user, version)
+			suite.NoError(err)
+			cards, err := suite.decodeAll(string(data))
+			suite.NoError(err)
+			suite.Equal([]vcard.Card{{User: suite.user}}, cards)
+		})
+	}
+}
+
+func (suite *vcardTestSuite) TestDecode() {
+	input := "BEGIN:VCARD\n" +
+		"VERSION:3.0\n" +
+		"PRODID:-//Apple Inc.//iPhone OS 15.0//EN\n" +
+		"N:Smith;John;;Dr.;\n" +
+		"item1.TEL;type=CELL;type=VOICE:+7 812 987-88-99\n" +
+		"TEL;HOME;PREF:8-812-111-22-33\n" +
+		"TEL;TYPE=FAX:8-812-111-22-34\n" +
+		"EMAIL;TYPE=\"INTERNET,WORK\":john@example.com\n" +
+		"ADR;TYPE=HOME:PO 12;Apt 5;Nevsky prospekt 1;Saint\n" +
+		"  Petersburg;;190000;Russia\n" +
+		"ADR;TYPE=WORK:;;Main st 1;Moscow;;;RU\n" +
+		"BDAY:1980-01-01\n" +
+		"X-ABLabel:friend\n" +
+		"X-ABLabel:colleague\n" +
+		"END:VCARD\n" +
+		"\n" +
+		"BEGIN:VCARD\r\n" +
+		"VERSION:4.0\r\n" +
+		"FN:Jane\r\n" +
+		"N:Doe;Jane;;;\r\n" +
+		"TEL;VALUE=uri;TYPE=\"work,voice\";PREF=2:tel:+1-212-736-3100;ext=5\r\n" +
+		"TEL;VALUE=uri;PREF=1:tel:+1-212-736-3101\r\n" +
+		"ORG:Example\\, Inc.\r\n" +
+		"END:VCARD\r\n"
+
+	cards, err := suite.decodeAll(input)
+	suite.NoError(err)
+	suite.Equal([]vcard.Card{
+		{
+			User: model.User{
+				Name: "Dr. John Smith",
+				Phones: []model.PhoneNumber{
+					{Number: "+7 812 987-88-99", Type: model.PhoneMobile},
+					{Number: "8-812-111-22-33", Type: model.PhoneHome, Primary: true},
+					{Number: "8-812-111-22-34"},
+				},
+				Emails: []model.Email{{Address: "john@example.com", Type: model.EmailWork}},
+				PostalAddress: model.PostalAddress{
+					Street:     "Nevsky prospekt 1, Apt 5",
+					City:       "Saint Petersburg",
+					PostalCode: "190000",
+				},
+			},
+			Unmapped: []string{"ADR country", "ADR post office box", "ADR", "BDAY", "X-ABLABEL"},
+		},
+		{
+			User: model.User{
+				Name: "Jane",
+				Phones: []model.PhoneNumber{
+					{Number: "+1-212-736-3100", Type: model.PhoneWork},
+					{Number: "+1-212-736-3101", Primary: true},
+				},
+			},
+			Unmapped: []string{"ORG"},
+		},
+	}, cards)
+}
+
+func (suite *vcardTestSuite) TestDecodeErrors() {
+	tests := map[string]struct {
+		input    string
+		expected string
+	}{
+		"no_begin": {
+			input:    "VERSION:4.0\nFN:John\nEND:VCARD\n",
+			expected: "line 1: expected BEGIN:VCARD",
+		},
+		"no_end": {
+			input:    "BEGIN:VCARD\nVERSION:4.0\nFN:John\n",
+			expected: "line 3: missing END:VCARD",
+		},
+		"unsupported_version": {
+			input:    "BEGIN:VCARD\nVERSION:2.1\nFN:John\nEND:VCARD\n",
+			expected: `line 2: unsupported vCard version "2.1"`,
+		},
+		"no_colon": {
+			input:    "BEGIN:VCARD\nVERSION:4.0\n\nFN John\nEND:VCARD\n",
+			expected: "line 4: missing : after the property name",
+		},
+		"nested": {
+			input:    "BEGIN:VCARD\nVERSION:4.0\nBEGIN:VCARD\nEND:VCARD\nEND:VCARD\n",
+			expected: "line 3: nested vCards are not supported",
+		},
+	}
+	for name, test := range tests {
+		suite.Run(name, func() {
+			_, err := suite.decodeAll(test.input)
+			suite.EqualError(err, test.expected)
+		})
+	}
+}